@@ -0,0 +1,346 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// A file attached to a task. The content is kept in a BlobStore under the attachment's ID.
+type Attachment struct {
+	Id          string    `json:"id" gorm:"primary_key;type:uuid"`
+	CreatedAt   time.Time `json:"created_at"`
+	TaskId      string    `json:"task_id" gorm:"not_null;type:uuid"`
+	UserId      uint64    `json:"user_id" gorm:"not_null"`
+	Filename    string    `json:"filename" gorm:"not_null"`
+	ContentType string    `json:"content_type" gorm:"not_null"`
+	// Size of the content in bytes
+	Size int64 `json:"size" gorm:"not_null"`
+}
+
+func (attachment *Attachment) BeforeCreate(scope *gorm.Scope) error {
+	if attachment.Id == "" {
+		return scope.SetColumn("Id", newUUID())
+	}
+	return nil
+}
+
+// BlobStore keeps the content of attachments.
+type BlobStore interface {
+	// Stores the content under the key and returns its size in bytes.
+	Put(key string, content io.Reader) (int64, error)
+	// Returns the content stored under the key. Callers must close it.
+	Get(key string) (io.ReadCloser, error)
+	// Removes the content stored under the key. Removing a key that doesn't exist is not an error.
+	Delete(key string) error
+}
+
+// localBlobStore is a BlobStore that keeps each blob in a file in a directory.
+type localBlobStore struct {
+	dir string
+}
+
+// Returns a BlobStore that keeps blobs in dir, creating it if needed.
+func NewLocalBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return localBlobStore{dir}, nil
+}
+
+// Returns the path of the key's file, refusing keys that could point outside the directory.
+func (store localBlobStore) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("Invalid blob key \"%s\"", key)
+	}
+	return filepath.Join(store.dir, key), nil
+}
+
+// Writes the content to a temporary file first, so a failed write never leaves a partial blob.
+func (store localBlobStore) Put(key string, content io.Reader) (int64, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return 0, err
+	}
+	file, err := ioutil.TempFile(store.dir, ".upload-")
+	if err != nil {
+		return 0, err
+	}
+	size, err := io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return 0, err
+	}
+	return size, nil
+}
+
+func (store localBlobStore) Get(key string) (io.ReadCloser, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (store localBlobStore) Delete(key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Maximum size of a single attachment in bytes
+const MaxAttachmentSize = 25 << 20
+
+// The types of files that can be attached, as detected from their content rather than trusted
+// from the client.
+var attachmentContentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// An upload that was refused, along with the HTTP status to respond with. Other errors are
+// failures of the server.
+type attachmentError struct {
+	status  int
+	message string
+}
+
+func (err *attachmentError) Error() string {
+	return err.message
+}
+
+// Returns the error for an attachment that doesn't fit in the quota, given the bytes already used.
+func quotaExceededError(quota int64, used int64) error {
+	return &attachmentError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Attachment would exceed the quota of %d bytes, %d bytes are in use", quota, used)}
+}
+
+// Returns the name to store an uploaded file under, without any directories.
+func attachmentFilename(name string) string {
+	name = strings.TrimSpace(filepath.Base(strings.Replace(name, `\`, "/", -1)))
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// Stores the content as a new attachment of the task with the given filename. Fails without
+// storing anything if the content isn't one of the allowed types or would take the user's
+// attachments over quota bytes in total.
+func UploadAttachment(db Database, blobs BlobStore, quota int64, taskId string, filename string, userId uint64, content io.Reader) (*Attachment, error) {
+	if _, err := db.GetTask(taskId, userId, nil); err == gorm.ErrRecordNotFound {
+		return nil, &attachmentError{http.StatusNotFound, fmt.Sprintf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)}
+	} else if err != nil {
+		return nil, err
+	}
+
+	// DetectContentType looks at no more than the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !attachmentContentTypes[mediaType] {
+		return nil, &attachmentError{http.StatusUnsupportedMediaType, fmt.Sprintf("Files of type \"%s\" can't be attached", contentType)}
+	}
+
+	used, err := db.GetAttachmentUsage(userId)
+	if err != nil {
+		return nil, err
+	}
+	remaining := quota - used
+	if remaining > MaxAttachmentSize {
+		remaining = MaxAttachmentSize
+	}
+
+	attachment := &Attachment{
+		Id:          newUUID(),
+		TaskId:      taskId,
+		Filename:    attachmentFilename(filename),
+		ContentType: contentType,
+	}
+	// Reading one byte more than fits is enough to tell that the content doesn't
+	limited := io.LimitReader(io.MultiReader(bytes.NewReader(head), content), remaining+1)
+	size, err := blobs.Put(attachment.Id, limited)
+	if err != nil {
+		return nil, err
+	}
+	if size > remaining {
+		blobs.Delete(attachment.Id)
+		if remaining == MaxAttachmentSize {
+			return nil, &attachmentError{http.StatusRequestEntityTooLarge, fmt.Sprintf("Attachments can't be larger than %d bytes", MaxAttachmentSize)}
+		}
+		return nil, quotaExceededError(quota, used)
+	}
+
+	attachment.Size = size
+	// Other uploads may have been recorded while the content was being stored, so the quota is
+	// checked again when the attachment is recorded.
+	if err := db.AddAttachment(attachment, quota, userId); err != nil {
+		blobs.Delete(attachment.Id)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// Deletes an attachment along with its content and returns whether it existed.
+func DeleteAttachment(db Database, blobs BlobStore, attachmentId string, userId uint64) (bool, error) {
+	deleted, err := db.DeleteAttachment(attachmentId, userId)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	return true, blobs.Delete(attachmentId)
+}
+
+// Deletes the attachments of tasks that have been purged, along with their content.
+func PurgeOrphanedAttachments(db Database, blobs BlobStore) (int, error) {
+	orphaned, err := db.GetOrphanedAttachments()
+	if err != nil {
+		return 0, err
+	}
+	for i, attachment := range orphaned {
+		if _, err := DeleteAttachment(db, blobs, attachment.Id, attachment.UserId); err != nil {
+			return i, err
+		}
+	}
+	return len(orphaned), nil
+}
+
+// Periodically deletes the attachments of purged tasks. Runs until the process exits.
+func RunAttachmentCleanup(db Database, blobs BlobStore, interval time.Duration) {
+	for {
+		purged, err := PurgeOrphanedAttachments(db, blobs)
+		if err != nil {
+			log.Printf("Error purging attachments: %s", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d attachments of purged tasks", purged)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Serves uploads, downloads and deletion of attachments, authenticated like /graphql:
+//
+//	POST /attachments?task=<task ID>   multipart form with the file in "file", responds with the attachment
+//	GET /attachments/<ID>              responds with the file
+//	DELETE /attachments/<ID>
+//
+// quota limits the total size of each user's attachments in bytes.
+func HandleAttachments(db Database, blobs BlobStore, quota int64) http.Handler {
+	db = WithHistory(db, SourceREST)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := GetBearerToken(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		userId, err := AuthUserId(token)
+		if err != nil {
+			log.Printf("Error verifying token: %s", err.Error())
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/attachments"), "/")
+		switch {
+		case r.Method == "POST" && id == "":
+			serveUpload(db, blobs, quota, userId, w, r)
+		case r.Method == "GET" && id != "":
+			serveDownload(db, blobs, id, userId, w)
+		case r.Method == "DELETE" && id != "":
+			deleted, err := DeleteAttachment(db, blobs, id, userId)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			} else if !deleted {
+				http.NotFound(w, r)
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func serveUpload(db Database, blobs BlobStore, quota int64, userId uint64, w http.ResponseWriter, r *http.Request) {
+	// Leaves room for the rest of the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAttachmentSize+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "The file must be uploaded as \"file\" in a multipart form", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	attachment, err := UploadAttachment(db, blobs, quota, r.URL.Query().Get("task"), header.Filename, userId, file)
+	if refused, ok := err.(*attachmentError); ok {
+		http.Error(w, refused.Error(), refused.status)
+		return
+	} else if err != nil {
+		log.Printf("Error uploading attachment: %s", err)
+		http.Error(w, "Attachment could not be stored", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(attachment)
+}
+
+func serveDownload(db Database, blobs BlobStore, attachmentId string, userId uint64, w http.ResponseWriter) {
+	attachment, err := db.GetAttachment(attachmentId, userId)
+	if err == gorm.ErrRecordNotFound {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error looking up attachment %s: %s", attachmentId, err)
+		http.Error(w, "Attachment is unavailable", http.StatusInternalServerError)
+		return
+	}
+	content, err := blobs.Get(attachment.Id)
+	if err != nil {
+		log.Printf("Error reading attachment %s: %s", attachment.Id, err)
+		http.Error(w, "Attachment content is unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", attachment.Size))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": attachment.Filename}))
+	// Browsers must not second-guess the validated content type
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, content); err != nil {
+		log.Printf("Error writing attachment %s: %s", attachment.Id, err)
+	}
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"fmt"
+)
+
+// One item of an UpdateTasks batch, with the same meaning as the arguments of UpdateTask.
+type TaskUpdate struct {
+	TaskId          string
+	Attrs           map[string]interface{}
+	ExpectedVersion *int
+}
+
+// Runs fn for every item of a batch in one transaction on db. Each item runs in a nested
+// transaction so that a failed item is rolled back on its own, and its error is kept at its index.
+// Every Database implementation, including the wrappers, implements its batch methods with this so
+// that each item goes through the implementation's own single-item method.
+func runBatch(db Database, count int, fn func(tx Database, i int) error) ([]error, error) {
+	errs := make([]error, count)
+	err := db.WithTx(func(tx Database) error {
+		for i := 0; i < count; i++ {
+			errs[i] = tx.WithTx(func(item Database) error {
+				return fn(item, i)
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+func addTasks(db Database, tasks []*Task, userId uint64) ([]error, error) {
+	return runBatch(db, len(tasks), func(tx Database, i int) error {
+		return tx.AddTask(tasks[i], userId)
+	})
+}
+
+func updateTasks(db Database, updates []TaskUpdate, userId uint64) ([]*Task, []error, error) {
+	tasks := make([]*Task, len(updates))
+	errs, err := runBatch(db, len(updates), func(tx Database, i int) error {
+		update := updates[i]
+		var err error
+		tasks[i], err = tx.UpdateTask(update.TaskId, userId, update.Attrs, update.ExpectedVersion)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return tasks, errs, nil
+}
+
+// Unlike DeleteTask, a task that doesn't exist is an error.
+func deleteTasks(db Database, taskIds []string, userId uint64) ([]error, error) {
+	return runBatch(db, len(taskIds), func(tx Database, i int) error {
+		deleted, err := tx.DeleteTask(taskIds[i], userId)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskIds[i], userId)
+		}
+		return nil
+	})
+}
+
+func addActions(db Database, actions []*Action, userId uint64) ([]error, error) {
+	return runBatch(db, len(actions), func(tx Database, i int) error {
+		return tx.AddAction(actions[i], userId)
+	})
+}
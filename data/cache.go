@@ -0,0 +1,308 @@
+package data
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A key-value store for cachingDB. Implementations may evict entries at any time. Values are never
+// modified after they are stored, so they can be kept as-is.
+type Cache interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, value interface{})
+}
+
+// LRUCache is an in-process Cache that holds a fixed number of entries and evicts the least
+// recently used one when full.
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	// Most recently used at the front
+	order *list.List
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (cache *LRUCache) Get(key string) (interface{}, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+func (cache *LRUCache) Set(key string, value interface{}) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*lruEntry).value = value
+		cache.order.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.order.PushFront(&lruEntry{key, value})
+	for cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// cachingDB serves GetUserById, GetTask and GetTasks from a Cache and passes everything else
+// through. Each user's entries are keyed by a generation that every write made for the user
+// replaces, which invalidates all of them at once without the cache having to support deletion.
+type cachingDB struct {
+	Database
+	cache Cache
+	// Users written to in the current transaction. Only set inside WithTx, where reads bypass the
+	// cache since they may see uncommitted changes.
+	touched map[uint64]bool
+}
+
+// Wraps db so that reads are served from cache where possible.
+func WithCache(db Database, cache Cache) Database {
+	return cachingDB{Database: db, cache: cache}
+}
+
+func userGenerationKey(userId uint64) string {
+	return fmt.Sprintf("generation:%d", userId)
+}
+
+// Returns the prefix for the user's current cache entries, starting a new generation if the
+// previous one was evicted.
+func (db cachingDB) userPrefix(userId uint64) string {
+	generation, ok := db.cache.Get(userGenerationKey(userId))
+	if !ok {
+		generation = newUUID()
+		db.cache.Set(userGenerationKey(userId), generation)
+	}
+	return fmt.Sprintf("%d:%s:", userId, generation)
+}
+
+// Discards every cached entry for the user, or defers that until commit inside a transaction.
+func (db cachingDB) invalidate(userId uint64) {
+	if db.touched != nil {
+		db.touched[userId] = true
+		return
+	}
+	db.cache.Set(userGenerationKey(userId), newUUID())
+}
+
+func (db cachingDB) WithTx(fn func(Database) error) error {
+	if db.touched != nil {
+		return db.Database.WithTx(func(tx Database) error {
+			return fn(cachingDB{tx, db.cache, db.touched})
+		})
+	}
+
+	touched := make(map[uint64]bool)
+	defer func() {
+		// Rolled back changes were never visible, but invalidating is harmless
+		for userId := range touched {
+			db.invalidate(userId)
+		}
+	}()
+	return db.Database.WithTx(func(tx Database) error {
+		return fn(cachingDB{tx, db.cache, touched})
+	})
+}
+
+func (db cachingDB) GetUserById(id uint64) (*User, error) {
+	if db.touched != nil {
+		return db.Database.GetUserById(id)
+	}
+	key := db.userPrefix(id) + "user"
+	if cached, ok := db.cache.Get(key); ok {
+		user := cached.(User)
+		return &user, nil
+	}
+	user, err := db.Database.GetUserById(id)
+	if err != nil {
+		return nil, err
+	}
+	db.cache.Set(key, *user)
+	return user, nil
+}
+
+func (db cachingDB) GetTask(taskId string, userId uint64, kind *TaskKind) (*Task, error) {
+	if db.touched != nil {
+		return db.Database.GetTask(taskId, userId, kind)
+	}
+	key := db.userPrefix(userId) + "task:" + taskId
+	if kind != nil {
+		key += fmt.Sprintf(":%d", *kind)
+	}
+	if cached, ok := db.cache.Get(key); ok {
+		task := cached.(Task)
+		return &task, nil
+	}
+	task, err := db.Database.GetTask(taskId, userId, kind)
+	if err != nil {
+		return nil, err
+	}
+	db.cache.Set(key, *task)
+	return task, nil
+}
+
+func (db cachingDB) GetTasks(userId uint64, query TaskQuery) (*TaskPage, error) {
+	if db.touched != nil {
+		return db.Database.GetTasks(userId, query)
+	}
+	encodedQuery, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	key := db.userPrefix(userId) + "tasks:" + string(encodedQuery)
+	if cached, ok := db.cache.Get(key); ok {
+		page := cached.(TaskPage)
+		page.Tasks = append([]Task{}, page.Tasks...)
+		return &page, nil
+	}
+	page, err := db.Database.GetTasks(userId, query)
+	if err != nil {
+		return nil, err
+	}
+	stored := *page
+	stored.Tasks = append([]Task{}, page.Tasks...)
+	db.cache.Set(key, stored)
+	return page, nil
+}
+
+func (db cachingDB) AddTask(task *Task, userId uint64) error {
+	defer db.invalidate(userId)
+	return db.Database.AddTask(task, userId)
+}
+
+func (db cachingDB) DeleteTask(taskId string, userId uint64) (bool, error) {
+	defer db.invalidate(userId)
+	return db.Database.DeleteTask(taskId, userId)
+}
+
+func (db cachingDB) RestoreTask(taskId string, userId uint64) (*Task, error) {
+	defer db.invalidate(userId)
+	return db.Database.RestoreTask(taskId, userId)
+}
+
+func (db cachingDB) PurgeTask(taskId string, userId uint64) (bool, error) {
+	defer db.invalidate(userId)
+	return db.Database.PurgeTask(taskId, userId)
+}
+
+// Purged tasks were already in the trash, and trashed tasks are never cached, but the tasks that
+// referred to them are changed too.
+func (db cachingDB) PurgeDeletedTasks(deletedBefore time.Time) ([]Task, error) {
+	tasks, err := db.Database.PurgeDeletedTasks(deletedBefore)
+	invalidated := make(map[uint64]bool)
+	for _, task := range tasks {
+		if !invalidated[task.UserId] {
+			db.invalidate(task.UserId)
+			invalidated[task.UserId] = true
+		}
+	}
+	return tasks, err
+}
+
+func (db cachingDB) UpdateTask(taskId string, userId uint64, attrs map[string]interface{}, expectedVersion *int) (*Task, error) {
+	defer db.invalidate(userId)
+	return db.Database.UpdateTask(taskId, userId, attrs, expectedVersion)
+}
+
+func (db cachingDB) AddTasks(tasks []*Task, userId uint64) ([]error, error) {
+	return addTasks(db, tasks, userId)
+}
+
+func (db cachingDB) UpdateTasks(updates []TaskUpdate, userId uint64) ([]*Task, []error, error) {
+	return updateTasks(db, updates, userId)
+}
+
+func (db cachingDB) DeleteTasks(taskIds []string, userId uint64) ([]error, error) {
+	return deleteTasks(db, taskIds, userId)
+}
+
+func (db cachingDB) AddActions(actions []*Action, userId uint64) ([]error, error) {
+	return addActions(db, actions, userId)
+}
+
+func (db cachingDB) CreateUser(username string, password string) (*User, error) {
+	user, err := db.Database.CreateUser(username, password)
+	if err == nil {
+		db.invalidate(user.Id)
+	}
+	return user, err
+}
+
+func (db cachingDB) AddAction(action *Action, userId uint64) error {
+	defer db.invalidate(userId)
+	return db.Database.AddAction(action, userId)
+}
+
+func (db cachingDB) DeleteAction(id string, userId uint64) error {
+	defer db.invalidate(userId)
+	return db.Database.DeleteAction(id, userId)
+}
+
+func (db cachingDB) AddHistory(entry *HistoryEntry) error {
+	defer db.invalidate(entry.UserId)
+	return db.Database.AddHistory(entry)
+}
+
+// Projects aren't cached, but deleting one moves its tasks out of it.
+func (db cachingDB) DeleteProject(projectId string, userId uint64) (bool, error) {
+	defer db.invalidate(userId)
+	return db.Database.DeleteProject(projectId, userId)
+}
+
+func (db cachingDB) AddTag(taskId string, name string, userId uint64) (*Tag, error) {
+	defer db.invalidate(userId)
+	return db.Database.AddTag(taskId, name, userId)
+}
+
+func (db cachingDB) RemoveTag(taskId string, name string, userId uint64) (bool, error) {
+	defer db.invalidate(userId)
+	return db.Database.RemoveTag(taskId, name, userId)
+}
+
+func (db cachingDB) RenameTag(tagId string, name string, userId uint64) (*Tag, error) {
+	defer db.invalidate(userId)
+	return db.Database.RenameTag(tagId, name, userId)
+}
+
+func (db cachingDB) MergeTags(sourceId string, targetId string, userId uint64) (*Tag, error) {
+	defer db.invalidate(userId)
+	return db.Database.MergeTags(sourceId, targetId, userId)
+}
+
+func (db cachingDB) DeleteTag(tagId string, userId uint64) (bool, error) {
+	defer db.invalidate(userId)
+	return db.Database.DeleteTag(tagId, userId)
+}
+
+// Dependencies aren't cached, but tasks are listed by whether they are blocked.
+func (db cachingDB) AddDependency(taskId string, blockedById string, userId uint64) error {
+	defer db.invalidate(userId)
+	return db.Database.AddDependency(taskId, blockedById, userId)
+}
+
+func (db cachingDB) RemoveDependency(taskId string, blockedById string, userId uint64) (bool, error) {
+	defer db.invalidate(userId)
+	return db.Database.RemoveDependency(taskId, blockedById, userId)
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"testing"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewLRUCache(2)
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Get("a")
+	cache.Set("c", 3)
+
+	tests := []struct {
+		key   string
+		value interface{}
+		ok    bool
+	}{
+		{"a", 1, true},
+		{"b", nil, false},
+		{"c", 3, true},
+	}
+	for _, test := range tests {
+		if value, ok := cache.Get(test.key); value != test.value || ok != test.ok {
+			t.Errorf("Get(%q) = %v, %v, want %v, %v", test.key, value, ok, test.value, test.ok)
+		}
+	}
+
+	cache.Set("a", 4)
+	if value, _ := cache.Get("a"); value != 4 {
+		t.Errorf("Get(\"a\") = %v after replacing it, want 4", value)
+	}
+}
+
+// Counts the calls to GetTask that reach the database.
+type countingTaskDB struct {
+	Database
+	calls *int
+}
+
+func (db countingTaskDB) GetTask(taskId string, userId uint64, kind *TaskKind) (*Task, error) {
+	*db.calls++
+	return db.Database.GetTask(taskId, userId, kind)
+}
+
+func (db countingTaskDB) WithTx(fn func(Database) error) error {
+	return db.Database.WithTx(func(tx Database) error {
+		return fn(countingTaskDB{tx, db.calls})
+	})
+}
+
+// Gets the task and fails the test unless it has the title and took the given number of calls.
+func checkCachedTask(t *testing.T, db Database, calls *int, taskId string, userId uint64, title string, wantCalls int) {
+	*calls = 0
+	task, err := db.GetTask(taskId, userId, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Title != title {
+		t.Errorf("GetTask returned title %q, want %q", task.Title, title)
+	}
+	if *calls != wantCalls {
+		t.Errorf("GetTask made %d calls to the database, want %d", *calls, wantCalls)
+	}
+}
+
+func TestCachingDBInvalidatesOnWrites(t *testing.T) {
+	calls := 0
+	db := WithCache(countingTaskDB{NewMemoryDatabase(), &calls}, NewLRUCache(100))
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := db.CreateUser("other", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Title: "before"}
+	addTestTasks(t, db, user.Id, task)
+	otherTask := &Task{Title: "other"}
+	addTestTasks(t, db, other.Id, otherTask)
+
+	checkCachedTask(t, db, &calls, task.Id, user.Id, "before", 1)
+	checkCachedTask(t, db, &calls, task.Id, user.Id, "before", 0)
+	checkCachedTask(t, db, &calls, otherTask.Id, other.Id, "other", 1)
+
+	if _, err := db.UpdateTask(task.Id, user.Id, map[string]interface{}{"title": "after"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkCachedTask(t, db, &calls, task.Id, user.Id, "after", 1)
+	// Other users' entries are kept
+	checkCachedTask(t, db, &calls, otherTask.Id, other.Id, "other", 0)
+
+	// Cached copies can't be changed by callers
+	cached, err := db.GetTask(task.Id, user.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.Title = "changed"
+	checkCachedTask(t, db, &calls, task.Id, user.Id, "after", 0)
+}
+
+func TestCachingDBInTransactions(t *testing.T) {
+	calls := 0
+	db := WithCache(countingTaskDB{NewMemoryDatabase(), &calls}, NewLRUCache(100))
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Title: "before"}
+	addTestTasks(t, db, user.Id, task)
+	checkCachedTask(t, db, &calls, task.Id, user.Id, "before", 1)
+
+	err = db.WithTx(func(tx Database) error {
+		if _, err := tx.UpdateTask(task.Id, user.Id, map[string]interface{}{"title": "after"}, nil); err != nil {
+			return err
+		}
+		// Reads inside the transaction see its changes, and other reads don't until it commits
+		checkCachedTask(t, tx, &calls, task.Id, user.Id, "after", 1)
+		checkCachedTask(t, db, &calls, task.Id, user.Id, "before", 0)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkCachedTask(t, db, &calls, task.Id, user.Id, "after", 1)
+}
@@ -0,0 +1,624 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// Announces a change to one of a user's tasks, habits or actions.
+type ChangeEvent struct {
+	UserId    uint64           `json:"user_id"`
+	Operation HistoryOperation `json:"operation"`
+	// Not set for delete_action, since only the action's ID is known
+	TaskId string `json:"task_id,omitempty"`
+	// Only set for add_action and delete_action
+	ActionId string    `json:"action_id,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Broker delivers change events to the subscribers of the user they belong to.
+type Broker interface {
+	Publish(event ChangeEvent) error
+	// Returns a channel of the user's events and a function that ends the subscription and
+	// closes the channel.
+	Subscribe(userId uint64) (<-chan ChangeEvent, func())
+	// Reports whether the user's events may have subscribers, so finding out what to publish can
+	// be skipped when nobody is listening.
+	HasSubscribers(userId uint64) bool
+	Close() error
+}
+
+// Events are dropped rather than blocking the publisher when a subscriber falls this far behind.
+const subscriberBufferSize = 64
+
+// memoryBroker is a Broker that only delivers events within the process.
+type memoryBroker struct {
+	mu          sync.Mutex
+	subscribers map[uint64]map[chan ChangeEvent]bool
+}
+
+func NewMemoryBroker() Broker {
+	return &memoryBroker{
+		subscribers: make(map[uint64]map[chan ChangeEvent]bool),
+	}
+}
+
+func (broker *memoryBroker) Publish(event ChangeEvent) error {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	for subscriber := range broker.subscribers[event.UserId] {
+		select {
+		case subscriber <- event:
+		default:
+			log.Printf("Dropped %s event for task \"%s\", subscriber of user %d is full", event.Operation, event.TaskId, event.UserId)
+		}
+	}
+	return nil
+}
+
+func (broker *memoryBroker) Subscribe(userId uint64) (<-chan ChangeEvent, func()) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	subscriber := make(chan ChangeEvent, subscriberBufferSize)
+	if broker.subscribers[userId] == nil {
+		broker.subscribers[userId] = make(map[chan ChangeEvent]bool)
+	}
+	broker.subscribers[userId][subscriber] = true
+
+	var once sync.Once
+	return subscriber, func() {
+		once.Do(func() {
+			broker.mu.Lock()
+			defer broker.mu.Unlock()
+
+			delete(broker.subscribers[userId], subscriber)
+			if len(broker.subscribers[userId]) == 0 {
+				delete(broker.subscribers, userId)
+			}
+			close(subscriber)
+		})
+	}
+}
+
+func (broker *memoryBroker) HasSubscribers(userId uint64) bool {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	return len(broker.subscribers[userId]) > 0
+}
+
+func (broker *memoryBroker) Close() error {
+	return nil
+}
+
+const changeChannel = "duet_changes"
+
+// postgresBroker is a Broker that sends events through Postgres NOTIFY, so subscribers in every
+// duet instance connected to the database receive them.
+type postgresBroker struct {
+	db       *sql.DB
+	listener *pq.Listener
+	// Delivers the events received from Postgres to this instance's subscribers
+	local Broker
+}
+
+func NewPostgresBroker(host string, user string, dbName string) (Broker, error) {
+	source, err := dataSource("postgres", host, user, dbName)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", source)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := pq.NewListener(source, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Change feed listener error: %s", err)
+		}
+	})
+	if err := listener.Listen(changeChannel); err != nil {
+		listener.Close()
+		db.Close()
+		return nil, err
+	}
+
+	broker := &postgresBroker{
+		db:       db,
+		listener: listener,
+		local:    NewMemoryBroker(),
+	}
+	go broker.receive()
+	return broker, nil
+}
+
+func (broker *postgresBroker) receive() {
+	for notification := range broker.listener.Notify {
+		// nil after the listener reconnects, when events may have been missed
+		if notification == nil {
+			continue
+		}
+		var event ChangeEvent
+		if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+			log.Printf("Ignoring malformed change event \"%s\": %s", notification.Extra, err)
+			continue
+		}
+		broker.local.Publish(event)
+	}
+}
+
+func (broker *postgresBroker) Publish(event ChangeEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = broker.db.Exec("SELECT pg_notify($1, $2)", changeChannel, string(payload))
+	return err
+}
+
+func (broker *postgresBroker) Subscribe(userId uint64) (<-chan ChangeEvent, func()) {
+	return broker.local.Subscribe(userId)
+}
+
+// Subscribers in other instances can't be seen from here, so there may always be some.
+func (broker *postgresBroker) HasSubscribers(userId uint64) bool {
+	return true
+}
+
+func (broker *postgresBroker) Close() error {
+	if err := broker.listener.Close(); err != nil {
+		return err
+	}
+	return broker.db.Close()
+}
+
+// changesDB publishes an event for every change made through it. Changes made in a transaction
+// are published once it commits, and not at all if it rolls back.
+type changesDB struct {
+	Database
+	broker Broker
+	// Events waiting for the current transaction to commit. Only set inside WithTx.
+	pending *[]ChangeEvent
+}
+
+// Wraps db so that its changes are published to broker. Changes to a user without subscribers are
+// passed straight through. Tasks purged by trash retention are not announced, since they were
+// announced when they were deleted.
+func WithChanges(db Database, broker Broker) Database {
+	return changesDB{Database: db, broker: broker}
+}
+
+func (db changesDB) publish(userId uint64, operation HistoryOperation, taskId string, actionId string) {
+	event := ChangeEvent{
+		UserId:    userId,
+		Operation: operation,
+		TaskId:    taskId,
+		ActionId:  actionId,
+		Time:      time.Now().UTC(),
+	}
+	if db.pending != nil {
+		*db.pending = append(*db.pending, event)
+		return
+	}
+	db.send(event)
+}
+
+func (db changesDB) send(event ChangeEvent) {
+	// The change itself succeeded, so failing to announce it is only logged
+	if err := db.broker.Publish(event); err != nil {
+		log.Printf("Error publishing %s event for task \"%s\": %s", event.Operation, event.TaskId, err)
+	}
+}
+
+// Publishes an update for each of the given tasks, as they were before a change, that the change
+// cascaded to.
+func (db changesDB) publishCascade(before []Task, userId uint64) {
+	updates, err := cascadedUpdates(db.Database, before, userId)
+	if err != nil {
+		log.Printf("Error finding the tasks updated along with another: %s", err)
+		return
+	}
+	for _, update := range updates {
+		db.publish(userId, OperationUpdate, update.after.Id, "")
+		db.publishOccurrence(update.before, update.after, userId)
+	}
+}
+
+// Publishes the creation of the next occurrence of a recurring task if a change completed it.
+func (db changesDB) publishOccurrence(before *Task, after *Task, userId uint64) {
+	occurrence, err := generatedOccurrence(db.Database, before, after, userId)
+	if err != nil {
+		log.Printf("Error finding the next occurrence of task \"%s\": %s", after.Id, err)
+		return
+	}
+	if occurrence != nil {
+		db.publish(userId, OperationCreate, occurrence.Id, "")
+	}
+}
+
+// Returns the tasks that a change to the given tasks may unblock, which are those blocked by them
+// that are blocked before it. Failing to find them only means no unblock events are published.
+func (db changesDB) blockedBy(taskIds []string, userId uint64) []Task {
+	blocked, err := blockedDependents(db.Database, taskIds, userId)
+	if err != nil {
+		log.Printf("Error finding the tasks blocked by another: %s", err)
+		return nil
+	}
+	return blocked
+}
+
+// Publishes an unblock event for each of the given tasks, blocked before a change, that the change
+// unblocked.
+func (db changesDB) publishUnblocked(blocked []Task, userId uint64) {
+	unblocked, err := unblockedTasks(db.Database, blocked, userId)
+	if err != nil {
+		log.Printf("Error finding the tasks unblocked by a change: %s", err)
+		return
+	}
+	for _, task := range unblocked {
+		db.publish(userId, OperationUnblock, task.Id, "")
+	}
+}
+
+func (db changesDB) WithTx(fn func(Database) error) error {
+	pending := []ChangeEvent{}
+	err := db.Database.WithTx(func(tx Database) error {
+		return fn(changesDB{tx, db.broker, &pending})
+	})
+	if err != nil {
+		return err
+	}
+	// A nested transaction's events wait for the outer one
+	if db.pending != nil {
+		*db.pending = append(*db.pending, pending...)
+		return nil
+	}
+	for _, event := range pending {
+		db.send(event)
+	}
+	return nil
+}
+
+// Runs fn in a transaction, so that what it looks up before and after a change is consistent with
+// the change, and its events are only published if it commits.
+func (db changesDB) inTx(fn func(tx changesDB) error) error {
+	return db.WithTx(func(tx Database) error {
+		return fn(tx.(changesDB))
+	})
+}
+
+func (db changesDB) AddTask(task *Task, userId uint64) error {
+	if !db.broker.HasSubscribers(userId) {
+		return db.Database.AddTask(task, userId)
+	}
+	return db.inTx(func(tx changesDB) error {
+		ancestors, err := ancestorsOf(tx.Database, userId, task.ParentId)
+		if err != nil {
+			return err
+		}
+		if err := tx.Database.AddTask(task, userId); err != nil {
+			return err
+		}
+		tx.publish(userId, OperationCreate, task.Id, "")
+		tx.publishCascade(ancestors, userId)
+		return nil
+	})
+}
+
+func (db changesDB) UpdateTask(taskId string, userId uint64, attrs map[string]interface{}, expectedVersion *int) (*Task, error) {
+	if !db.broker.HasSubscribers(userId) {
+		return db.Database.UpdateTask(taskId, userId, attrs, expectedVersion)
+	}
+	var task *Task
+	err := db.inTx(func(tx changesDB) error {
+		var ancestors []Task
+		before, err := tx.Database.GetTask(taskId, userId, nil)
+		if err == nil {
+			parentId, _ := attrs["parent_id"].(*string)
+			if ancestors, err = ancestorsOf(tx.Database, userId, before.ParentId, parentId); err != nil {
+				return err
+			}
+		}
+		// Completing the task may also complete its ancestors, either of which may unblock others
+		blocked := tx.blockedBy(append([]string{taskId}, idsOfTasks(ancestors)...), userId)
+		if task, err = tx.Database.UpdateTask(taskId, userId, attrs, expectedVersion); err != nil {
+			return err
+		}
+		tx.publish(userId, OperationUpdate, taskId, "")
+		tx.publishOccurrence(before, task, userId)
+		tx.publishCascade(ancestors, userId)
+		tx.publishUnblocked(blocked, userId)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (db changesDB) AddTasks(tasks []*Task, userId uint64) ([]error, error) {
+	return addTasks(db, tasks, userId)
+}
+
+func (db changesDB) UpdateTasks(updates []TaskUpdate, userId uint64) ([]*Task, []error, error) {
+	return updateTasks(db, updates, userId)
+}
+
+func (db changesDB) DeleteTasks(taskIds []string, userId uint64) ([]error, error) {
+	return deleteTasks(db, taskIds, userId)
+}
+
+func (db changesDB) AddActions(actions []*Action, userId uint64) ([]error, error) {
+	return addActions(db, actions, userId)
+}
+
+func (db changesDB) DeleteTask(taskId string, userId uint64) (bool, error) {
+	if !db.broker.HasSubscribers(userId) {
+		return db.Database.DeleteTask(taskId, userId)
+	}
+	deleted := false
+	err := db.inTx(func(tx changesDB) error {
+		var descendants, ancestors []Task
+		if task, err := tx.Database.GetTask(taskId, userId, nil); err == nil {
+			if descendants, err = liveDescendants(tx.Database, taskId, userId); err != nil {
+				return err
+			}
+			if ancestors, err = ancestorsOf(tx.Database, userId, task.ParentId); err != nil {
+				return err
+			}
+		}
+		deletedIds := append([]string{taskId}, idsOfTasks(descendants)...)
+		// Tasks in the trash no longer block anything
+		blocked := tx.blockedBy(append(deletedIds, idsOfTasks(ancestors)...), userId)
+		var err error
+		if deleted, err = tx.Database.DeleteTask(taskId, userId); err != nil || !deleted {
+			return err
+		}
+		for _, id := range deletedIds {
+			tx.publish(userId, OperationDelete, id, "")
+		}
+		tx.publishCascade(ancestors, userId)
+		tx.publishUnblocked(blocked, userId)
+		return nil
+	})
+	return deleted, err
+}
+
+func (db changesDB) RestoreTask(taskId string, userId uint64) (*Task, error) {
+	if !db.broker.HasSubscribers(userId) {
+		return db.Database.RestoreTask(taskId, userId)
+	}
+	var task *Task
+	err := db.inTx(func(tx changesDB) error {
+		descendants, err := trashedDescendants(tx.Database, taskId, userId)
+		if err != nil {
+			return err
+		}
+		if task, err = tx.Database.RestoreTask(taskId, userId); err != nil {
+			return err
+		}
+		for _, id := range append([]string{taskId}, idsOfTasks(descendants)...) {
+			tx.publish(userId, OperationRestore, id, "")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (db changesDB) PurgeTask(taskId string, userId uint64) (bool, error) {
+	if !db.broker.HasSubscribers(userId) {
+		return db.Database.PurgeTask(taskId, userId)
+	}
+	purged := false
+	err := db.inTx(func(tx changesDB) error {
+		descendants, err := trashedDescendants(tx.Database, taskId, userId)
+		if err != nil {
+			return err
+		}
+		if purged, err = tx.Database.PurgeTask(taskId, userId); err != nil || !purged {
+			return err
+		}
+		for _, id := range append([]string{taskId}, idsOfTasks(descendants)...) {
+			tx.publish(userId, OperationPurge, id, "")
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// Also publishes the completion of a recurring task that a done action caused, and the tasks that
+// completing it unblocked.
+func (db changesDB) AddAction(action *Action, userId uint64) error {
+	if !db.broker.HasSubscribers(userId) {
+		return db.Database.AddAction(action, userId)
+	}
+	return db.inTx(func(tx changesDB) error {
+		var before []Task
+		if task, err := tx.Database.GetTask(action.TaskId, userId, nil); err == nil {
+			ancestors, err := ancestorsOf(tx.Database, userId, task.ParentId)
+			if err != nil {
+				return err
+			}
+			before = append([]Task{*task}, ancestors...)
+		}
+		blocked := tx.blockedBy(idsOfTasks(before), userId)
+		if err := tx.Database.AddAction(action, userId); err != nil {
+			return err
+		}
+		tx.publish(userId, OperationAddAction, action.TaskId, action.Id)
+		tx.publishCascade(before, userId)
+		tx.publishUnblocked(blocked, userId)
+		return nil
+	})
+}
+
+func (db changesDB) DeleteAction(id string, userId uint64) error {
+	if err := db.Database.DeleteAction(id, userId); err != nil {
+		return err
+	}
+	db.publish(userId, OperationDeleteAction, "", id)
+	return nil
+}
+
+// Announces the tasks and habits that deleting the project moved out of it.
+func (db changesDB) DeleteProject(projectId string, userId uint64) (bool, error) {
+	if !db.broker.HasSubscribers(userId) {
+		return db.Database.DeleteProject(projectId, userId)
+	}
+	deleted := false
+	err := db.inTx(func(tx changesDB) error {
+		page, err := tx.Database.GetTasks(userId, TaskQuery{ProjectId: &projectId})
+		if err != nil {
+			return err
+		}
+		if deleted, err = tx.Database.DeleteProject(projectId, userId); err != nil || !deleted {
+			return err
+		}
+		tx.publishCascade(page.Tasks, userId)
+		return nil
+	})
+	return deleted, err
+}
+
+func (db changesDB) AddTag(taskId string, name string, userId uint64) (*Tag, error) {
+	tag, err := db.Database.AddTag(taskId, name, userId)
+	if err != nil {
+		return nil, err
+	}
+	db.publish(userId, OperationAddTag, taskId, "")
+	return tag, nil
+}
+
+func (db changesDB) RemoveTag(taskId string, name string, userId uint64) (bool, error) {
+	removed, err := db.Database.RemoveTag(taskId, name, userId)
+	if err != nil || !removed {
+		return removed, err
+	}
+	db.publish(userId, OperationRemoveTag, taskId, "")
+	return true, nil
+}
+
+// Publishes an update for each task that has the tag, as of before change, if change reports that
+// it changed the tag.
+func (db changesDB) publishTagged(tagId string, userId uint64, change func(tx changesDB) (bool, error)) error {
+	if !db.broker.HasSubscribers(userId) {
+		_, err := change(db)
+		return err
+	}
+	return db.inTx(func(tx changesDB) error {
+		_, taskIds, err := taggedTasks(tx.Database, tagId, userId)
+		if err != nil {
+			return err
+		}
+		if changed, err := change(tx); err != nil || !changed {
+			return err
+		}
+		for _, taskId := range taskIds {
+			tx.publish(userId, OperationUpdate, taskId, "")
+		}
+		return nil
+	})
+}
+
+// Changes to a tag itself are announced as updates to everything with the tag.
+func (db changesDB) RenameTag(tagId string, name string, userId uint64) (*Tag, error) {
+	var tag *Tag
+	err := db.publishTagged(tagId, userId, func(tx changesDB) (bool, error) {
+		var err error
+		tag, err = tx.Database.RenameTag(tagId, name, userId)
+		return err == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (db changesDB) MergeTags(sourceId string, targetId string, userId uint64) (*Tag, error) {
+	var tag *Tag
+	err := db.publishTagged(sourceId, userId, func(tx changesDB) (bool, error) {
+		var err error
+		tag, err = tx.Database.MergeTags(sourceId, targetId, userId)
+		return err == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (db changesDB) DeleteTag(tagId string, userId uint64) (bool, error) {
+	deleted := false
+	err := db.publishTagged(tagId, userId, func(tx changesDB) (bool, error) {
+		var err error
+		deleted, err = tx.Database.DeleteTag(tagId, userId)
+		return deleted, err
+	})
+	return deleted, err
+}
+
+func (db changesDB) AddAttachment(attachment *Attachment, quota int64, userId uint64) error {
+	if err := db.Database.AddAttachment(attachment, quota, userId); err != nil {
+		return err
+	}
+	db.publish(userId, OperationAddAttachment, attachment.TaskId, "")
+	return nil
+}
+
+func (db changesDB) DeleteAttachment(attachmentId string, userId uint64) (bool, error) {
+	if !db.broker.HasSubscribers(userId) {
+		return db.Database.DeleteAttachment(attachmentId, userId)
+	}
+	deleted := false
+	err := db.inTx(func(tx changesDB) error {
+		attachment, err := tx.Database.GetAttachment(attachmentId, userId)
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if deleted, err = tx.Database.DeleteAttachment(attachmentId, userId); err != nil || !deleted {
+			return err
+		}
+		tx.publish(userId, OperationRemoveAttachment, attachment.TaskId, "")
+		return nil
+	})
+	return deleted, err
+}
+
+func (db changesDB) AddDependency(taskId string, blockedById string, userId uint64) error {
+	if err := db.Database.AddDependency(taskId, blockedById, userId); err != nil {
+		return err
+	}
+	db.publish(userId, OperationAddDependency, taskId, "")
+	return nil
+}
+
+func (db changesDB) RemoveDependency(taskId string, blockedById string, userId uint64) (bool, error) {
+	if !db.broker.HasSubscribers(userId) {
+		return db.Database.RemoveDependency(taskId, blockedById, userId)
+	}
+	removed := false
+	err := db.inTx(func(tx changesDB) error {
+		blocked := tx.blockedBy([]string{blockedById}, userId)
+		var err error
+		if removed, err = tx.Database.RemoveDependency(taskId, blockedById, userId); err != nil || !removed {
+			return err
+		}
+		tx.publish(userId, OperationRemoveDependency, taskId, "")
+		tx.publishUnblocked(blocked, userId)
+		return nil
+	})
+	return removed, err
+}
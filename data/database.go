@@ -1,27 +1,117 @@
 package data
 
 import (
+	"database/sql"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Database interface {
 	Close() error
+	// Runs fn in a transaction. Changes fn makes through the Database it is given are committed
+	// if it returns nil and rolled back otherwise. Calls nested in another transaction only roll
+	// back their own changes.
+	WithTx(fn func(Database) error) error
 	GetTask(taskId string, userId uint64, kind *TaskKind) (*Task, error)
-	GetTasks(userId uint64, kind *TaskKind) ([]Task, error)
+	GetTasks(userId uint64, query TaskQuery) (*TaskPage, error)
 	AddTask(task *Task, userId uint64) error
 	DeleteTask(taskId string, userId uint64) (bool, error)
-	UpdateTask(taskId string, userId uint64, attrs map[string]interface{}) (*Task, error)
+	GetDeletedTasks(userId uint64) ([]Task, error)
+	RestoreTask(taskId string, userId uint64) (*Task, error)
+	PurgeTask(taskId string, userId uint64) (bool, error)
+	// Permanently deletes every task of every user that was moved to the trash before the given
+	// time and returns them as they were.
+	PurgeDeletedTasks(deletedBefore time.Time) ([]Task, error)
+	// Updates a task with the given attributes. If expectedVersion is set and the task has since
+	// been modified, nothing is changed and a *ConflictError is returned.
+	UpdateTask(taskId string, userId uint64, attrs map[string]interface{}, expectedVersion *int) (*Task, error)
+	// The batch methods apply every item in one transaction. An item that fails has no effect and
+	// its error is returned at its index, while the other items still apply. The final error is
+	// only set if the transaction as a whole failed.
+	AddTasks(tasks []*Task, userId uint64) ([]error, error)
+	UpdateTasks(updates []TaskUpdate, userId uint64) ([]*Task, []error, error)
+	DeleteTasks(taskIds []string, userId uint64) ([]error, error)
+	AddActions(actions []*Action, userId uint64) ([]error, error)
 	CreateUser(username string, password string) (*User, error)
 	GetUserById(id uint64) (*User, error)
 	GetUserByUsername(username string) (*User, error)
 	AddAction(action *Action, userId uint64) error
 	DeleteAction(id string, userId uint64) error
+	// Returns the action if it belongs to one of the user's tasks, including tasks in the trash.
+	GetAction(id string, userId uint64) (*Action, error)
+	// Returns the actions of each of the given tasks that belong to the user, keyed by task ID.
+	// Tasks are returned without their actions, so this is the only way to load them.
+	GetActions(taskIds []string, userId uint64) (map[string][]Action, error)
+	AddHistory(entry *HistoryEntry) error
+	GetHistory(taskId string, userId uint64) ([]HistoryEntry, error)
+	GetProject(projectId string, userId uint64) (*Project, error)
+	// Returns the user's projects in order. If archived is set, only projects that are or aren't
+	// archived are returned.
+	GetProjects(userId uint64, archived *bool) ([]Project, error)
+	AddProject(project *Project, userId uint64) error
+	UpdateProject(projectId string, userId uint64, attrs map[string]interface{}) (*Project, error)
+	// Deletes a project and moves its tasks and habits out of it.
+	DeleteProject(projectId string, userId uint64) (bool, error)
+	// Returns the user's tags by name.
+	GetTags(userId uint64) ([]Tag, error)
+	// Returns the tags of each of the given tasks that belong to the user, keyed by task ID.
+	GetTaskTags(taskIds []string, userId uint64) (map[string][]Tag, error)
+	// Tags a task or habit with the user's tag of the given name, creating the tag if needed.
+	// Tagging it again has no effect.
+	AddTag(taskId string, name string, userId uint64) (*Tag, error)
+	// Removes the tag of the given name from a task or habit and returns whether it had the tag.
+	RemoveTag(taskId string, name string, userId uint64) (bool, error)
+	// Renames a tag everywhere it is used. Fails if the user has another tag with the name.
+	RenameTag(tagId string, name string, userId uint64) (*Tag, error)
+	// Moves everything tagged with the source tag to the target tag, deletes the source tag and
+	// returns the target.
+	MergeTags(sourceId string, targetId string, userId uint64) (*Tag, error)
+	// Deletes a tag and removes it from everything that had it.
+	DeleteTag(tagId string, userId uint64) (bool, error)
+	GetAttachment(attachmentId string, userId uint64) (*Attachment, error)
+	// Returns the attachments of each of the given tasks that belong to the user, keyed by task ID.
+	GetAttachments(taskIds []string, userId uint64) (map[string][]Attachment, error)
+	// Records an attachment whose content has already been stored. Fails without recording it if
+	// it would take the user's attachments over quota bytes in total.
+	AddAttachment(attachment *Attachment, quota int64, userId uint64) error
+	// Deletes the record of an attachment, but not its content, and returns whether it existed.
+	DeleteAttachment(attachmentId string, userId uint64) (bool, error)
+	// Returns the total size in bytes of the user's attachments.
+	GetAttachmentUsage(userId uint64) (int64, error)
+	// Returns the attachments of every user whose task has been purged.
+	GetOrphanedAttachments() ([]Attachment, error)
+	// Returns the reminders of each of the given tasks that belong to the user, keyed by task ID.
+	GetReminders(taskIds []string, userId uint64) (map[string][]Reminder, error)
+	// Adds a reminder to a task or habit and sets when it is first due.
+	AddReminder(reminder *Reminder, userId uint64) error
+	DeleteReminder(reminderId string, userId uint64) (bool, error)
+	// Returns up to limit reminders of every user that are due by the given time, earliest first.
+	GetDueReminders(now time.Time, limit int) ([]Reminder, error)
+	// Marks a reminder that is due at fireAt as fired and sets when it is next due. Returns false
+	// without changing anything if it is no longer due at fireAt, such as when it has already been
+	// claimed.
+	ClaimReminder(reminderId string, fireAt time.Time, next *time.Time) (bool, error)
+	// Returns the user's running timers on tasks and habits that aren't in the trash, oldest first.
+	GetRunningTimers(userId uint64) ([]Timer, error)
+	// Returns the running timers of every user that were started before the given time.
+	GetForgottenTimers(startedBefore time.Time) ([]Timer, error)
+	// Returns the live tasks that each of the given tasks is blocked by, keyed by task ID and
+	// oldest first.
+	GetBlockers(taskIds []string, userId uint64) (map[string][]Task, error)
+	// Returns the live tasks blocked by each of the given tasks, keyed by task ID and oldest first.
+	GetDependents(taskIds []string, userId uint64) (map[string][]Task, error)
+	// Blocks a task by another task until that one is done. Fails if the other task is already
+	// blocked by the task, directly or through other tasks. Adding it again has no effect.
+	AddDependency(taskId string, blockedById string, userId uint64) error
+	// Removes a dependency between two tasks and returns whether it existed.
+	RemoveDependency(taskId string, blockedById string, userId uint64) (bool, error)
 }
 
 type gormDB struct {
@@ -45,18 +135,32 @@ const (
 
 type Task struct {
 	// Common fields
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	DeletedAt *time.Time
-	Id        string   `json:"id" gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
-	Kind      TaskKind `json:"kind" gorm:"not_null"`
-	Title     string   `json:"title" gorm:"not_null"`
-	Done      bool     `json:"done" gorm:"not_null;default:false"`
-	UserId    uint64   `json:"user_id" gorm:"not_null"`
-	Actions   []Action `json:"actions" gorm:"ForeignKey:TaskId"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	Id        string     `json:"id" gorm:"primary_key;type:uuid"`
+	Kind      TaskKind   `json:"kind" gorm:"not_null"`
+	Title     string     `json:"title" gorm:"not_null"`
+	Done      bool       `json:"done" gorm:"not_null;default:false"`
+	UserId    uint64     `json:"user_id" gorm:"not_null"`
+	Version   int        `json:"version" gorm:"not_null;default:1"`
+	Actions   []Action   `json:"actions" gorm:"ForeignKey:TaskId"`
+	ProjectId *string    `json:"project_id" gorm:"type:uuid"`
+	// Long-form description in Markdown
+	Notes string `json:"notes" gorm:"type:text;not_null;default:''"`
+	// Rank of the task in the user's manual order, see rankBetween
+	Position string `json:"position" gorm:"not_null;default:''"`
 	// Task Fields
 	StartDate *time.Time `json:"start_date"`
 	EndDate   *time.Time `json:"end_date"`
+	ParentId  *string    `json:"parent_id" gorm:"type:uuid"`
+	// Whether the task is marked done once all its subtasks are
+	AutoComplete bool     `json:"auto_complete" gorm:"not_null;default:false"`
+	Priority     Priority `json:"priority" gorm:"not_null;default:0"`
+	// RRULE the task repeats by, see RecurrenceRule, or empty if it doesn't repeat
+	Recurrence string `json:"recurrence" gorm:"not_null;default:''"`
+	// The occurrence created when this recurring task was completed
+	NextId *string `json:"next_id" gorm:"type:uuid"`
 	// Habit Fields
 	Interval  Interval `json:"interval"`
 	Frequency int      `json:"frequency"`
@@ -68,10 +172,13 @@ const (
 	ActionProgress ActionKind = iota
 	ActionDefer
 	ActionDone
+	// Start and stop a timer tracking time spent on the task, see timeEntries
+	ActionStart
+	ActionStop
 )
 
 type Action struct {
-	Id     string     `json:"id" gorm:"primary_key;type:uuid;default:uuid_generate_v4()"`
+	Id     string     `json:"id" gorm:"primary_key;type:uuid"`
 	Kind   ActionKind `json:"kind" gorm:"not_null"`
 	When   *time.Time `json:"when" gorm:"not_null"`
 	TaskId string     `json:"task_id" gorm:"not_null;type:uuid"`
@@ -87,17 +194,131 @@ type User struct {
 	Tasks          []Task `json:"-" gorm:"ForeignKey:UserId"`
 }
 
+// Returned when a task was modified after the version the client based its update on.
+type ConflictError struct {
+	Current *Task
+}
+
+func (err *ConflictError) Error() string {
+	return fmt.Sprintf("Task ID \"%s\" was modified concurrently and is now at version %d", err.Current.Id, err.Current.Version)
+}
+
+// IDs are generated here rather than by the database so that every dialect behaves the same.
+func (task *Task) BeforeCreate(scope *gorm.Scope) error {
+	if task.Version == 0 {
+		if err := scope.SetColumn("Version", 1); err != nil {
+			return err
+		}
+	}
+	if task.Id == "" {
+		return scope.SetColumn("Id", newUUID())
+	}
+	return nil
+}
+
+func (action *Action) BeforeCreate(scope *gorm.Scope) error {
+	if action.Id == "" {
+		return scope.SetColumn("Id", newUUID())
+	}
+	return nil
+}
+
+// Returns the data source name for the given dialect. Postgres connects to dbName on host as user,
+// while sqlite3 ignores host and user and treats dbName as the path of the database file.
+func dataSource(dialect string, host string, user string, dbName string) (string, error) {
+	switch dialect {
+	case "postgres":
+		return fmt.Sprintf("host=%s user=%s DB.name=%s sslmode=disable", host, user, dbName), nil
+	case "sqlite3":
+		return dbName, nil
+	}
+	return "", fmt.Errorf("Unsupported database dialect \"%s\"", dialect)
+}
+
+// Opens the database for the given dialect.
+func OpenDatabase(dialect string, host string, user string, dbName string) (*gorm.DB, error) {
+	source, err := dataSource(dialect, host, user, dbName)
+	if err != nil {
+		return nil, err
+	}
+	return gorm.Open(dialect, source)
+}
+
+// Opens the database and brings its schema up to date. Refuses to start if the schema was
+// migrated by a newer version of duet.
 func InitDatabase(dialect string, host string, user string, dbName string) Database {
-	db, err := gorm.Open(dialect, fmt.Sprintf("host=%s user=%s DB.name=%s sslmode=disable", host, user, dbName))
+	db, err := OpenDatabase(dialect, host, user, dbName)
+	if err != nil {
+		panic(err)
+	}
+	applied, err := MigrateUp(db)
 	if err != nil {
 		panic(err)
 	}
-	db.AutoMigrate(&Task{}, &User{}, &Action{})
+	if len(applied) > 0 {
+		log.Printf("Applied schema migrations %v", applied)
+	}
 	return gormDB{db}
 }
 
 func (db gormDB) Close() error {
-	return db.Close()
+	return db.DB.Close()
+}
+
+func (db gormDB) WithTx(fn func(Database) error) error {
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		return db.savepoint(func() error {
+			return fn(db)
+		})
+	}
+	return db.transaction(func(tx gormDB) error {
+		return fn(tx)
+	})
+}
+
+// Runs fn within the current transaction so that if it fails, only the changes it made are rolled
+// back and the transaction can carry on.
+func (db gormDB) savepoint(fn func() error) (err error) {
+	if err := db.Exec("SAVEPOINT duet_savepoint").Error; err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			db.Exec("ROLLBACK TO SAVEPOINT duet_savepoint")
+			panic(r)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		db.Exec("ROLLBACK TO SAVEPOINT duet_savepoint")
+		db.Exec("RELEASE SAVEPOINT duet_savepoint")
+		return err
+	}
+	return db.Exec("RELEASE SAVEPOINT duet_savepoint").Error
+}
+
+// Runs fn in a transaction, or in the current one if db is already part of a transaction.
+func (db gormDB) transaction(fn func(tx gormDB) error) (err error) {
+	if _, ok := db.CommonDB().(*sql.Tx); ok {
+		return fn(db)
+	}
+
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err := fn(gormDB{tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
 func (db gormDB) GetTask(taskId string, userId uint64, kind *TaskKind) (*Task, error) {
@@ -110,64 +331,309 @@ func (db gormDB) GetTask(taskId string, userId uint64, kind *TaskKind) (*Task, e
 	}
 
 	var task Task
-	// TODO: Only preload actions if necessary
-	if err := db.Preload("Actions").Where(whereFields).First(&task).Error; err != nil {
+	if err := db.Where(whereFields).First(&task).Error; err != nil {
 		return nil, err
 	}
 	return &task, nil
 }
 
-func (db gormDB) GetTasks(userId uint64, kind *TaskKind) ([]Task, error) {
-	whereFields := map[string]interface{}{
-		"user_id": userId,
-	}
-	if kind != nil {
-		whereFields["kind"] = *kind
+func (db gormDB) GetTasks(userId uint64, query TaskQuery) (*TaskPage, error) {
+	scoped, err := query.scope(db.Where("user_id = ?", userId))
+	if err != nil {
+		return nil, err
 	}
 
 	var tasks []Task
-	// TODO: Only preload actions if necessary
-	if err := db.Preload("Actions").Where(whereFields).Find(&tasks).Error; err != nil {
+	if err := scoped.Find(&tasks).Error; err != nil {
 		return nil, err
 	}
-	return tasks, nil
+	return query.page(tasks), nil
 }
 
+// Adds a task. Tasks without a position are placed after the user's other tasks.
 func (db gormDB) AddTask(task *Task, userId uint64) error {
 	task.UserId = userId
-	return db.Create(task).Error
+	if err := validateRecurrence(task.Recurrence); err != nil {
+		return err
+	}
+	return db.transaction(func(tx gormDB) error {
+		if err := validateTaskProject(tx, task.ProjectId, userId); err != nil {
+			return err
+		}
+		if task.ParentId != nil {
+			if err := validateParent(tx, task, *task.ParentId, userId); err != nil {
+				return err
+			}
+		}
+		if task.Position == "" {
+			// Tasks in the trash keep their position, so they count too
+			var last sql.NullString
+			row := tx.Unscoped().Model(&Task{}).Where("user_id = ?", userId).Select("MAX(position)").Row()
+			if err := row.Scan(&last); err != nil {
+				return err
+			}
+			task.Position = rankAfter(last.String)
+		}
+		if err := tx.Create(task).Error; err != nil {
+			return err
+		}
+		return autoCompleteTask(tx, task.ParentId, userId)
+	})
 }
 
-// Deletes the task with the given ID and returns whether a row was deleted.
+// Deletes the task with the given ID along with its subtasks and returns whether a row was
+// deleted. Everything deleted together shares its deletion time, so it can be restored together.
 func (db gormDB) DeleteTask(taskId string, userId uint64) (bool, error) {
-	task := Task{
-		Id:     taskId,
-		UserId: userId,
-	}
-	result := db.Where(&task).Delete(&task)
-	if err := result.Error; err != nil {
-		return false, err
-	}
-	return result.RowsAffected > 0, nil
+	deleted := false
+	err := db.transaction(func(tx gormDB) error {
+		task, err := tx.GetTask(taskId, userId, nil)
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		descendants, err := liveDescendants(tx, taskId, userId)
+		if err != nil {
+			return err
+		}
+		ids := append([]string{taskId}, idsOfTasks(descendants)...)
+		err = tx.Model(&Task{}).Where("id IN (?)", ids).UpdateColumn("deleted_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		deleted = true
+		return autoCompleteTask(tx, task.ParentId, userId)
+	})
+	return deleted, err
 }
 
-// Updates a task with the given attributes and returns the updated Task if one exists for the ID.
-func (db gormDB) UpdateTask(taskId string, userId uint64, attrs map[string]interface{}) (*Task, error) {
-	task := Task{
-		Id: taskId,
+// Returns the user's soft-deleted tasks and habits, most recently deleted first.
+func (db gormDB) GetDeletedTasks(userId uint64) ([]Task, error) {
+	var tasks []Task
+	err := db.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userId).
+		Order("deleted_at DESC").
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
 	}
-	result := db.Model(&task).Where("user_id = ?", userId).Updates(attrs)
-	if err := result.Error; err != nil {
+	return tasks, nil
+}
+
+// Moves a task out of the trash along with the subtasks that were deleted with it and returns it.
+// The task is detached from its parent if the parent is no longer live.
+func (db gormDB) RestoreTask(taskId string, userId uint64) (*Task, error) {
+	var task *Task
+	err := db.transaction(func(tx gormDB) error {
+		descendants, err := trashedDescendants(tx, taskId, userId)
+		if err != nil {
+			return err
+		}
+		result := tx.Unscoped().Model(&Task{}).
+			Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", taskId, userId).
+			Update("deleted_at", gorm.Expr("NULL"))
+		if err := result.Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("Task ID \"%s\" is not in the trash for user \"%d\"", taskId, userId)
+		}
+		if len(descendants) > 0 {
+			err := tx.Unscoped().Model(&Task{}).
+				Where("id IN (?)", idsOfTasks(descendants)).
+				Update("deleted_at", gorm.Expr("NULL")).Error
+			if err != nil {
+				return err
+			}
+		}
+		task, err = tx.GetTask(taskId, userId, nil)
+		if err != nil || task.ParentId == nil {
+			return err
+		}
+		if _, err := tx.GetTask(*task.ParentId, userId, nil); err != gorm.ErrRecordNotFound {
+			return err
+		}
+		task, err = tx.UpdateTask(taskId, userId, map[string]interface{}{"parent_id": (*string)(nil)}, nil)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
-	if result.RowsAffected == 0 {
-		return nil, fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
+	return task, nil
+}
+
+// Permanently deletes a task in the trash along with its actions and the subtasks that were deleted
+// with it, and returns whether it existed.
+func (db gormDB) PurgeTask(taskId string, userId uint64) (bool, error) {
+	purged := false
+	err := db.transaction(func(tx gormDB) error {
+		var ids []string
+		err := tx.Unscoped().Model(&Task{}).
+			Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", taskId, userId).
+			Pluck("id", &ids).Error
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		descendants, err := trashedDescendants(tx, taskId, userId)
+		if err != nil {
+			return err
+		}
+		purged = true
+		return tx.purgeTasks(append(ids, idsOfTasks(descendants)...))
+	})
+	return purged, err
+}
+
+func (db gormDB) PurgeDeletedTasks(deletedBefore time.Time) ([]Task, error) {
+	var tasks []Task
+	err := db.transaction(func(tx gormDB) error {
+		err := tx.Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", deletedBefore).
+			Find(&tasks).Error
+		if err != nil || len(tasks) == 0 {
+			return err
+		}
+		return tx.purgeTasks(idsOfTasks(tasks))
+	})
+	if err != nil {
+		return nil, err
 	}
-	// TODO: Only query actions if necessary
-	if err := db.Model(&task).Related(&task.Actions).Error; err != nil {
+	return tasks, nil
+}
+
+// Tasks are purged this many at a time, since SQLite allows at most 999 parameters in a statement.
+const purgeBatchSize = 500
+
+// Deletes the tasks along with their actions, tags, reminders and dependencies, and detaches the
+// tasks that remain from them.
+func (db gormDB) purgeTasks(ids []string) error {
+	return db.transaction(func(tx gormDB) error {
+		for len(ids) > 0 {
+			batch := ids
+			if len(batch) > purgeBatchSize {
+				batch = batch[:purgeBatchSize]
+			}
+			ids = ids[len(batch):]
+
+			if err := tx.Where("task_id IN (?)", batch).Delete(&Action{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("task_id IN (?)", batch).Delete(&taskTag{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("task_id IN (?)", batch).Delete(&Reminder{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("task_id IN (?)", batch).Delete(&taskDependency{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("blocked_by_id IN (?)", batch).Delete(&taskDependency{}).Error; err != nil {
+				return err
+			}
+			for _, column := range []string{"parent_id", "next_id"} {
+				err := tx.Unscoped().Model(&Task{}).
+					Where(column+" IN (?)", batch).
+					Updates(map[string]interface{}{
+						column:    gorm.Expr("NULL"),
+						"version": gorm.Expr("version + 1"),
+					}).Error
+				if err != nil {
+					return err
+				}
+			}
+			if err := tx.Unscoped().Where("id IN (?)", batch).Delete(&Task{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Updates a task with the given attributes and returns the updated Task if one exists for the ID.
+func (db gormDB) UpdateTask(taskId string, userId uint64, attrs map[string]interface{}, expectedVersion *int) (*Task, error) {
+	var task *Task
+	err := db.transaction(func(tx gormDB) error {
+		before, err := tx.GetTask(taskId, userId, nil)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if before != nil {
+			if err := validateParentAttr(tx, before, attrs, userId); err != nil {
+				return err
+			}
+		}
+		if err := validateProjectAttr(tx, attrs, userId); err != nil {
+			return err
+		}
+		if err := validateRecurrenceAttr(attrs); err != nil {
+			return err
+		}
+
+		versioned := map[string]interface{}{
+			"version": gorm.Expr("version + 1"),
+		}
+		for column, value := range attrs {
+			versioned[column] = value
+		}
+
+		scoped := tx.Model(&Task{Id: taskId}).Where("user_id = ?", userId)
+		if expectedVersion != nil {
+			scoped = scoped.Where("version = ?", *expectedVersion)
+		}
+		result := scoped.Updates(versioned)
+		if err := result.Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			if expectedVersion != nil {
+				if current, err := tx.GetTask(taskId, userId, nil); err == nil {
+					return &ConflictError{current}
+				}
+			}
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
+		}
+
+		after, err := tx.GetTask(taskId, userId, nil)
+		if err != nil {
+			return err
+		}
+		if before != nil {
+			if err := recurAfterUpdate(tx, before, after, userId); err != nil {
+				return err
+			}
+		}
+		if err := autoCompleteAfterUpdate(tx, before, after, userId); err != nil {
+			return err
+		}
+		if before != nil && endDateChanged(before, after) {
+			if err := tx.rescheduleReminders(after); err != nil {
+				return err
+			}
+		}
+		task, err = tx.GetTask(taskId, userId, nil)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
-	return &task, nil
+	return task, nil
+}
+
+func (db gormDB) AddTasks(tasks []*Task, userId uint64) ([]error, error) {
+	return addTasks(db, tasks, userId)
+}
+
+func (db gormDB) UpdateTasks(updates []TaskUpdate, userId uint64) ([]*Task, []error, error) {
+	return updateTasks(db, updates, userId)
+}
+
+func (db gormDB) DeleteTasks(taskIds []string, userId uint64) ([]error, error) {
+	return deleteTasks(db, taskIds, userId)
+}
+
+func (db gormDB) AddActions(actions []*Action, userId uint64) ([]error, error) {
+	return addActions(db, actions, userId)
 }
 
 func (db gormDB) CreateUser(username string, password string) (*User, error) {
@@ -209,29 +675,636 @@ func (db gormDB) GetUserByUsername(username string) (*User, error) {
 }
 
 func (db gormDB) AddAction(action *Action, userId uint64) error {
-	task, err := db.GetTask(action.TaskId, userId, nil)
-	if task == nil {
-		return fmt.Errorf("Task %s does not exist for user %d", action.TaskId, userId)
+	return db.transaction(func(tx gormDB) error {
+		task, err := tx.GetTask(action.TaskId, userId, nil)
+		if task == nil {
+			return fmt.Errorf("Task %s does not exist for user %d", action.TaskId, userId)
+		}
+		if err != nil {
+			return err
+		}
+		if err := validateTimerAction(tx, action, userId); err != nil {
+			return err
+		}
+		// Kept in UTC so that timers compare the same in every database
+		if action.When != nil {
+			when := action.When.UTC()
+			action.When = &when
+		}
+		if err := tx.Create(action).Error; err != nil {
+			return err
+		}
+		return completeAfterAction(tx, action, userId)
+	})
+}
+
+func (db gormDB) DeleteAction(id string, userId uint64) error {
+	return db.transaction(func(tx gormDB) error {
+		action := &Action{
+			Id: id,
+		}
+		if err := tx.Where(action).First(action).Error; err != nil {
+			return err
+		}
+		task, err := tx.GetTask(action.TaskId, userId, nil)
+		if err != nil {
+			return err
+		}
+		if task == nil {
+			return fmt.Errorf("Not authorized to delete action %s", id)
+		}
+		return tx.Delete(action).Error
+	})
+}
+
+func (db gormDB) GetAction(id string, userId uint64) (*Action, error) {
+	var action Action
+	err := db.Where("id = ? AND task_id IN (SELECT id FROM tasks WHERE user_id = ?)", id, userId).First(&action).Error
+	if err != nil {
+		return nil, err
+	}
+	return &action, nil
+}
+
+// Returns the actions of each of the given tasks that belong to the user, keyed by task ID. Tasks
+// in the trash are included.
+func (db gormDB) GetActions(taskIds []string, userId uint64) (map[string][]Action, error) {
+	actions := make(map[string][]Action)
+	if len(taskIds) == 0 {
+		return actions, nil
+	}
+	var found []Action
+	err := db.Where("task_id IN (?) AND task_id IN (SELECT id FROM tasks WHERE user_id = ?)", taskIds, userId).
+		Find(&found).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range found {
+		actions[action.TaskId] = append(actions[action.TaskId], action)
 	}
+	return actions, nil
+}
+
+func (db gormDB) AddHistory(entry *HistoryEntry) error {
+	return db.Create(entry).Error
+}
+
+// Returns the history of a task, oldest first. Entries outlive the task itself.
+func (db gormDB) GetHistory(taskId string, userId uint64) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	err := db.Where("task_id = ? AND user_id = ?", taskId, userId).
+		Order("created_at").
+		Find(&entries).Error
 	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (db gormDB) GetProject(projectId string, userId uint64) (*Project, error) {
+	var project Project
+	if err := db.Where("id = ? AND user_id = ?", projectId, userId).First(&project).Error; err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+func (db gormDB) GetProjects(userId uint64, archived *bool) ([]Project, error) {
+	scoped := db.Where("user_id = ?", userId)
+	if archived != nil {
+		scoped = scoped.Where("archived = ?", *archived)
+	}
+	var projects []Project
+	if err := scoped.Order("position").Order("created_at").Find(&projects).Error; err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// Adds a project. Projects without a position are placed after the user's other projects.
+func (db gormDB) AddProject(project *Project, userId uint64) error {
+	project.UserId = userId
+	if err := validateProject(project); err != nil {
 		return err
 	}
-	return db.Create(action).Error
+	return db.transaction(func(tx gormDB) error {
+		if project.Position == 0 {
+			var last Project
+			err := tx.Where("user_id = ?", userId).Order("position DESC").First(&last).Error
+			if err != nil && err != gorm.ErrRecordNotFound {
+				return err
+			}
+			project.Position = last.Position + 1
+		}
+		return tx.Create(project).Error
+	})
 }
 
-func (db gormDB) DeleteAction(id string, userId uint64) error {
-	action := &Action{
-		Id: id,
+func (db gormDB) UpdateProject(projectId string, userId uint64, attrs map[string]interface{}) (*Project, error) {
+	var project *Project
+	err := db.transaction(func(tx gormDB) error {
+		var err error
+		project, err = tx.GetProject(projectId, userId)
+		if err != nil {
+			return fmt.Errorf("Project ID \"%s\" does not exist for user \"%d\"", projectId, userId)
+		}
+		if err := setProjectAttrs(project, attrs); err != nil {
+			return err
+		}
+		if err := validateProject(project); err != nil {
+			return err
+		}
+		if err := tx.Model(project).Updates(attrs).Error; err != nil {
+			return err
+		}
+		project, err = tx.GetProject(projectId, userId)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	if err := db.Where(action).First(action).Error; err != nil {
+	return project, nil
+}
+
+// Deletes a project and moves its tasks and habits, including those in the trash, out of it.
+func (db gormDB) DeleteProject(projectId string, userId uint64) (bool, error) {
+	deleted := false
+	err := db.transaction(func(tx gormDB) error {
+		result := tx.Where("id = ? AND user_id = ?", projectId, userId).Delete(&Project{})
+		if err := result.Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		deleted = true
+		return tx.Unscoped().Model(&Task{}).
+			Where("project_id = ? AND user_id = ?", projectId, userId).
+			Updates(map[string]interface{}{
+				"project_id": gorm.Expr("NULL"),
+				"version":    gorm.Expr("version + 1"),
+			}).Error
+	})
+	return deleted, err
+}
+
+func (db gormDB) GetTags(userId uint64) ([]Tag, error) {
+	var tags []Tag
+	if err := db.Where("user_id = ?", userId).Order("name").Find(&tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Returns the tags of each of the given tasks that belong to the user, keyed by task ID and
+// ordered by name. Tasks in the trash are included.
+func (db gormDB) GetTaskTags(taskIds []string, userId uint64) (map[string][]Tag, error) {
+	tags := make(map[string][]Tag)
+	if len(taskIds) == 0 {
+		return tags, nil
+	}
+	var links []taskTag
+	if err := db.Where("task_id IN (?)", taskIds).Find(&links).Error; err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return tags, nil
+	}
+	tagIds := make([]string, len(links))
+	for i, link := range links {
+		tagIds[i] = link.TagId
+	}
+	var found []Tag
+	if err := db.Where("id IN (?) AND user_id = ?", tagIds, userId).Order("name").Find(&found).Error; err != nil {
+		return nil, err
+	}
+	taskIdsOfTag := make(map[string][]string)
+	for _, link := range links {
+		taskIdsOfTag[link.TagId] = append(taskIdsOfTag[link.TagId], link.TaskId)
+	}
+	for _, tag := range found {
+		for _, taskId := range taskIdsOfTag[tag.Id] {
+			tags[taskId] = append(tags[taskId], tag)
+		}
+	}
+	return tags, nil
+}
+
+// Returns the user's tag with the given name, or nil if there is none.
+func (db gormDB) findTagByName(name string, userId uint64) (*Tag, error) {
+	var tag Tag
+	err := db.Where("user_id = ? AND name = ?", userId, name).First(&tag).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func (db gormDB) findTag(tagId string, userId uint64) (*Tag, error) {
+	var tag Tag
+	if err := db.Where("id = ? AND user_id = ?", tagId, userId).First(&tag).Error; err != nil {
+		return nil, tagNotFound(tagId, userId)
+	}
+	return &tag, nil
+}
+
+func (db gormDB) AddTag(taskId string, name string, userId uint64) (*Tag, error) {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return nil, err
+	}
+	var tag *Tag
+	err = db.transaction(func(tx gormDB) error {
+		if _, err := tx.GetTask(taskId, userId, nil); err != nil {
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
+		}
+		var err error
+		tag, err = tx.findTagByName(name, userId)
+		if err != nil {
+			return err
+		}
+		if tag == nil {
+			tag = &Tag{
+				UserId: userId,
+				Name:   name,
+			}
+			if err := tx.Create(tag).Error; err != nil {
+				return err
+			}
+		}
+		link := taskTag{TaskId: taskId, TagId: tag.Id}
+		return tx.Where(link).FirstOrCreate(&link).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (db gormDB) RemoveTag(taskId string, name string, userId uint64) (bool, error) {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return false, err
+	}
+	removed := false
+	err = db.transaction(func(tx gormDB) error {
+		if _, err := tx.GetTask(taskId, userId, nil); err != nil {
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
+		}
+		tag, err := tx.findTagByName(name, userId)
+		if err != nil || tag == nil {
+			return err
+		}
+		result := tx.Where("task_id = ? AND tag_id = ?", taskId, tag.Id).Delete(&taskTag{})
+		removed = result.RowsAffected > 0
+		return result.Error
+	})
+	return removed, err
+}
+
+func (db gormDB) RenameTag(tagId string, name string, userId uint64) (*Tag, error) {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return nil, err
+	}
+	var tag *Tag
+	err = db.transaction(func(tx gormDB) error {
+		var err error
+		if tag, err = tx.findTag(tagId, userId); err != nil {
+			return err
+		}
+		other, err := tx.findTagByName(name, userId)
+		if err != nil {
+			return err
+		}
+		if other != nil && other.Id != tagId {
+			return tagNameTaken(name)
+		}
+		if err := tx.Model(tag).Update("name", name).Error; err != nil {
+			return err
+		}
+		tag, err = tx.findTag(tagId, userId)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (db gormDB) MergeTags(sourceId string, targetId string, userId uint64) (*Tag, error) {
+	if sourceId == targetId {
+		return nil, fmt.Errorf("Can't merge tag ID \"%s\" into itself", sourceId)
+	}
+	var target *Tag
+	err := db.transaction(func(tx gormDB) error {
+		if _, err := tx.findTag(sourceId, userId); err != nil {
+			return err
+		}
+		var err error
+		if target, err = tx.findTag(targetId, userId); err != nil {
+			return err
+		}
+		// Tasks with both tags keep the target's link
+		err = tx.Where("tag_id = ? AND task_id IN (SELECT task_id FROM task_tags WHERE tag_id = ?)", sourceId, targetId).
+			Delete(&taskTag{}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&taskTag{}).Where("tag_id = ?", sourceId).UpdateColumn("tag_id", targetId).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", sourceId).Delete(&Tag{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+func (db gormDB) DeleteTag(tagId string, userId uint64) (bool, error) {
+	deleted := false
+	err := db.transaction(func(tx gormDB) error {
+		if _, err := tx.findTag(tagId, userId); err != nil {
+			return nil
+		}
+		if err := tx.Where("tag_id = ?", tagId).Delete(&taskTag{}).Error; err != nil {
+			return err
+		}
+		deleted = true
+		return tx.Where("id = ?", tagId).Delete(&Tag{}).Error
+	})
+	return deleted, err
+}
+
+func (db gormDB) GetAttachment(attachmentId string, userId uint64) (*Attachment, error) {
+	var attachment Attachment
+	if err := db.Where("id = ? AND user_id = ?", attachmentId, userId).First(&attachment).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// Returns the attachments of each of the given tasks that belong to the user, keyed by task ID
+// and oldest first. Tasks in the trash are included.
+func (db gormDB) GetAttachments(taskIds []string, userId uint64) (map[string][]Attachment, error) {
+	attachments := make(map[string][]Attachment)
+	if len(taskIds) == 0 {
+		return attachments, nil
+	}
+	var found []Attachment
+	err := db.Where("task_id IN (?) AND user_id = ?", taskIds, userId).
+		Order("created_at, id").
+		Find(&found).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, attachment := range found {
+		attachments[attachment.TaskId] = append(attachments[attachment.TaskId], attachment)
+	}
+	return attachments, nil
+}
+
+func (db gormDB) AddAttachment(attachment *Attachment, quota int64, userId uint64) error {
+	return db.transaction(func(tx gormDB) error {
+		if _, err := tx.GetTask(attachment.TaskId, userId, nil); err != nil {
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", attachment.TaskId, userId)
+		}
+		// Locking the user's row makes concurrent uploads of the same user check the quota one
+		// after the other. SQLite has no FOR UPDATE, but only lets one transaction write at a time.
+		if tx.Dialect().GetName() == "postgres" {
+			var user User
+			if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&user, userId).Error; err != nil {
+				return err
+			}
+		}
+		used, err := tx.GetAttachmentUsage(userId)
+		if err != nil {
+			return err
+		}
+		if used+attachment.Size > quota {
+			return quotaExceededError(quota, used)
+		}
+		attachment.UserId = userId
+		return tx.Create(attachment).Error
+	})
+}
+
+func (db gormDB) DeleteAttachment(attachmentId string, userId uint64) (bool, error) {
+	result := db.Where("id = ? AND user_id = ?", attachmentId, userId).Delete(&Attachment{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (db gormDB) GetAttachmentUsage(userId uint64) (int64, error) {
+	var usage int64
+	err := db.Model(&Attachment{}).
+		Where("user_id = ?", userId).
+		Select("COALESCE(SUM(size), 0)").
+		Row().Scan(&usage)
+	return usage, err
+}
+
+// Attachments are kept when their task is purged, so their content can be deleted from the
+// BlobStore before they are.
+func (db gormDB) GetOrphanedAttachments() ([]Attachment, error) {
+	var attachments []Attachment
+	err := db.Where("NOT EXISTS (SELECT 1 FROM tasks WHERE tasks.id = attachments.task_id)").
+		Find(&attachments).Error
+	if err != nil {
+		return nil, err
 	}
-	task, err := db.GetTask(action.TaskId, userId, nil)
+	return attachments, nil
+}
+
+// Returns the reminders of each of the given tasks that belong to the user, keyed by task ID and
+// oldest first. Tasks in the trash are included.
+func (db gormDB) GetReminders(taskIds []string, userId uint64) (map[string][]Reminder, error) {
+	reminders := make(map[string][]Reminder)
+	if len(taskIds) == 0 {
+		return reminders, nil
+	}
+	var found []Reminder
+	err := db.Where("task_id IN (?) AND user_id = ?", taskIds, userId).
+		Order("created_at, id").
+		Find(&found).Error
 	if err != nil {
+		return nil, err
+	}
+	for _, reminder := range found {
+		reminders[reminder.TaskId] = append(reminders[reminder.TaskId], reminder)
+	}
+	return reminders, nil
+}
+
+func (db gormDB) AddReminder(reminder *Reminder, userId uint64) error {
+	return db.transaction(func(tx gormDB) error {
+		task, err := tx.GetTask(reminder.TaskId, userId, nil)
+		if err != nil {
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", reminder.TaskId, userId)
+		}
+		if err := scheduleReminder(reminder, task); err != nil {
+			return err
+		}
+		reminder.UserId = userId
+		return tx.Create(reminder).Error
+	})
+}
+
+func (db gormDB) DeleteReminder(reminderId string, userId uint64) (bool, error) {
+	result := db.Where("id = ? AND user_id = ?", reminderId, userId).Delete(&Reminder{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (db gormDB) GetDueReminders(now time.Time, limit int) ([]Reminder, error) {
+	var reminders []Reminder
+	err := db.Where("fire_at IS NOT NULL AND fire_at <= ?", now.UTC()).
+		Order("fire_at, id").
+		Limit(limit).
+		Find(&reminders).Error
+	if err != nil {
+		return nil, err
+	}
+	return reminders, nil
+}
+
+func (db gormDB) ClaimReminder(reminderId string, fireAt time.Time, next *time.Time) (bool, error) {
+	result := db.Model(&Reminder{}).
+		Where("id = ? AND fire_at = ?", reminderId, fireAt.UTC()).
+		Updates(map[string]interface{}{
+			"fire_at":  next,
+			"fired_at": time.Now().UTC(),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (db gormDB) GetRunningTimers(userId uint64) ([]Timer, error) {
+	return db.runningTimers(db.Where("tasks.user_id = ?", userId))
+}
+
+func (db gormDB) GetForgottenTimers(startedBefore time.Time) ([]Timer, error) {
+	return db.runningTimers(db.Where(`actions."when" < ?`, startedBefore.UTC()))
+}
+
+type timerRow struct {
+	Id     string
+	TaskId string
+	When   time.Time
+	UserId uint64
+}
+
+// Returns the running timers that scope selects, oldest first.
+func (db gormDB) runningTimers(scope *gorm.DB) ([]Timer, error) {
+	var rows []timerRow
+	err := scope.Table("actions").
+		Select(`actions.id, actions.task_id, actions."when", tasks.user_id`).
+		Joins("JOIN tasks ON tasks.id = actions.task_id AND tasks.deleted_at IS NULL").
+		Where("actions.kind = ?", ActionStart).
+		Where(`NOT EXISTS (SELECT 1 FROM actions stops WHERE stops.task_id = actions.task_id AND stops.kind = ? AND stops."when" >= actions."when")`, ActionStop).
+		Order(`actions."when"`).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	timers := make([]Timer, len(rows))
+	for i, row := range rows {
+		timers[i] = Timer{
+			TaskId:    row.TaskId,
+			UserId:    row.UserId,
+			ActionId:  row.Id,
+			StartedAt: row.When,
+		}
+	}
+	return timers, nil
+}
+
+func (db gormDB) GetBlockers(taskIds []string, userId uint64) (map[string][]Task, error) {
+	return db.dependencyTasks("task_id", taskIds, userId)
+}
+
+func (db gormDB) GetDependents(taskIds []string, userId uint64) (map[string][]Task, error) {
+	return db.dependencyTasks("blocked_by_id", taskIds, userId)
+}
+
+// Returns the live tasks at the other end of the dependencies whose column is one of the given
+// task IDs, keyed by that task ID and oldest first.
+func (db gormDB) dependencyTasks(column string, taskIds []string, userId uint64) (map[string][]Task, error) {
+	tasks := make(map[string][]Task)
+	if len(taskIds) == 0 {
+		return tasks, nil
+	}
+	var links []taskDependency
+	if err := db.Where(column+" IN (?)", taskIds).Find(&links).Error; err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return tasks, nil
+	}
+	otherIds := []string{}
+	keysOfOther := make(map[string][]string)
+	for _, link := range links {
+		key, other := link.TaskId, link.BlockedById
+		if column == "blocked_by_id" {
+			key, other = other, key
+		}
+		otherIds = append(otherIds, other)
+		keysOfOther[other] = append(keysOfOther[other], key)
+	}
+	var found []Task
+	err := db.Where("id IN (?) AND user_id = ?", otherIds, userId).Order("created_at").Order("id").Find(&found).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range found {
+		for _, key := range keysOfOther[task.Id] {
+			tasks[key] = append(tasks[key], task)
+		}
+	}
+	return tasks, nil
+}
+
+// Returns the IDs of the tasks blocking each of the given tasks, including tasks in the trash.
+func (db gormDB) blockerIds(taskIds []string) (map[string][]string, error) {
+	var links []taskDependency
+	if err := db.Where("task_id IN (?)", taskIds).Find(&links).Error; err != nil {
+		return nil, err
+	}
+	ids := make(map[string][]string)
+	for _, link := range links {
+		ids[link.TaskId] = append(ids[link.TaskId], link.BlockedById)
+	}
+	return ids, nil
+}
+
+func (db gormDB) AddDependency(taskId string, blockedById string, userId uint64) error {
+	return db.transaction(func(tx gormDB) error {
+		if err := validateDependency(tx, tx.blockerIds, taskId, blockedById, userId); err != nil {
+			return err
+		}
+		link := taskDependency{TaskId: taskId, BlockedById: blockedById}
+		return tx.Where(link).FirstOrCreate(&link).Error
+	})
+}
+
+func (db gormDB) RemoveDependency(taskId string, blockedById string, userId uint64) (bool, error) {
+	result := db.Where("task_id = ? AND blocked_by_id = ? AND task_id IN (SELECT id FROM tasks WHERE user_id = ?)", taskId, blockedById, userId).
+		Delete(&taskDependency{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Moves the reminders that are relative to the task's end date to its current end date.
+func (db gormDB) rescheduleReminders(task *Task) error {
+	var reminders []Reminder
+	if err := db.Where("task_id = ? AND offset_minutes IS NOT NULL", task.Id).Find(&reminders).Error; err != nil {
 		return err
 	}
-	if task == nil {
-		return fmt.Errorf("Not authorized to delete action %s", id)
+	for _, reminder := range reminders {
+		err := db.Model(&reminder).UpdateColumn("fire_at", reminderFireAt(&reminder, task, time.Now())).Error
+		if err != nil {
+			return err
+		}
 	}
-	return db.Delete(action).Error
+	return nil
 }
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Records that a task is blocked by another task until that one is done.
+type taskDependency struct {
+	TaskId      string `gorm:"primary_key;type:uuid"`
+	BlockedById string `gorm:"primary_key;type:uuid"`
+}
+
+func (taskDependency) TableName() string {
+	return "task_dependencies"
+}
+
+// Checks that the task may be blocked by the other: both must be live tasks of the user, and the
+// other task can't already be blocked by the task, directly or through other tasks, since neither
+// could then ever be done first. blockerIds returns the IDs of the tasks blocking each of the given
+// tasks, including tasks in the trash, since restoring one could otherwise complete a cycle.
+func validateDependency(db Database, blockerIds func([]string) (map[string][]string, error), taskId string, blockedById string, userId uint64) error {
+	if taskId == blockedById {
+		return fmt.Errorf("Task ID \"%s\" can't be blocked by itself", taskId)
+	}
+	kind := TaskEnum
+	for _, id := range []string{taskId, blockedById} {
+		if _, err := db.GetTask(id, userId, &kind); err != nil {
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", id, userId)
+		}
+	}
+
+	visited := map[string]bool{blockedById: true}
+	queue := []string{blockedById}
+	for len(queue) > 0 {
+		blockers, err := blockerIds(queue)
+		if err != nil {
+			return err
+		}
+		queue = nil
+		for _, ids := range blockers {
+			for _, id := range ids {
+				if id == taskId {
+					return fmt.Errorf("Task ID \"%s\" can't be blocked by \"%s\", which is already blocked by it", taskId, blockedById)
+				}
+				if !visited[id] {
+					visited[id] = true
+					queue = append(queue, id)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Reports whether a task with the given live blockers is blocked, which it is until they are all
+// done.
+func isBlocked(blockers []Task) bool {
+	for _, blocker := range blockers {
+		if !blocker.Done {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the live tasks that are blocked by any of the given tasks and currently blocked, so that
+// unblockedTasks can tell which of them a change to the given tasks unblocked.
+func blockedDependents(db Database, taskIds []string, userId uint64) ([]Task, error) {
+	dependents, err := db.GetDependents(taskIds, userId)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	visited := make(map[string]bool)
+	for _, tasks := range dependents {
+		for _, dependent := range tasks {
+			if !visited[dependent.Id] {
+				visited[dependent.Id] = true
+				ids = append(ids, dependent.Id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return []Task{}, nil
+	}
+	blockers, err := db.GetBlockers(ids, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := []Task{}
+	for _, tasks := range dependents {
+		for _, dependent := range tasks {
+			if visited[dependent.Id] && isBlocked(blockers[dependent.Id]) {
+				visited[dependent.Id] = false
+				blocked = append(blocked, dependent)
+			}
+		}
+	}
+	return blocked, nil
+}
+
+// Returns those of the given tasks, which were blocked before a change, that are still live and no
+// longer blocked.
+func unblockedTasks(db Database, blocked []Task, userId uint64) ([]Task, error) {
+	unblocked := []Task{}
+	if len(blocked) == 0 {
+		return unblocked, nil
+	}
+	blockers, err := db.GetBlockers(idsOfTasks(blocked), userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range blocked {
+		if isBlocked(blockers[task.Id]) {
+			continue
+		}
+		after, err := db.GetTask(task.Id, userId, nil)
+		if err == gorm.ErrRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		unblocked = append(unblocked, *after)
+	}
+	return unblocked, nil
+}
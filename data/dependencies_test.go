@@ -0,0 +1,154 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddDependencyRejectsCycles(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := db.CreateUser("other", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, b, c := &Task{Title: "a"}, &Task{Title: "b"}, &Task{Title: "c"}
+		addTestTasks(t, db, user.Id, a, b, c)
+		otherTask := &Task{Title: "other"}
+		addTestTasks(t, db, other.Id, otherTask)
+		if err := db.AddDependency(a.Id, b.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddDependency(b.Id, c.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddDependency(a.Id, b.Id, user.Id); err != nil {
+			t.Errorf("%T: adding a dependency again failed: %s", db, err)
+		}
+
+		tests := []struct {
+			taskId      string
+			blockedById string
+		}{
+			{a.Id, a.Id},
+			{b.Id, a.Id},
+			{c.Id, a.Id},
+			{a.Id, otherTask.Id},
+			{a.Id, "missing"},
+		}
+		for _, test := range tests {
+			if err := db.AddDependency(test.taskId, test.blockedById, user.Id); err == nil {
+				t.Errorf("%T: AddDependency(%s, %s) succeeded, want an error", db, test.taskId, test.blockedById)
+			}
+		}
+
+		// Restoring b would complete the cycle, so it still counts while in the trash
+		if _, err := db.DeleteTask(b.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddDependency(c.Id, a.Id, user.Id); err == nil {
+			t.Errorf("%T: a cycle through a task in the trash was allowed", db)
+		}
+	}
+}
+
+func TestActionableTasks(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocked, first, second := &Task{Title: "blocked"}, &Task{Title: "first"}, &Task{Title: "second"}
+		addTestTasks(t, db, user.Id, blocked, first, second)
+		for _, blocker := range []*Task{first, second} {
+			if err := db.AddDependency(blocked.Id, blocker.Id, user.Id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		actionable := true
+		checkActionable := func(want ...string) {
+			page, err := db.GetTasks(user.Id, TaskQuery{Actionable: &actionable})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := titlesOf(page.Tasks); !equalStrings(got, want) {
+				t.Errorf("%T: actionable tasks are %v, want %v", db, got, want)
+			}
+		}
+
+		checkActionable("first", "second")
+		if _, err := db.UpdateTask(first.Id, user.Id, map[string]interface{}{"done": true}, nil); err != nil {
+			t.Fatal(err)
+		}
+		checkActionable("second")
+		// Deleted blockers no longer block
+		if _, err := db.DeleteTask(second.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		checkActionable("blocked")
+
+		if ok, err := db.RemoveDependency(blocked.Id, first.Id, user.Id); err != nil || !ok {
+			t.Errorf("%T: RemoveDependency returned %v, %v, want true", db, ok, err)
+		}
+		blockers, err := db.GetBlockers([]string{blocked.Id}, user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blockers[blocked.Id]) != 0 {
+			t.Errorf("%T: blocked by %v after removing the dependencies", db, titlesOf(blockers[blocked.Id]))
+		}
+	}
+}
+
+func TestCompletingBlockersPublishesUnblock(t *testing.T) {
+	db := WithChanges(NewMemoryDatabase(), NewMemoryBroker())
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked, first, second := &Task{Title: "blocked"}, &Task{Title: "first"}, &Task{Title: "second"}
+	addTestTasks(t, db, user.Id, blocked, first, second)
+	for _, blocker := range []*Task{first, second} {
+		if err := db.AddDependency(blocked.Id, blocker.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, unsubscribe := db.(changesDB).broker.Subscribe(user.Id)
+	defer unsubscribe()
+
+	// Returns whether an unblock event for the blocked task was published
+	unblocked := func() bool {
+		for {
+			select {
+			case event := <-events:
+				if event.Operation == OperationUnblock && event.TaskId == blocked.Id {
+					return true
+				}
+			case <-time.After(100 * time.Millisecond):
+				return false
+			}
+		}
+	}
+
+	if _, err := db.UpdateTask(first.Id, user.Id, map[string]interface{}{"done": true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if unblocked() {
+		t.Error("Completing one of two blockers published an unblock event")
+	}
+	if _, err := db.UpdateTask(second.Id, user.Id, map[string]interface{}{"done": true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !unblocked() {
+		t.Error("Completing the last blocker didn't publish an unblock event")
+	}
+}
@@ -0,0 +1,613 @@
+package data
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+const exportFormatVersion = 1
+
+// A user's tasks, habits and actions in the portable export format. This is the format written
+// by GET /rest/export and "duet export", and read by POST /rest/import and "duet import":
+//
+//	{
+//	  "version": 1,
+//	  "exported_at": "2017-03-01T12:00:00Z",
+//	  "username": "andy",
+//	  "projects": [{
+//	    "id": "...", "name": "...", "color": "#4a90e2" | "", "archived": false, "position": 1,
+//	    "created_at": "<RFC 3339>"
+//	  }],
+//	  "tasks": [{
+//	    "id": "...", "kind": "task" | "habit", "title": "...", "notes": "<Markdown>",
+//	    "done": false,
+//	    "start_date": "<RFC 3339>" | null, "end_date": "<RFC 3339>" | null,
+//	    "interval": "daily" | "weekly" | "monthly", "frequency": 3,
+//	    "parent_id": "..." | null, "auto_complete": false, "project_id": "..." | null,
+//	    "priority": "none" | "low" | "medium" | "high",
+//	    "recurrence": "FREQ=WEEKLY;BYDAY=TU" | "", "next_id": "..." | null,
+//	    "tags": ["@phone", ...], "blocked_by": ["...", ...],
+//	    "created_at": "<RFC 3339>", "updated_at": "<RFC 3339>",
+//	    "actions": [{"id": "...", "kind": "progress" | "defer" | "done" | "start" | "stop", "when": "<RFC 3339>"}]
+//	  }]
+//	}
+//
+// start_date, end_date, parent_id, auto_complete, priority, recurrence, next_id and blocked_by only
+// apply to tasks, while interval and frequency only apply to habits. parent_id, next_id and
+// blocked_by refer to other tasks in the same export, and project_id to one of its projects. Tasks
+// and habits are listed in the user's manual order. Exports without projects, priorities or notes
+// are still accepted.
+type Export struct {
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Username   string          `json:"username"`
+	Projects   []ExportProject `json:"projects"`
+	Tasks      []ExportTask    `json:"tasks"`
+}
+
+type ExportProject struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Color     string    `json:"color"`
+	Archived  bool      `json:"archived"`
+	Position  int       `json:"position"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportTask struct {
+	Id           string         `json:"id"`
+	Kind         string         `json:"kind"`
+	Title        string         `json:"title"`
+	Notes        string         `json:"notes"`
+	Done         bool           `json:"done"`
+	StartDate    *time.Time     `json:"start_date"`
+	EndDate      *time.Time     `json:"end_date"`
+	Interval     string         `json:"interval"`
+	Frequency    int            `json:"frequency"`
+	ParentId     *string        `json:"parent_id"`
+	AutoComplete bool           `json:"auto_complete"`
+	ProjectId    *string        `json:"project_id"`
+	Priority     string         `json:"priority"`
+	Recurrence   string         `json:"recurrence"`
+	NextId       *string        `json:"next_id"`
+	Tags         []string       `json:"tags"`
+	BlockedBy    []string       `json:"blocked_by"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+	Actions      []ExportAction `json:"actions"`
+}
+
+type ExportAction struct {
+	Id   string    `json:"id"`
+	Kind string    `json:"kind"`
+	When time.Time `json:"when"`
+}
+
+// The outcome of an import. IdMap maps each imported project, task and action ID to the ID it has
+// now.
+type ImportResult struct {
+	ProjectsCreated int               `json:"projects_created"`
+	ProjectsSkipped int               `json:"projects_skipped"`
+	TasksCreated    int               `json:"tasks_created"`
+	TasksSkipped    int               `json:"tasks_skipped"`
+	ActionsCreated  int               `json:"actions_created"`
+	ActionsSkipped  int               `json:"actions_skipped"`
+	IdMap           map[string]string `json:"id_map"`
+}
+
+var taskKindNames = map[TaskKind]string{
+	TaskEnum:  "task",
+	HabitEnum: "habit",
+}
+
+var intervalNames = map[Interval]string{
+	Daily:   "daily",
+	Weekly:  "weekly",
+	Monthly: "monthly",
+}
+
+var priorityNames = map[Priority]string{
+	PriorityNone:   "none",
+	PriorityLow:    "low",
+	PriorityMedium: "medium",
+	PriorityHigh:   "high",
+}
+
+var actionKindNames = map[ActionKind]string{
+	ActionProgress: "progress",
+	ActionDefer:    "defer",
+	ActionDone:     "done",
+	ActionStart:    "start",
+	ActionStop:     "stop",
+}
+
+func parseTaskKind(name string) (TaskKind, error) {
+	for kind, kindName := range taskKindNames {
+		if kindName == name {
+			return kind, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown task kind \"%s\"", name)
+}
+
+func parseInterval(name string) (Interval, error) {
+	for interval, intervalName := range intervalNames {
+		if intervalName == name {
+			return interval, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown interval \"%s\"", name)
+}
+
+// Parses a priority, treating a missing one as no priority.
+func parsePriority(name string) (Priority, error) {
+	if name == "" {
+		return PriorityNone, nil
+	}
+	for priority, priorityName := range priorityNames {
+		if priorityName == name {
+			return priority, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown priority \"%s\"", name)
+}
+
+func parseActionKind(name string) (ActionKind, error) {
+	for kind, kindName := range actionKindNames {
+		if kindName == name {
+			return kind, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown action kind \"%s\"", name)
+}
+
+// Collects every project, task, habit and action belonging to the user.
+func ExportTasks(db Database, userId uint64) (*Export, error) {
+	user, err := db.GetUserById(userId)
+	if err != nil {
+		return nil, err
+	}
+	page, err := db.GetTasks(userId, TaskQuery{Order: OrderPositionAsc})
+	if err != nil {
+		return nil, err
+	}
+	actions, err := db.GetActions(idsOfTasks(page.Tasks), userId)
+	if err != nil {
+		return nil, err
+	}
+	projects, err := db.GetProjects(userId, nil)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := db.GetTaskTags(idsOfTasks(page.Tasks), userId)
+	if err != nil {
+		return nil, err
+	}
+	blockers, err := db.GetBlockers(idsOfTasks(page.Tasks), userId)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &Export{
+		Version:    exportFormatVersion,
+		ExportedAt: time.Now().UTC(),
+		Username:   user.Username,
+		Projects:   []ExportProject{},
+		Tasks:      []ExportTask{},
+	}
+	for _, project := range projects {
+		export.Projects = append(export.Projects, ExportProject{
+			Id:        project.Id,
+			Name:      project.Name,
+			Color:     project.Color,
+			Archived:  project.Archived,
+			Position:  project.Position,
+			CreatedAt: project.CreatedAt,
+		})
+	}
+	for _, task := range page.Tasks {
+		exported := ExportTask{
+			Id:           task.Id,
+			Kind:         taskKindNames[task.Kind],
+			Title:        task.Title,
+			Notes:        task.Notes,
+			Done:         task.Done,
+			StartDate:    task.StartDate,
+			EndDate:      task.EndDate,
+			Interval:     intervalNames[task.Interval],
+			Frequency:    task.Frequency,
+			ParentId:     task.ParentId,
+			AutoComplete: task.AutoComplete,
+			ProjectId:    task.ProjectId,
+			Priority:     priorityNames[task.Priority],
+			Recurrence:   task.Recurrence,
+			NextId:       task.NextId,
+			Tags:         []string{},
+			BlockedBy:    idsOfTasks(blockers[task.Id]),
+			CreatedAt:    task.CreatedAt,
+			UpdatedAt:    task.UpdatedAt,
+			Actions:      []ExportAction{},
+		}
+		for _, tag := range tags[task.Id] {
+			exported.Tags = append(exported.Tags, tag.Name)
+		}
+		for _, action := range actions[task.Id] {
+			exportedAction := ExportAction{
+				Id:   action.Id,
+				Kind: actionKindNames[action.Kind],
+			}
+			if action.When != nil {
+				exportedAction.When = *action.When
+			}
+			exported.Actions = append(exported.Actions, exportedAction)
+		}
+		export.Tasks = append(export.Tasks, exported)
+	}
+	return export, nil
+}
+
+func formatCSVTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Writes the export as CSV with one row per project, task, habit or action. The record column says
+// which one a row is, and action rows refer to their task through task_id. Project rows hold the
+// project's name in the title column, and the tags column separates tag names with commas.
+func (export *Export) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{
+		"record", "id", "task_id", "title", "done", "start_date", "end_date", "interval",
+		"frequency", "action_kind", "when", "created_at", "updated_at", "parent_id", "project_id",
+		"tags", "priority", "notes", "recurrence",
+	})
+	for _, project := range export.Projects {
+		writer.Write([]string{
+			"project", project.Id, "", project.Name, "", "", "", "", "",
+			"", "", formatCSVTime(&project.CreatedAt), "", "", "", "", "", "", "",
+		})
+	}
+	for _, task := range export.Tasks {
+		interval, frequency, priority := "", "", task.Priority
+		if task.Kind == taskKindNames[HabitEnum] {
+			interval, frequency, priority = task.Interval, strconv.Itoa(task.Frequency), ""
+		}
+		parentId, projectId := "", ""
+		if task.ParentId != nil {
+			parentId = *task.ParentId
+		}
+		if task.ProjectId != nil {
+			projectId = *task.ProjectId
+		}
+		writer.Write([]string{
+			task.Kind, task.Id, "", task.Title, strconv.FormatBool(task.Done),
+			formatCSVTime(task.StartDate), formatCSVTime(task.EndDate), interval, frequency,
+			"", "", formatCSVTime(&task.CreatedAt), formatCSVTime(&task.UpdatedAt), parentId, projectId,
+			strings.Join(task.Tags, ","), priority, task.Notes, task.Recurrence,
+		})
+		for _, action := range task.Actions {
+			writer.Write([]string{
+				"action", action.Id, task.Id, "", "", "", "", "", "",
+				action.Kind, formatCSVTime(&action.When), "", "", "", "", "", "", "", "",
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type exportActionsByWhen []ExportAction
+
+func (s exportActionsByWhen) Len() int           { return len(s) }
+func (s exportActionsByWhen) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s exportActionsByWhen) Less(i, j int) bool { return s[i].When.Before(s[j].When) }
+
+// Identifies a task independently of its ID, so re-importing an export doesn't duplicate it.
+func importKey(kind TaskKind, title string, createdAt time.Time) string {
+	return fmt.Sprintf("%d|%d|%s", kind, createdAt.Unix(), title)
+}
+
+func actionKey(kind ActionKind, when time.Time) string {
+	return fmt.Sprintf("%d|%d", kind, when.Unix())
+}
+
+// Adds the exported projects, tasks, habits and actions to the user's account under new IDs.
+// Projects the user already has, because they have the same ID or name, are reused. Tasks the user
+// already has, because they have the same ID or the same kind, title and creation time, are
+// skipped, as are actions their task already has, though skipped tasks still get any tags and
+// blockers they are missing. Imported tasks keep their parent and blockers if they are part of the
+// export, and are placed after the user's other tasks in the order of the export. Either everything
+// is imported or nothing is.
+func ImportTasks(db Database, userId uint64, export *Export) (*ImportResult, error) {
+	if export.Version != exportFormatVersion {
+		return nil, fmt.Errorf("Unsupported export version %d", export.Version)
+	}
+
+	result := &ImportResult{
+		IdMap: make(map[string]string),
+	}
+	err := db.WithTx(func(tx Database) error {
+		if err := importProjects(tx, userId, export.Projects, result); err != nil {
+			return err
+		}
+		page, err := tx.GetTasks(userId, TaskQuery{})
+		if err != nil {
+			return err
+		}
+		actions, err := tx.GetActions(idsOfTasks(page.Tasks), userId)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]*Task)
+		// Exported parent IDs of the created tasks, which are set once every task has its new ID
+		parents := make(map[string]string)
+		// Recurring tasks created, which are made recurring once their actions are added so that done
+		// actions don't complete them again
+		recurring := make(map[string]ExportTask)
+		// Exported blocker IDs of every imported task, which are also set once every task has its ID
+		blockers := make(map[string][]string)
+		for i := range page.Tasks {
+			task := &page.Tasks[i]
+			existing[task.Id] = task
+			existing[importKey(task.Kind, task.Title, task.CreatedAt)] = task
+		}
+
+		for _, exported := range export.Tasks {
+			kind, err := parseTaskKind(exported.Kind)
+			if err != nil {
+				return err
+			}
+			if exported.Title == "" {
+				return fmt.Errorf("Task \"%s\" has no title", exported.Id)
+			}
+
+			task, duplicate := existing[exported.Id]
+			if !duplicate {
+				task, duplicate = existing[importKey(kind, exported.Title, exported.CreatedAt)]
+			}
+			if duplicate {
+				result.TasksSkipped++
+			} else {
+				task = &Task{
+					Kind:      kind,
+					Title:     exported.Title,
+					Notes:     exported.Notes,
+					Done:      exported.Done,
+					CreatedAt: exported.CreatedAt,
+					UpdatedAt: exported.UpdatedAt,
+				}
+				if exported.ProjectId != nil {
+					if projectId, ok := result.IdMap[*exported.ProjectId]; ok {
+						task.ProjectId = &projectId
+					}
+				}
+				if kind == HabitEnum {
+					if task.Interval, err = parseInterval(exported.Interval); err != nil {
+						return err
+					}
+					task.Frequency = exported.Frequency
+				} else {
+					task.StartDate = exported.StartDate
+					task.EndDate = exported.EndDate
+					task.AutoComplete = exported.AutoComplete
+					if task.Priority, err = parsePriority(exported.Priority); err != nil {
+						return err
+					}
+				}
+				if err := tx.AddTask(task, userId); err != nil {
+					return err
+				}
+				existing[exported.Id] = task
+				existing[importKey(task.Kind, task.Title, task.CreatedAt)] = task
+				if kind == TaskEnum && exported.ParentId != nil {
+					parents[task.Id] = *exported.ParentId
+				}
+				if kind == TaskEnum && exported.Recurrence != "" {
+					if err := validateRecurrence(exported.Recurrence); err != nil {
+						return err
+					}
+					recurring[task.Id] = exported
+				}
+				result.TasksCreated++
+			}
+			result.IdMap[exported.Id] = task.Id
+			if len(exported.BlockedBy) > 0 {
+				blockers[task.Id] = exported.BlockedBy
+			}
+
+			for _, name := range exported.Tags {
+				if _, err := tx.AddTag(task.Id, name, userId); err != nil {
+					return err
+				}
+			}
+
+			existingActions := make(map[string]bool)
+			for _, action := range actions[task.Id] {
+				if action.When != nil {
+					existingActions[actionKey(action.Kind, *action.When)] = true
+				}
+			}
+			// Actions are added in time order so that timers start before they stop
+			exportedActions := append([]ExportAction{}, exported.Actions...)
+			sort.Stable(exportActionsByWhen(exportedActions))
+			for _, exportedAction := range exportedActions {
+				actionKind, err := parseActionKind(exportedAction.Kind)
+				if err != nil {
+					return err
+				}
+				if existingActions[actionKey(actionKind, exportedAction.When)] {
+					result.ActionsSkipped++
+					continue
+				}
+				when := exportedAction.When
+				action := &Action{
+					Kind:   actionKind,
+					When:   &when,
+					TaskId: task.Id,
+				}
+				if err := tx.AddAction(action, userId); err != nil {
+					return err
+				}
+				existingActions[actionKey(actionKind, when)] = true
+				result.IdMap[exportedAction.Id] = action.Id
+				result.ActionsCreated++
+			}
+		}
+
+		for taskId, exportedParentId := range parents {
+			parentId, ok := result.IdMap[exportedParentId]
+			if !ok {
+				continue
+			}
+			if _, err := tx.UpdateTask(taskId, userId, map[string]interface{}{"parent_id": &parentId}, nil); err != nil {
+				return err
+			}
+		}
+		for taskId, exportedBlockerIds := range blockers {
+			for _, exportedBlockerId := range exportedBlockerIds {
+				blockerId, ok := result.IdMap[exportedBlockerId]
+				if !ok {
+					continue
+				}
+				if err := tx.AddDependency(taskId, blockerId, userId); err != nil {
+					return err
+				}
+			}
+		}
+		for taskId, exported := range recurring {
+			attrs := map[string]interface{}{"recurrence": exported.Recurrence}
+			if exported.NextId != nil {
+				if nextId, ok := result.IdMap[*exported.NextId]; ok {
+					attrs["next_id"] = &nextId
+				}
+			}
+			if _, err := tx.UpdateTask(taskId, userId, attrs, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Adds the exported projects that the user doesn't have yet and maps every exported project ID to
+// the user's project in result.
+func importProjects(tx Database, userId uint64, exported []ExportProject, result *ImportResult) error {
+	projects, err := tx.GetProjects(userId, nil)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]string)
+	for _, project := range projects {
+		existing[project.Id] = project.Id
+		existing["name:"+project.Name] = project.Id
+	}
+
+	for _, exportedProject := range exported {
+		id, duplicate := existing[exportedProject.Id]
+		if !duplicate {
+			id, duplicate = existing["name:"+exportedProject.Name]
+		}
+		if duplicate {
+			result.ProjectsSkipped++
+		} else {
+			project := &Project{
+				Name:     exportedProject.Name,
+				Color:    exportedProject.Color,
+				Archived: exportedProject.Archived,
+				Position: exportedProject.Position,
+			}
+			if err := tx.AddProject(project, userId); err != nil {
+				return err
+			}
+			id = project.Id
+			existing["name:"+project.Name] = id
+			result.ProjectsCreated++
+		}
+		result.IdMap[exportedProject.Id] = id
+	}
+	return nil
+}
+
+// Authenticates a REST request by its bearer token and returns the user's ID. Writes an error
+// response and returns false if the token is missing or invalid.
+func authRestRequest(w rest.ResponseWriter, r *rest.Request) (uint64, bool) {
+	token, err := GetBearerToken(r.Request)
+	if err != nil {
+		rest.Error(w, err.Error(), http.StatusUnauthorized)
+		return 0, false
+	}
+	userId, err := AuthUserId(token)
+	if err != nil {
+		log.Printf("Error verifying token: %s", err.Error())
+		rest.Error(w, "Invalid token", http.StatusUnauthorized)
+		return 0, false
+	}
+	return userId, true
+}
+
+// Serves the user's data as JSON, or as CSV with ?format=csv.
+func ServeExport(db Database) func(rest.ResponseWriter, *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		userId, ok := authRestRequest(w, r)
+		if !ok {
+			return
+		}
+
+		export, err := ExportTasks(db, userId)
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			w.WriteJson(export)
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Header().Set("Content-Disposition", "attachment; filename=\"duet.csv\"")
+			if err := export.WriteCSV(w.(http.ResponseWriter)); err != nil {
+				log.Printf("Error writing CSV export: %s", err)
+			}
+		default:
+			rest.Error(w, "Format must be json or csv", http.StatusBadRequest)
+		}
+	}
+}
+
+// Imports a JSON export into the user's account and responds with the ImportResult.
+func ServeImport(db Database) func(rest.ResponseWriter, *rest.Request) {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		userId, ok := authRestRequest(w, r)
+		if !ok {
+			return
+		}
+
+		export := Export{}
+		if err := r.DecodeJsonPayload(&export); err != nil {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := ImportTasks(WithHistory(db, SourceREST), userId, &export)
+		if err != nil {
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteJson(result)
+	}
+}
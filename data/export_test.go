@@ -0,0 +1,170 @@
+package data
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+// Adds a project with a tagged task, its subtask, a task blocking it and a habit with an action.
+func addExportTasks(t *testing.T, db Database, userId uint64) {
+	project := &Project{Name: "Home"}
+	if err := db.AddProject(project, userId); err != nil {
+		t.Fatal(err)
+	}
+	parent := &Task{Title: "parent", ProjectId: &project.Id, Priority: PriorityHigh}
+	blocker := &Task{Title: "blocker"}
+	habit := &Task{Title: "habit", Kind: HabitEnum, Interval: Weekly, Frequency: 3}
+	addTestTasks(t, db, userId, parent, blocker, habit)
+	addTestTasks(t, db, userId, &Task{Title: "child", ParentId: &parent.Id})
+	if _, err := db.AddTag(parent.Id, "errand", userId); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddDependency(parent.Id, blocker.Id, userId); err != nil {
+		t.Fatal(err)
+	}
+	when := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	if err := db.AddAction(&Action{TaskId: habit.Id, Kind: ActionProgress, When: &when}, userId); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportAndImport(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		from, err := db.CreateUser("from", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		to, err := db.CreateUser("to", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addExportTasks(t, db, from.Id)
+		export, err := ExportTasks(db, from.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(export.Projects) != 1 || len(export.Tasks) != 4 {
+			t.Fatalf("%T: exported %d projects and %d tasks, want 1 and 4", db, len(export.Projects), len(export.Tasks))
+		}
+
+		result, err := ImportTasks(db, to.Id, export)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ProjectsCreated != 1 || result.TasksCreated != 4 || result.ActionsCreated != 1 {
+			t.Errorf("%T: first import returned %+v", db, result)
+		}
+		for _, task := range export.Tasks {
+			if newId := result.IdMap[task.Id]; newId == "" || newId == task.Id {
+				t.Errorf("%T: task %s was imported as %q, want a new ID", db, task.Id, newId)
+			}
+		}
+
+		page, err := db.GetTasks(to.Id, TaskQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := titlesOf(page.Tasks), []string{"parent", "blocker", "habit", "child"}; !equalStrings(got, want) {
+			t.Fatalf("%T: imported tasks are %v, want %v", db, got, want)
+		}
+		parent, child := page.Tasks[0], page.Tasks[3]
+		if child.ParentId == nil || *child.ParentId != parent.Id {
+			t.Errorf("%T: the imported subtask isn't a subtask of the imported parent", db)
+		}
+		if parent.ProjectId == nil || *parent.ProjectId != result.IdMap[*export.Tasks[0].ProjectId] {
+			t.Errorf("%T: the imported task isn't in the imported project", db)
+		}
+		tags, err := db.GetTaskTags([]string{parent.Id}, to.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tags[parent.Id]) != 1 || tags[parent.Id][0].Name != "errand" {
+			t.Errorf("%T: the imported task has tags %v, want [errand]", db, tags[parent.Id])
+		}
+		blockers, err := db.GetBlockers([]string{parent.Id}, to.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titlesOf(blockers[parent.Id]); !equalStrings(got, []string{"blocker"}) {
+			t.Errorf("%T: the imported task is blocked by %v, want [blocker]", db, got)
+		}
+
+		// Importing the same export again duplicates nothing
+		result, err = ImportTasks(db, to.Id, export)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ProjectsCreated != 0 || result.TasksCreated != 0 || result.ActionsCreated != 0 || result.TasksSkipped != 4 {
+			t.Errorf("%T: second import returned %+v", db, result)
+		}
+	}
+}
+
+func TestImportIsAllOrNothing(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		export := &Export{
+			Version: exportFormatVersion,
+			Tasks: []ExportTask{
+				{Id: "first", Kind: "task", Title: "valid"},
+				{Id: "second", Kind: "chore", Title: "invalid"},
+			},
+		}
+		if _, err := ImportTasks(db, user.Id, export); err == nil {
+			t.Errorf("%T: importing a task of an unknown kind succeeded", db)
+		}
+		page, err := db.GetTasks(user.Id, TaskQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Tasks) != 0 {
+			t.Errorf("%T: a failed import added %v", db, titlesOf(page.Tasks))
+		}
+
+		export.Version = exportFormatVersion + 1
+		export.Tasks = export.Tasks[:1]
+		if _, err := ImportTasks(db, user.Id, export); err == nil {
+			t.Errorf("%T: importing an unsupported version succeeded", db)
+		}
+	}
+}
+
+func TestExportWriteCSV(t *testing.T) {
+	db := NewMemoryDatabase()
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addExportTasks(t, db, user.Id)
+	export, err := ExportTasks(db, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := export.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []string{}
+	for _, row := range rows[1:] {
+		records = append(records, row[0])
+	}
+	if want := []string{"project", "task", "task", "habit", "action", "task"}; !equalStrings(records, want) {
+		t.Errorf("CSV has records %v, want %v", records, want)
+	}
+}
@@ -0,0 +1,639 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Where a change to a task came from.
+type HistorySource string
+
+const (
+	SourceGraphQL HistorySource = "graphql"
+	SourceREST    HistorySource = "rest"
+	SourceTodoist HistorySource = "todoist"
+	SourceCLI     HistorySource = "cli"
+	// Changes the server makes by itself, like purging old tasks from the trash
+	SourceRetention HistorySource = "retention"
+)
+
+type HistoryOperation string
+
+const (
+	OperationCreate       HistoryOperation = "create"
+	OperationUpdate       HistoryOperation = "update"
+	OperationDelete       HistoryOperation = "delete"
+	OperationRestore      HistoryOperation = "restore"
+	OperationPurge        HistoryOperation = "purge"
+	OperationAddAction    HistoryOperation = "add_action"
+	OperationDeleteAction HistoryOperation = "delete_action"
+	OperationAddTag       HistoryOperation = "add_tag"
+	OperationRemoveTag    HistoryOperation = "remove_tag"
+	// Changes to a tag itself are recorded on everything with the tag
+	OperationRenameTag HistoryOperation = "rename_tag"
+	OperationMergeTag  HistoryOperation = "merge_tag"
+	// Attachment changes record the attachment's filename
+	OperationAddAttachment    HistoryOperation = "add_attachment"
+	OperationRemoveAttachment HistoryOperation = "remove_attachment"
+	// Dependency changes are recorded on the blocked task along with the task blocking it
+	OperationAddDependency    HistoryOperation = "add_dependency"
+	OperationRemoveDependency HistoryOperation = "remove_dependency"
+	// Announces that a task is no longer blocked because the last of its blockers was completed,
+	// deleted or removed. Only published as a change event and not recorded in history.
+	OperationUnblock HistoryOperation = "unblock"
+)
+
+// An append-only record of a single change made to a task.
+type HistoryEntry struct {
+	Id        string           `json:"id" gorm:"primary_key;type:uuid"`
+	CreatedAt time.Time        `json:"created_at"`
+	TaskId    string           `json:"task_id" gorm:"not_null;type:uuid"`
+	UserId    uint64           `json:"user_id" gorm:"not_null"`
+	Source    HistorySource    `json:"source" gorm:"not_null"`
+	Operation HistoryOperation `json:"operation" gorm:"not_null"`
+	// JSON encoded []FieldChange
+	Changes string `json:"-" gorm:"type:text"`
+}
+
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+func (entry *HistoryEntry) BeforeCreate(scope *gorm.Scope) error {
+	if entry.Id == "" {
+		return scope.SetColumn("Id", newUUID())
+	}
+	return nil
+}
+
+func (entry *HistoryEntry) FieldChanges() ([]FieldChange, error) {
+	changes := []FieldChange{}
+	if entry.Changes == "" {
+		return changes, nil
+	}
+	if err := json.Unmarshal([]byte(entry.Changes), &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (entry *HistoryEntry) setFieldChanges(changes []FieldChange) error {
+	if len(changes) == 0 {
+		entry.Changes = ""
+		return nil
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	entry.Changes = string(encoded)
+	return nil
+}
+
+func historyTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func historyString(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+type historyField struct {
+	name  string
+	value interface{}
+}
+
+// The fields of a task that are tracked in its history.
+func historyFields(task *Task) []historyField {
+	return []historyField{
+		{"kind", int(task.Kind)},
+		{"title", task.Title},
+		{"notes", task.Notes},
+		{"done", task.Done},
+		{"start_date", historyTime(task.StartDate)},
+		{"end_date", historyTime(task.EndDate)},
+		{"interval", int(task.Interval)},
+		{"frequency", task.Frequency},
+		{"parent_id", historyString(task.ParentId)},
+		{"auto_complete", task.AutoComplete},
+		{"project_id", historyString(task.ProjectId)},
+		{"priority", int(task.Priority)},
+		{"position", task.Position},
+		{"recurrence", task.Recurrence},
+		{"next_id", historyString(task.NextId)},
+	}
+}
+
+// Lists the fields that differ between two versions of a task. Either version may be nil.
+func diffTasks(before *Task, after *Task) []FieldChange {
+	var beforeFields, afterFields []historyField
+	if before != nil {
+		beforeFields = historyFields(before)
+	}
+	if after != nil {
+		afterFields = historyFields(after)
+	}
+
+	changes := []FieldChange{}
+	for i := 0; i < len(beforeFields) || i < len(afterFields); i++ {
+		var change FieldChange
+		if i < len(beforeFields) {
+			change.Field = beforeFields[i].name
+			change.Before = beforeFields[i].value
+		}
+		if i < len(afterFields) {
+			change.Field = afterFields[i].name
+			change.After = afterFields[i].value
+		}
+		if change.Before != change.After {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// historyDB records every change made through it to the history of the affected task. Each
+// change and its history entry are written in the same transaction.
+type historyDB struct {
+	Database
+	source HistorySource
+}
+
+// Wraps db so that changes made through it are recorded as coming from source.
+func WithHistory(db Database, source HistorySource) Database {
+	if db, ok := db.(historyDB); ok {
+		return historyDB{db.Database, source}
+	}
+	return historyDB{db, source}
+}
+
+func (db historyDB) record(tx Database, taskId string, userId uint64, operation HistoryOperation, changes []FieldChange) error {
+	entry := &HistoryEntry{
+		TaskId:    taskId,
+		UserId:    userId,
+		Source:    db.source,
+		Operation: operation,
+	}
+	if err := entry.setFieldChanges(changes); err != nil {
+		return err
+	}
+	return tx.AddHistory(entry)
+}
+
+// Records the updates that a change cascaded to the given tasks, as they were before it.
+func (db historyDB) recordCascade(tx Database, before []Task, userId uint64) error {
+	updates, err := cascadedUpdates(tx, before, userId)
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		if err := db.record(tx, update.after.Id, userId, OperationUpdate, diffTasks(update.before, update.after)); err != nil {
+			return err
+		}
+		if err := db.recordOccurrence(tx, update.before, update.after, userId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Records the creation of the next occurrence of a recurring task if the change completed it.
+func (db historyDB) recordOccurrence(tx Database, before *Task, after *Task, userId uint64) error {
+	occurrence, err := generatedOccurrence(tx, before, after, userId)
+	if err != nil || occurrence == nil {
+		return err
+	}
+	return db.record(tx, occurrence.Id, userId, OperationCreate, diffTasks(nil, occurrence))
+}
+
+func (db historyDB) WithTx(fn func(Database) error) error {
+	return db.Database.WithTx(func(tx Database) error {
+		return fn(historyDB{tx, db.source})
+	})
+}
+
+func (db historyDB) AddTask(task *Task, userId uint64) error {
+	return db.Database.WithTx(func(tx Database) error {
+		ancestors, err := ancestorsOf(tx, userId, task.ParentId)
+		if err != nil {
+			return err
+		}
+		if err := tx.AddTask(task, userId); err != nil {
+			return err
+		}
+		if err := db.record(tx, task.Id, userId, OperationCreate, diffTasks(nil, task)); err != nil {
+			return err
+		}
+		return db.recordCascade(tx, ancestors, userId)
+	})
+}
+
+func (db historyDB) UpdateTask(taskId string, userId uint64, attrs map[string]interface{}, expectedVersion *int) (*Task, error) {
+	var task *Task
+	err := db.Database.WithTx(func(tx Database) error {
+		before, err := tx.GetTask(taskId, userId, nil)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		var ancestors []Task
+		if err == nil {
+			parentId, _ := attrs["parent_id"].(*string)
+			ancestors, err = ancestorsOf(tx, userId, before.ParentId, parentId)
+			if err != nil {
+				return err
+			}
+		}
+		task, err = tx.UpdateTask(taskId, userId, attrs, expectedVersion)
+		if err != nil {
+			return err
+		}
+		if err := db.record(tx, taskId, userId, OperationUpdate, diffTasks(before, task)); err != nil {
+			return err
+		}
+		if err := db.recordOccurrence(tx, before, task, userId); err != nil {
+			return err
+		}
+		return db.recordCascade(tx, ancestors, userId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (db historyDB) AddTasks(tasks []*Task, userId uint64) ([]error, error) {
+	return addTasks(db, tasks, userId)
+}
+
+func (db historyDB) UpdateTasks(updates []TaskUpdate, userId uint64) ([]*Task, []error, error) {
+	return updateTasks(db, updates, userId)
+}
+
+func (db historyDB) DeleteTasks(taskIds []string, userId uint64) ([]error, error) {
+	return deleteTasks(db, taskIds, userId)
+}
+
+func (db historyDB) AddActions(actions []*Action, userId uint64) ([]error, error) {
+	return addActions(db, actions, userId)
+}
+
+func (db historyDB) DeleteTask(taskId string, userId uint64) (bool, error) {
+	deleted := false
+	err := db.Database.WithTx(func(tx Database) error {
+		task, err := tx.GetTask(taskId, userId, nil)
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		descendants, err := liveDescendants(tx, taskId, userId)
+		if err != nil {
+			return err
+		}
+		ancestors, err := ancestorsOf(tx, userId, task.ParentId)
+		if err != nil {
+			return err
+		}
+		deleted, err = tx.DeleteTask(taskId, userId)
+		if err != nil || !deleted {
+			return err
+		}
+		for _, id := range append([]string{taskId}, idsOfTasks(descendants)...) {
+			if err := db.record(tx, id, userId, OperationDelete, nil); err != nil {
+				return err
+			}
+		}
+		return db.recordCascade(tx, ancestors, userId)
+	})
+	return deleted, err
+}
+
+func (db historyDB) RestoreTask(taskId string, userId uint64) (*Task, error) {
+	var task *Task
+	err := db.Database.WithTx(func(tx Database) error {
+		descendants, err := trashedDescendants(tx, taskId, userId)
+		if err != nil {
+			return err
+		}
+		task, err = tx.RestoreTask(taskId, userId)
+		if err != nil {
+			return err
+		}
+		for _, id := range append([]string{taskId}, idsOfTasks(descendants)...) {
+			if err := db.record(tx, id, userId, OperationRestore, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (db historyDB) PurgeTask(taskId string, userId uint64) (bool, error) {
+	purged := false
+	err := db.Database.WithTx(func(tx Database) error {
+		descendants, err := trashedDescendants(tx, taskId, userId)
+		if err != nil {
+			return err
+		}
+		purged, err = tx.PurgeTask(taskId, userId)
+		if err != nil || !purged {
+			return err
+		}
+		for _, id := range append([]string{taskId}, idsOfTasks(descendants)...) {
+			if err := db.record(tx, id, userId, OperationPurge, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return purged, err
+}
+
+func (db historyDB) PurgeDeletedTasks(deletedBefore time.Time) ([]Task, error) {
+	var purged []Task
+	err := db.Database.WithTx(func(tx Database) error {
+		var err error
+		if purged, err = tx.PurgeDeletedTasks(deletedBefore); err != nil {
+			return err
+		}
+		for _, task := range purged {
+			if err := db.record(tx, task.Id, task.UserId, OperationPurge, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// Also records the completion of a recurring task that a done action caused.
+func (db historyDB) AddAction(action *Action, userId uint64) error {
+	return db.Database.WithTx(func(tx Database) error {
+		task, err := tx.GetTask(action.TaskId, userId, nil)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		var before []Task
+		if task != nil {
+			ancestors, err := ancestorsOf(tx, userId, task.ParentId)
+			if err != nil {
+				return err
+			}
+			before = append([]Task{*task}, ancestors...)
+		}
+		if err := tx.AddAction(action, userId); err != nil {
+			return err
+		}
+		err = db.record(tx, action.TaskId, userId, OperationAddAction, []FieldChange{
+			{Field: "action_id", After: action.Id},
+			{Field: "action_kind", After: int(action.Kind)},
+			{Field: "action_when", After: historyTime(action.When)},
+		})
+		if err != nil {
+			return err
+		}
+		return db.recordCascade(tx, before, userId)
+	})
+}
+
+func (db historyDB) DeleteAction(id string, userId uint64) error {
+	return db.Database.WithTx(func(tx Database) error {
+		action, err := tx.GetAction(id, userId)
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteAction(id, userId); err != nil {
+			return err
+		}
+		return db.record(tx, action.TaskId, userId, OperationDeleteAction, []FieldChange{
+			{Field: "action_id", Before: action.Id},
+			{Field: "action_kind", Before: int(action.Kind)},
+			{Field: "action_when", Before: historyTime(action.When)},
+		})
+	})
+}
+
+// Records the tasks and habits that deleting the project moved out of it.
+func (db historyDB) DeleteProject(projectId string, userId uint64) (bool, error) {
+	deleted := false
+	err := db.Database.WithTx(func(tx Database) error {
+		page, err := tx.GetTasks(userId, TaskQuery{ProjectId: &projectId})
+		if err != nil {
+			return err
+		}
+		deleted, err = tx.DeleteProject(projectId, userId)
+		if err != nil || !deleted {
+			return err
+		}
+		return db.recordCascade(tx, page.Tasks, userId)
+	})
+	return deleted, err
+}
+
+// Only records tagging a task or habit that didn't already have the tag.
+func (db historyDB) AddTag(taskId string, name string, userId uint64) (*Tag, error) {
+	var tag *Tag
+	err := db.Database.WithTx(func(tx Database) error {
+		before, err := tx.GetTaskTags([]string{taskId}, userId)
+		if err != nil {
+			return err
+		}
+		if tag, err = tx.AddTag(taskId, name, userId); err != nil {
+			return err
+		}
+		for _, existing := range before[taskId] {
+			if existing.Id == tag.Id {
+				return nil
+			}
+		}
+		return db.record(tx, taskId, userId, OperationAddTag, []FieldChange{
+			{Field: "tag", After: tag.Name},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (db historyDB) RemoveTag(taskId string, name string, userId uint64) (bool, error) {
+	removed := false
+	err := db.Database.WithTx(func(tx Database) error {
+		var err error
+		removed, err = tx.RemoveTag(taskId, name, userId)
+		if err != nil || !removed {
+			return err
+		}
+		return db.record(tx, taskId, userId, OperationRemoveTag, []FieldChange{
+			{Field: "tag", Before: name},
+		})
+	})
+	return removed, err
+}
+
+func (db historyDB) RenameTag(tagId string, name string, userId uint64) (*Tag, error) {
+	var renamed *Tag
+	err := db.Database.WithTx(func(tx Database) error {
+		tag, taskIds, err := taggedTasks(tx, tagId, userId)
+		if err != nil {
+			return err
+		}
+		if renamed, err = tx.RenameTag(tagId, name, userId); err != nil {
+			return err
+		}
+		if renamed.Name == tag.Name {
+			return nil
+		}
+		for _, taskId := range taskIds {
+			err := db.record(tx, taskId, userId, OperationRenameTag, []FieldChange{
+				{Field: "tag", Before: tag.Name, After: renamed.Name},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return renamed, nil
+}
+
+// Records the source tag being replaced by the target on everything that had the source tag.
+func (db historyDB) MergeTags(sourceId string, targetId string, userId uint64) (*Tag, error) {
+	var target *Tag
+	err := db.Database.WithTx(func(tx Database) error {
+		source, taskIds, err := taggedTasks(tx, sourceId, userId)
+		if err != nil {
+			return err
+		}
+		if target, err = tx.MergeTags(sourceId, targetId, userId); err != nil {
+			return err
+		}
+		for _, taskId := range taskIds {
+			err := db.record(tx, taskId, userId, OperationMergeTag, []FieldChange{
+				{Field: "tag", Before: source.Name, After: target.Name},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// Records the tag being removed from everything that had it.
+func (db historyDB) DeleteTag(tagId string, userId uint64) (bool, error) {
+	deleted := false
+	err := db.Database.WithTx(func(tx Database) error {
+		tag, taskIds, err := taggedTasks(tx, tagId, userId)
+		if err != nil {
+			return err
+		}
+		deleted, err = tx.DeleteTag(tagId, userId)
+		if err != nil || !deleted {
+			return err
+		}
+		for _, taskId := range taskIds {
+			err := db.record(tx, taskId, userId, OperationRemoveTag, []FieldChange{
+				{Field: "tag", Before: tag.Name},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+func (db historyDB) AddAttachment(attachment *Attachment, quota int64, userId uint64) error {
+	return db.Database.WithTx(func(tx Database) error {
+		if err := tx.AddAttachment(attachment, quota, userId); err != nil {
+			return err
+		}
+		return db.record(tx, attachment.TaskId, userId, OperationAddAttachment, []FieldChange{
+			{Field: "attachment", After: attachment.Filename},
+		})
+	})
+}
+
+func (db historyDB) DeleteAttachment(attachmentId string, userId uint64) (bool, error) {
+	deleted := false
+	err := db.Database.WithTx(func(tx Database) error {
+		attachment, err := tx.GetAttachment(attachmentId, userId)
+		if err == gorm.ErrRecordNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		deleted, err = tx.DeleteAttachment(attachmentId, userId)
+		if err != nil || !deleted {
+			return err
+		}
+		return db.record(tx, attachment.TaskId, userId, OperationRemoveAttachment, []FieldChange{
+			{Field: "attachment", Before: attachment.Filename},
+		})
+	})
+	return deleted, err
+}
+
+// Only records blocking a task by a task that didn't already block it.
+func (db historyDB) AddDependency(taskId string, blockedById string, userId uint64) error {
+	return db.Database.WithTx(func(tx Database) error {
+		before, err := tx.GetBlockers([]string{taskId}, userId)
+		if err != nil {
+			return err
+		}
+		if err := tx.AddDependency(taskId, blockedById, userId); err != nil {
+			return err
+		}
+		for _, blocker := range before[taskId] {
+			if blocker.Id == blockedById {
+				return nil
+			}
+		}
+		return db.record(tx, taskId, userId, OperationAddDependency, []FieldChange{
+			{Field: "blocked_by", After: blockedById},
+		})
+	})
+}
+
+func (db historyDB) RemoveDependency(taskId string, blockedById string, userId uint64) (bool, error) {
+	removed := false
+	err := db.Database.WithTx(func(tx Database) error {
+		var err error
+		removed, err = tx.RemoveDependency(taskId, blockedById, userId)
+		if err != nil || !removed {
+			return err
+		}
+		return db.record(tx, taskId, userId, OperationRemoveDependency, []FieldChange{
+			{Field: "blocked_by", Before: blockedById},
+		})
+	})
+	return removed, err
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func operationsOf(entries []HistoryEntry) []HistoryOperation {
+	operations := []HistoryOperation{}
+	for _, entry := range entries {
+		operations = append(operations, entry.Operation)
+	}
+	return operations
+}
+
+func TestHistoryRecordsChanges(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, base := range dbs {
+		db := WithHistory(base, SourceGraphQL)
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := db.CreateUser("other", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		task := &Task{Title: "before"}
+		addTestTasks(t, db, user.Id, task)
+		if _, err := db.UpdateTask(task.Id, user.Id, map[string]interface{}{"title": "after"}, nil); err != nil {
+			t.Fatal(err)
+		}
+		// Failed changes aren't recorded
+		stale := 1
+		if _, err := db.UpdateTask(task.Id, user.Id, map[string]interface{}{"title": "stale"}, &stale); err == nil {
+			t.Fatalf("%T: UpdateTask with a stale version succeeded", base)
+		}
+		when := time.Now()
+		if err := db.AddAction(&Action{TaskId: task.Id, When: &when}, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.DeleteTask(task.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.PurgeTask(task.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		// History outlives the task
+		entries, err := db.GetHistory(task.Id, user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []HistoryOperation{OperationCreate, OperationUpdate, OperationAddAction, OperationDelete, OperationPurge}
+		if got := operationsOf(entries); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%T: history has operations %v, want %v", base, got, want)
+		}
+		for _, entry := range entries {
+			if entry.Source != SourceGraphQL || entry.UserId != user.Id {
+				t.Errorf("%T: %s entry has source %q and user %d", base, entry.Operation, entry.Source, entry.UserId)
+			}
+		}
+		changes, err := entries[1].FieldChanges()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []FieldChange{{"title", "before", "after"}}; !reflect.DeepEqual(changes, want) {
+			t.Errorf("%T: update recorded %v, want %v", base, changes, want)
+		}
+
+		entries, err = db.GetHistory(task.Id, other.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("%T: another user can see %d history entries", base, len(entries))
+		}
+	}
+}
+
+func TestHistoryRecordsRetentionPurges(t *testing.T) {
+	db := WithHistory(NewMemoryDatabase(), SourceRetention)
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Title: "deleted"}
+	addTestTasks(t, db, user.Id, task)
+	if _, err := db.DeleteTask(task.Id, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.PurgeDeletedTasks(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := db.GetHistory(task.Id, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].Operation != OperationPurge || entries[2].Source != SourceRetention {
+		t.Errorf("History is %+v, want it to end with a purge by retention", entries)
+	}
+}
+
+func TestDiffTasks(t *testing.T) {
+	parentId := "parent"
+	tests := []struct {
+		before *Task
+		after  *Task
+		want   []FieldChange
+	}{
+		{&Task{Title: "same"}, &Task{Title: "same"}, []FieldChange{}},
+		{&Task{Done: false}, &Task{Done: true}, []FieldChange{{"done", false, true}}},
+		{&Task{}, &Task{ParentId: &parentId}, []FieldChange{{"parent_id", nil, "parent"}}},
+	}
+	for _, test := range tests {
+		if got := diffTasks(test.before, test.after); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("diffTasks(%+v, %+v) = %v, want %v", test.before, test.after, got, test.want)
+		}
+	}
+
+	// Creation records every field that isn't null
+	created := diffTasks(nil, &Task{Title: "new"})
+	if len(created) < 2 || created[1] != (FieldChange{"title", nil, "new"}) {
+		t.Errorf("diffTasks(nil, task) = %v, want the title to be recorded", created)
+	}
+	for _, change := range created {
+		if change.After == nil {
+			t.Errorf("diffTasks(nil, task) recorded the null field %s", change.Field)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package data
+
+import (
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"golang.org/x/net/context"
+)
+
+type loaderKey int
+
+const taskLoaderKey loaderKey = 0
+
+// TaskLoader batches the loading of actions, tags, attachments, reminders, dependencies and subtasks
+// for the tasks resolved during a single GraphQL request. Resolvers that return tasks prime the
+// loader with them, and the first task whose actions, tags, attachments, reminders, blockers,
+// dependents or subtasks are selected loads those of every primed task in one query.
+type TaskLoader struct {
+	mu                 sync.Mutex
+	db                 Database
+	userId             uint64
+	pendingActions     map[string]bool
+	actions            map[string][]Action
+	pendingTags        map[string]bool
+	tags               map[string][]Tag
+	pendingAttachments map[string]bool
+	attachments        map[string][]Attachment
+	pendingReminders   map[string]bool
+	reminders          map[string][]Reminder
+	pendingBlockers    map[string]bool
+	blockers           map[string][]Task
+	pendingDependents  map[string]bool
+	dependents         map[string][]Task
+	pendingSubtasks    map[string]bool
+	subtasks           map[string][]Task
+}
+
+func NewTaskLoader(db Database, userId uint64) *TaskLoader {
+	return &TaskLoader{
+		db:                 db,
+		userId:             userId,
+		pendingActions:     make(map[string]bool),
+		actions:            make(map[string][]Action),
+		pendingTags:        make(map[string]bool),
+		tags:               make(map[string][]Tag),
+		pendingAttachments: make(map[string]bool),
+		attachments:        make(map[string][]Attachment),
+		pendingReminders:   make(map[string]bool),
+		reminders:          make(map[string][]Reminder),
+		pendingBlockers:    make(map[string]bool),
+		blockers:           make(map[string][]Task),
+		pendingDependents:  make(map[string]bool),
+		dependents:         make(map[string][]Task),
+		pendingSubtasks:    make(map[string]bool),
+		subtasks:           make(map[string][]Task),
+	}
+}
+
+// Returns a context that carries the loader, for use as the context of a GraphQL request.
+func WithTaskLoader(ctx context.Context, loader *TaskLoader) context.Context {
+	return context.WithValue(ctx, taskLoaderKey, loader)
+}
+
+// Returns the request's loader, or a new one that batches nothing if the request has none.
+func taskLoaderOfContext(p graphql.ResolveParams, db Database) *TaskLoader {
+	if loader, ok := p.Context.Value(taskLoaderKey).(*TaskLoader); ok {
+		return loader
+	}
+	return NewTaskLoader(db, userIdOfContext(p))
+}
+
+func idsOfTasks(tasks []Task) []string {
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.Id
+	}
+	return ids
+}
+
+// Adds the task to the pending IDs and returns all of them.
+func takePending(pending map[string]bool, taskId string) []string {
+	pending[taskId] = true
+	ids := []string{}
+	for id := range pending {
+		ids = append(ids, id)
+		delete(pending, id)
+	}
+	return ids
+}
+
+// Queues the tasks so their actions, tags, attachments, reminders, dependencies and subtasks are
+// loaded together with the next batch.
+func (loader *TaskLoader) Prime(tasks ...Task) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	for _, task := range tasks {
+		if _, ok := loader.actions[task.Id]; !ok {
+			loader.pendingActions[task.Id] = true
+		}
+		if _, ok := loader.tags[task.Id]; !ok {
+			loader.pendingTags[task.Id] = true
+		}
+		if _, ok := loader.attachments[task.Id]; !ok {
+			loader.pendingAttachments[task.Id] = true
+		}
+		if _, ok := loader.reminders[task.Id]; !ok {
+			loader.pendingReminders[task.Id] = true
+		}
+		if _, ok := loader.blockers[task.Id]; !ok {
+			loader.pendingBlockers[task.Id] = true
+		}
+		if _, ok := loader.dependents[task.Id]; !ok {
+			loader.pendingDependents[task.Id] = true
+		}
+		if _, ok := loader.subtasks[task.Id]; !ok {
+			loader.pendingSubtasks[task.Id] = true
+		}
+	}
+}
+
+// Returns the actions of a task, loading them along with those of every primed task if needed.
+func (loader *TaskLoader) LoadActions(taskId string) ([]Action, error) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	if actions, ok := loader.actions[taskId]; ok {
+		return actions, nil
+	}
+
+	ids := takePending(loader.pendingActions, taskId)
+	actions, err := loader.db.GetActions(ids, loader.userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if actions[id] == nil {
+			actions[id] = []Action{}
+		}
+		loader.actions[id] = actions[id]
+	}
+	return loader.actions[taskId], nil
+}
+
+// Returns the tags of a task, loading them along with those of every primed task if needed.
+func (loader *TaskLoader) LoadTags(taskId string) ([]Tag, error) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	if tags, ok := loader.tags[taskId]; ok {
+		return tags, nil
+	}
+
+	ids := takePending(loader.pendingTags, taskId)
+	tags, err := loader.db.GetTaskTags(ids, loader.userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if tags[id] == nil {
+			tags[id] = []Tag{}
+		}
+		loader.tags[id] = tags[id]
+	}
+	return loader.tags[taskId], nil
+}
+
+// Returns the attachments of a task, loading them along with those of every primed task if needed.
+func (loader *TaskLoader) LoadAttachments(taskId string) ([]Attachment, error) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	if attachments, ok := loader.attachments[taskId]; ok {
+		return attachments, nil
+	}
+
+	ids := takePending(loader.pendingAttachments, taskId)
+	attachments, err := loader.db.GetAttachments(ids, loader.userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if attachments[id] == nil {
+			attachments[id] = []Attachment{}
+		}
+		loader.attachments[id] = attachments[id]
+	}
+	return loader.attachments[taskId], nil
+}
+
+// Returns the reminders of a task, loading them along with those of every primed task if needed.
+func (loader *TaskLoader) LoadReminders(taskId string) ([]Reminder, error) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	if reminders, ok := loader.reminders[taskId]; ok {
+		return reminders, nil
+	}
+
+	ids := takePending(loader.pendingReminders, taskId)
+	reminders, err := loader.db.GetReminders(ids, loader.userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if reminders[id] == nil {
+			reminders[id] = []Reminder{}
+		}
+		loader.reminders[id] = reminders[id]
+	}
+	return loader.reminders[taskId], nil
+}
+
+// Returns the live tasks that block a task, loading them along with those of every primed task if
+// needed.
+func (loader *TaskLoader) LoadBlockers(taskId string) ([]Task, error) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	if blockers, ok := loader.blockers[taskId]; ok {
+		return blockers, nil
+	}
+
+	ids := takePending(loader.pendingBlockers, taskId)
+	blockers, err := loader.db.GetBlockers(ids, loader.userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if blockers[id] == nil {
+			blockers[id] = []Task{}
+		}
+		loader.blockers[id] = blockers[id]
+	}
+	return loader.blockers[taskId], nil
+}
+
+// Returns the live tasks that a task blocks, loading them along with those of every primed task if
+// needed.
+func (loader *TaskLoader) LoadDependents(taskId string) ([]Task, error) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	if dependents, ok := loader.dependents[taskId]; ok {
+		return dependents, nil
+	}
+
+	ids := takePending(loader.pendingDependents, taskId)
+	dependents, err := loader.db.GetDependents(ids, loader.userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if dependents[id] == nil {
+			dependents[id] = []Task{}
+		}
+		loader.dependents[id] = dependents[id]
+	}
+	return loader.dependents[taskId], nil
+}
+
+// Returns the direct subtasks of a task, oldest first, loading them along with those of every primed
+// task if needed.
+func (loader *TaskLoader) LoadSubtasks(taskId string) ([]Task, error) {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	if subtasks, ok := loader.subtasks[taskId]; ok {
+		return subtasks, nil
+	}
+
+	ids := takePending(loader.pendingSubtasks, taskId)
+	page, err := loader.db.GetTasks(loader.userId, TaskQuery{ParentIds: ids})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		loader.subtasks[id] = []Task{}
+	}
+	for _, task := range page.Tasks {
+		loader.subtasks[*task.ParentId] = append(loader.subtasks[*task.ParentId], task)
+	}
+	return loader.subtasks[taskId], nil
+}
+
+// Forgets everything loaded so it is loaded again when next selected. Called after mutations that
+// change actions, tags, reminders, dependencies or subtasks so that later fields in the same request
+// see the change.
+func (loader *TaskLoader) Clear() {
+	loader.mu.Lock()
+	defer loader.mu.Unlock()
+
+	for id := range loader.actions {
+		loader.pendingActions[id] = true
+	}
+	for id := range loader.tags {
+		loader.pendingTags[id] = true
+	}
+	for id := range loader.attachments {
+		loader.pendingAttachments[id] = true
+	}
+	for id := range loader.reminders {
+		loader.pendingReminders[id] = true
+	}
+	for id := range loader.blockers {
+		loader.pendingBlockers[id] = true
+	}
+	for id := range loader.dependents {
+		loader.pendingDependents[id] = true
+	}
+	for id := range loader.subtasks {
+		loader.pendingSubtasks[id] = true
+	}
+	loader.actions = make(map[string][]Action)
+	loader.tags = make(map[string][]Tag)
+	loader.attachments = make(map[string][]Attachment)
+	loader.reminders = make(map[string][]Reminder)
+	loader.blockers = make(map[string][]Task)
+	loader.dependents = make(map[string][]Task)
+	loader.subtasks = make(map[string][]Task)
+}
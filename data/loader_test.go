@@ -0,0 +1,109 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"golang.org/x/net/context"
+)
+
+// Counts the calls to GetActions.
+type countingActionsDB struct {
+	Database
+	calls *int
+}
+
+func (db countingActionsDB) GetActions(taskIds []string, userId uint64) (map[string][]Action, error) {
+	*db.calls++
+	return db.Database.GetActions(taskIds, userId)
+}
+
+// Adds tasks that each have an action, and returns them.
+func addTasksWithActions(t *testing.T, db Database, userId uint64, count int) []Task {
+	tasks := []Task{}
+	when := time.Now()
+	for i := 0; i < count; i++ {
+		task := &Task{Title: "task"}
+		addTestTasks(t, db, userId, task)
+		if err := db.AddAction(&Action{TaskId: task.Id, When: &when}, userId); err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, *task)
+	}
+	return tasks
+}
+
+func TestTaskLoaderBatchesActions(t *testing.T) {
+	calls := 0
+	db := countingActionsDB{NewMemoryDatabase(), &calls}
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tasks := addTasksWithActions(t, db, user.Id, 3)
+	other := addTasksWithActions(t, db, user.Id, 1)[0]
+
+	loader := NewTaskLoader(db, user.Id)
+	loader.Prime(tasks...)
+	for _, task := range tasks {
+		actions, err := loader.LoadActions(task.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actions) != 1 || actions[0].TaskId != task.Id {
+			t.Errorf("LoadActions(%s) returned %v", task.Id, actions)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Loading the actions of 3 primed tasks took %d queries, want 1", calls)
+	}
+
+	// Tasks that weren't primed are loaded on their own
+	if actions, err := loader.LoadActions(other.Id); err != nil || len(actions) != 1 {
+		t.Errorf("LoadActions of a task that wasn't primed returned %v, %v", actions, err)
+	}
+	if calls != 2 {
+		t.Errorf("Loading the actions of a task that wasn't primed took %d queries, want 1", calls-1)
+	}
+
+	loader.Clear()
+	if _, err := loader.LoadActions(tasks[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("Actions weren't loaded again after clearing the loader")
+	}
+}
+
+func TestTaskLoaderLoadsOnlySelectedActions(t *testing.T) {
+	calls := 0
+	db := countingActionsDB{NewMemoryDatabase(), &calls}
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTasksWithActions(t, db, user.Id, 3)
+	schema := GetSchema(db)
+
+	tests := []struct {
+		query string
+		calls int
+	}{
+		{`{ tasks { id } }`, 0},
+		{`{ tasks { id actions { id } } }`, 1},
+		{`{ tasks { actions { id } } tasksConnection { edges { node { actions { id } } } } }`, 1},
+	}
+	for _, test := range tests {
+		calls = 0
+		ctx := context.WithValue(context.Background(), UserIdKey, user.Id)
+		ctx = WithTaskLoader(ctx, NewTaskLoader(db, user.Id))
+		result := graphql.Do(graphql.Params{Schema: *schema, RequestString: test.query, Context: ctx})
+		if len(result.Errors) > 0 {
+			t.Fatalf("%s failed: %v", test.query, result.Errors)
+		}
+		if calls != test.calls {
+			t.Errorf("%s loaded actions %d times, want %d", test.query, calls, test.calls)
+		}
+	}
+}
@@ -0,0 +1,1480 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memoryDB is a Database that keeps everything in process memory. It is meant for tests and
+// local demos where Postgres isn't available, and mirrors gormDB's behavior including soft
+// deletion of tasks and scoping of tasks and actions to their user.
+type memoryDB struct {
+	mu *sync.RWMutex
+	// Set on the Database passed to a WithTx callback, which runs with mu already held
+	inTx bool
+	// The functions that undo the changes made so far in the transaction, in order
+	undo *[]func()
+	*memoryStore
+}
+
+type memoryStore struct {
+	tasks       map[string]*Task
+	taskOrder   []string
+	actions     map[string]*Action
+	actionOrder []string
+	users       map[uint64]*User
+	nextUserId  uint64
+	history     []HistoryEntry
+	projects    map[string]*Project
+	tags        map[string]*Tag
+	// The IDs of each task's tags
+	taskTags        map[string]map[string]bool
+	attachments     map[string]*Attachment
+	attachmentOrder []string
+	reminders       map[string]*Reminder
+	reminderOrder   []string
+	// The IDs of the tasks each task is blocked by
+	dependencies map[string]map[string]bool
+}
+
+func NewMemoryDatabase() Database {
+	return &memoryDB{
+		mu: &sync.RWMutex{},
+		memoryStore: &memoryStore{
+			tasks:        make(map[string]*Task),
+			actions:      make(map[string]*Action),
+			users:        make(map[uint64]*User),
+			nextUserId:   1,
+			projects:     make(map[string]*Project),
+			tags:         make(map[string]*Tag),
+			taskTags:     make(map[string]map[string]bool),
+			attachments:  make(map[string]*Attachment),
+			reminders:    make(map[string]*Reminder),
+			dependencies: make(map[string]map[string]bool),
+		},
+	}
+}
+
+func (db *memoryDB) Close() error {
+	return nil
+}
+
+// Locks the database for writing and returns the function that unlocks it.
+func (db *memoryDB) lock() func() {
+	if db.inTx {
+		return func() {}
+	}
+	db.mu.Lock()
+	return db.mu.Unlock
+}
+
+// Locks the database for reading and returns the function that unlocks it.
+func (db *memoryDB) rlock() func() {
+	if db.inTx {
+		return func() {}
+	}
+	db.mu.RLock()
+	return db.mu.RUnlock
+}
+
+// Records how to undo a change to the store, so a failed transaction can be rolled back. Outside
+// a transaction there is nothing to roll back.
+func (db *memoryDB) onRollback(undo func()) {
+	if db.undo != nil {
+		*db.undo = append(*db.undo, undo)
+	}
+}
+
+// The save functions record the current state of an entry before it is added, changed or
+// removed. Callers must hold the lock.
+
+func (db *memoryDB) saveTask(id string) {
+	task, ok := db.tasks[id]
+	if !ok {
+		db.onRollback(func() { delete(db.tasks, id) })
+		return
+	}
+	saved := *task
+	db.onRollback(func() {
+		*task = saved
+		db.tasks[id] = task
+	})
+}
+
+func (db *memoryDB) saveAction(id string) {
+	action, ok := db.actions[id]
+	if !ok {
+		db.onRollback(func() { delete(db.actions, id) })
+		return
+	}
+	saved := *action
+	db.onRollback(func() {
+		*action = saved
+		db.actions[id] = action
+	})
+}
+
+func (db *memoryDB) saveUser(id uint64) {
+	user, ok := db.users[id]
+	if !ok {
+		db.onRollback(func() { delete(db.users, id) })
+		return
+	}
+	saved := *user
+	db.onRollback(func() {
+		*user = saved
+		db.users[id] = user
+	})
+}
+
+func (db *memoryDB) saveProject(id string) {
+	project, ok := db.projects[id]
+	if !ok {
+		db.onRollback(func() { delete(db.projects, id) })
+		return
+	}
+	saved := *project
+	db.onRollback(func() {
+		*project = saved
+		db.projects[id] = project
+	})
+}
+
+func (db *memoryDB) saveTag(id string) {
+	tag, ok := db.tags[id]
+	if !ok {
+		db.onRollback(func() { delete(db.tags, id) })
+		return
+	}
+	saved := *tag
+	db.onRollback(func() {
+		*tag = saved
+		db.tags[id] = tag
+	})
+}
+
+func (db *memoryDB) saveAttachment(id string) {
+	attachment, ok := db.attachments[id]
+	if !ok {
+		db.onRollback(func() { delete(db.attachments, id) })
+		return
+	}
+	saved := *attachment
+	db.onRollback(func() {
+		*attachment = saved
+		db.attachments[id] = attachment
+	})
+}
+
+func (db *memoryDB) saveReminder(id string) {
+	reminder, ok := db.reminders[id]
+	if !ok {
+		db.onRollback(func() { delete(db.reminders, id) })
+		return
+	}
+	saved := *reminder
+	db.onRollback(func() {
+		*reminder = saved
+		db.reminders[id] = reminder
+	})
+}
+
+// Saves one of the sets of IDs kept per task, such as its tags or the tasks it's blocked by.
+func (db *memoryDB) saveSet(sets map[string]map[string]bool, key string) {
+	set, ok := sets[key]
+	if !ok {
+		db.onRollback(func() { delete(sets, key) })
+		return
+	}
+	saved := make(map[string]bool, len(set))
+	for id := range set {
+		saved[id] = true
+	}
+	db.onRollback(func() { sets[key] = saved })
+}
+
+// Saves an ordering of IDs. Orderings are only ever appended to or replaced, never changed in
+// place, so restoring the slice restores its contents.
+func (db *memoryDB) saveOrder(order *[]string) {
+	saved := *order
+	db.onRollback(func() { *order = saved })
+}
+
+// Returns the IDs in order without the given ID, leaving order itself unchanged.
+func without(order []string, id string) []string {
+	result := make([]string, 0, len(order))
+	for _, other := range order {
+		if other != id {
+			result = append(result, other)
+		}
+	}
+	return result
+}
+
+// Runs fn with the database locked for writing, so transactions are fully serialized. Any changes
+// fn made are undone if it returns an error. Nested calls only undo their own changes.
+func (db *memoryDB) WithTx(fn func(Database) error) (err error) {
+	if !db.inTx {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
+
+	// Nested transactions share their parent's undo log, so the parent can still undo their changes
+	undo := db.undo
+	if undo == nil {
+		undo = &[]func(){}
+	}
+	start := len(*undo)
+	rollback := func() {
+		for i := len(*undo) - 1; i >= start; i-- {
+			(*undo)[i]()
+		}
+		*undo = (*undo)[:start]
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			rollback()
+			panic(r)
+		}
+	}()
+
+	tx := &memoryDB{
+		mu:          db.mu,
+		inTx:        true,
+		undo:        undo,
+		memoryStore: db.memoryStore,
+	}
+	if err := fn(tx); err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
+
+// Returns the live task with the given ID if it belongs to the user. Callers must hold the lock.
+func (db *memoryDB) findTask(taskId string, userId uint64, kind *TaskKind) *Task {
+	task, ok := db.tasks[taskId]
+	if !ok || task.DeletedAt != nil || task.UserId != userId {
+		return nil
+	}
+	if kind != nil && task.Kind != *kind {
+		return nil
+	}
+	return task
+}
+
+// Returns a copy of the task. Like gormDB, actions are not attached and are loaded separately
+// with GetActions.
+func copyTask(task *Task) *Task {
+	result := *task
+	result.Actions = nil
+	return &result
+}
+
+func (db *memoryDB) GetTask(taskId string, userId uint64, kind *TaskKind) (*Task, error) {
+	defer db.rlock()()
+
+	task := db.findTask(taskId, userId, kind)
+	if task == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return copyTask(task), nil
+}
+
+func (db *memoryDB) GetTasks(userId uint64, query TaskQuery) (*TaskPage, error) {
+	if err := query.normalizeTags(); err != nil {
+		return nil, err
+	}
+	defer db.rlock()()
+
+	tasks := []Task{}
+	for _, id := range db.taskOrder {
+		task := db.findTask(id, userId, nil)
+		if task != nil && query.matches(task) && query.matchesTags(db.tagNamesOf(id)) && query.matchesActionable(task, db.isBlocked(id)) {
+			tasks = append(tasks, *copyTask(task))
+		}
+	}
+	return query.paginate(tasks)
+}
+
+func (db *memoryDB) AddTask(task *Task, userId uint64) error {
+	if err := validateRecurrence(task.Recurrence); err != nil {
+		return err
+	}
+	if task.ParentId == nil && task.ProjectId == nil {
+		defer db.lock()()
+		return db.addTask(task, userId)
+	}
+	return db.WithTx(func(tx Database) error {
+		if err := validateTaskProject(tx, task.ProjectId, userId); err != nil {
+			return err
+		}
+		if task.ParentId != nil {
+			if err := validateParent(tx, task, *task.ParentId, userId); err != nil {
+				return err
+			}
+		}
+		if err := tx.(*memoryDB).addTask(task, userId); err != nil {
+			return err
+		}
+		return autoCompleteTask(tx, task.ParentId, userId)
+	})
+}
+
+// Stores a new task, placing it after the user's other tasks if it has no position. Callers must
+// hold the lock.
+func (db *memoryDB) addTask(task *Task, userId uint64) error {
+	if task.Id == "" {
+		task.Id = newUUID()
+	}
+	if _, ok := db.tasks[task.Id]; ok {
+		return fmt.Errorf("Task ID \"%s\" already exists", task.Id)
+	}
+	// Like gorm, only fill in timestamps that weren't given
+	now := time.Now()
+	task.UserId = userId
+	if task.CreatedAt.IsZero() {
+		task.CreatedAt = now
+	}
+	if task.UpdatedAt.IsZero() {
+		task.UpdatedAt = now
+	}
+	if task.Version == 0 {
+		task.Version = 1
+	}
+	if task.Position == "" {
+		// Tasks in the trash keep their position, so they count too
+		last := ""
+		for _, other := range db.tasks {
+			if other.UserId == userId && other.Position > last {
+				last = other.Position
+			}
+		}
+		task.Position = rankAfter(last)
+	}
+
+	db.saveTask(task.Id)
+	db.saveOrder(&db.taskOrder)
+	stored := *task
+	stored.Actions = nil
+	db.tasks[task.Id] = &stored
+	db.taskOrder = append(db.taskOrder, task.Id)
+	return nil
+}
+
+// Deletes the task with the given ID along with its subtasks and returns whether a row was
+// deleted. Everything deleted together shares its deletion time, so it can be restored together.
+func (db *memoryDB) DeleteTask(taskId string, userId uint64) (bool, error) {
+	deleted := false
+	err := db.WithTx(func(tx Database) error {
+		mtx := tx.(*memoryDB)
+		task := mtx.findTask(taskId, userId, nil)
+		if task == nil {
+			return nil
+		}
+		descendants, err := liveDescendants(tx, taskId, userId)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		mtx.saveTask(taskId)
+		task.DeletedAt = &now
+		for _, descendant := range descendants {
+			mtx.saveTask(descendant.Id)
+			mtx.tasks[descendant.Id].DeletedAt = &now
+		}
+		deleted = true
+		return autoCompleteTask(tx, task.ParentId, userId)
+	})
+	return deleted, err
+}
+
+// Returns the user's soft-deleted tasks and habits, most recently deleted first.
+func (db *memoryDB) GetDeletedTasks(userId uint64) ([]Task, error) {
+	defer db.rlock()()
+
+	tasks := []Task{}
+	for _, id := range db.taskOrder {
+		if task := db.tasks[id]; task.UserId == userId && task.DeletedAt != nil {
+			tasks = append(tasks, *copyTask(task))
+		}
+	}
+	sort.Stable(byDeletedAtDesc(tasks))
+	return tasks, nil
+}
+
+type byDeletedAtDesc []Task
+
+func (s byDeletedAtDesc) Len() int           { return len(s) }
+func (s byDeletedAtDesc) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDeletedAtDesc) Less(i, j int) bool { return s[j].DeletedAt.Before(*s[i].DeletedAt) }
+
+// Returns the trashed task with the given ID if it belongs to the user. Callers must hold the lock.
+func (db *memoryDB) findDeletedTask(taskId string, userId uint64) *Task {
+	task, ok := db.tasks[taskId]
+	if !ok || task.DeletedAt == nil || task.UserId != userId {
+		return nil
+	}
+	return task
+}
+
+// Moves a task out of the trash along with the subtasks that were deleted with it and returns it.
+// The task is detached from its parent if the parent is no longer live.
+func (db *memoryDB) RestoreTask(taskId string, userId uint64) (*Task, error) {
+	var result *Task
+	err := db.WithTx(func(tx Database) error {
+		mtx := tx.(*memoryDB)
+		task := mtx.findDeletedTask(taskId, userId)
+		if task == nil {
+			return fmt.Errorf("Task ID \"%s\" is not in the trash for user \"%d\"", taskId, userId)
+		}
+		descendants, err := trashedDescendants(tx, taskId, userId)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, descendant := range descendants {
+			mtx.saveTask(descendant.Id)
+			restored := mtx.tasks[descendant.Id]
+			restored.DeletedAt = nil
+			restored.UpdatedAt = now
+		}
+		mtx.saveTask(taskId)
+		task.DeletedAt = nil
+		task.UpdatedAt = now
+		if task.ParentId != nil && mtx.findTask(*task.ParentId, userId, nil) == nil {
+			result, err = tx.UpdateTask(taskId, userId, map[string]interface{}{"parent_id": (*string)(nil)}, nil)
+			return err
+		}
+		result = copyTask(task)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Permanently deletes a task in the trash along with its actions and the subtasks that were deleted
+// with it, and returns whether it existed.
+func (db *memoryDB) PurgeTask(taskId string, userId uint64) (bool, error) {
+	purged := false
+	err := db.WithTx(func(tx Database) error {
+		mtx := tx.(*memoryDB)
+		if mtx.findDeletedTask(taskId, userId) == nil {
+			return nil
+		}
+		descendants, err := trashedDescendants(tx, taskId, userId)
+		if err != nil {
+			return err
+		}
+		ids := map[string]bool{taskId: true}
+		for _, descendant := range descendants {
+			ids[descendant.Id] = true
+		}
+		mtx.purgeTasks(ids)
+		purged = true
+		return nil
+	})
+	return purged, err
+}
+
+func (db *memoryDB) PurgeDeletedTasks(deletedBefore time.Time) ([]Task, error) {
+	defer db.lock()()
+
+	ids := make(map[string]bool)
+	tasks := []Task{}
+	for id, task := range db.tasks {
+		if task.DeletedAt != nil && task.DeletedAt.Before(deletedBefore) {
+			ids[id] = true
+			tasks = append(tasks, *copyTask(task))
+		}
+	}
+	db.purgeTasks(ids)
+	return tasks, nil
+}
+
+// Removes the tasks along with their actions, tags, reminders and dependencies, and detaches the
+// tasks that remain from them. Callers must hold the lock.
+func (db *memoryDB) purgeTasks(ids map[string]bool) {
+	if len(ids) == 0 {
+		return
+	}
+	db.saveOrder(&db.actionOrder)
+	actionOrder := []string{}
+	for _, id := range db.actionOrder {
+		if ids[db.actions[id].TaskId] {
+			db.saveAction(id)
+			delete(db.actions, id)
+		} else {
+			actionOrder = append(actionOrder, id)
+		}
+	}
+	db.actionOrder = actionOrder
+
+	db.saveOrder(&db.reminderOrder)
+	reminderOrder := []string{}
+	for _, id := range db.reminderOrder {
+		if ids[db.reminders[id].TaskId] {
+			db.saveReminder(id)
+			delete(db.reminders, id)
+		} else {
+			reminderOrder = append(reminderOrder, id)
+		}
+	}
+	db.reminderOrder = reminderOrder
+
+	for taskId, blockerIds := range db.dependencies {
+		db.saveSet(db.dependencies, taskId)
+		for blockerId := range blockerIds {
+			if ids[blockerId] {
+				delete(blockerIds, blockerId)
+			}
+		}
+		if ids[taskId] {
+			delete(db.dependencies, taskId)
+		}
+	}
+
+	now := time.Now()
+	for id, task := range db.tasks {
+		parentPurged := task.ParentId != nil && ids[*task.ParentId]
+		nextPurged := task.NextId != nil && ids[*task.NextId]
+		if ids[id] || !parentPurged && !nextPurged {
+			continue
+		}
+		db.saveTask(id)
+		if parentPurged {
+			task.ParentId = nil
+		}
+		if nextPurged {
+			task.NextId = nil
+		}
+		task.Version++
+		task.UpdatedAt = now
+	}
+
+	db.saveOrder(&db.taskOrder)
+	taskOrder := []string{}
+	for _, id := range db.taskOrder {
+		if ids[id] {
+			db.saveTask(id)
+			db.saveSet(db.taskTags, id)
+			delete(db.tasks, id)
+			delete(db.taskTags, id)
+		} else {
+			taskOrder = append(taskOrder, id)
+		}
+	}
+	db.taskOrder = taskOrder
+}
+
+// Updates a task with the given attributes and returns the updated Task if one exists for the ID.
+func (db *memoryDB) UpdateTask(taskId string, userId uint64, attrs map[string]interface{}, expectedVersion *int) (*Task, error) {
+	var result *Task
+	err := db.WithTx(func(tx Database) error {
+		mtx := tx.(*memoryDB)
+		task := mtx.findTask(taskId, userId, nil)
+		if task == nil {
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
+		}
+		if expectedVersion != nil && task.Version != *expectedVersion {
+			return &ConflictError{copyTask(task)}
+		}
+		if err := validateParentAttr(tx, task, attrs, userId); err != nil {
+			return err
+		}
+		if err := validateProjectAttr(tx, attrs, userId); err != nil {
+			return err
+		}
+		if err := validateRecurrenceAttr(attrs); err != nil {
+			return err
+		}
+		before := *task
+		updated := *task
+		if err := setTaskAttrs(&updated, attrs); err != nil {
+			return err
+		}
+		updated.Version++
+		updated.UpdatedAt = time.Now()
+		mtx.saveTask(taskId)
+		*task = updated
+		if err := recurAfterUpdate(tx, &before, &updated, userId); err != nil {
+			return err
+		}
+		if err := autoCompleteAfterUpdate(tx, &before, &updated, userId); err != nil {
+			return err
+		}
+		if endDateChanged(&before, &updated) {
+			mtx.rescheduleReminders(task)
+		}
+		result = copyTask(task)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (db *memoryDB) AddTasks(tasks []*Task, userId uint64) ([]error, error) {
+	return addTasks(db, tasks, userId)
+}
+
+func (db *memoryDB) UpdateTasks(updates []TaskUpdate, userId uint64) ([]*Task, []error, error) {
+	return updateTasks(db, updates, userId)
+}
+
+func (db *memoryDB) DeleteTasks(taskIds []string, userId uint64) ([]error, error) {
+	return deleteTasks(db, taskIds, userId)
+}
+
+func (db *memoryDB) AddActions(actions []*Action, userId uint64) ([]error, error) {
+	return addActions(db, actions, userId)
+}
+
+// Applies attributes keyed by column name, as accepted by gorm's Updates, to the task.
+func setTaskAttrs(task *Task, attrs map[string]interface{}) error {
+	for column, value := range attrs {
+		ok := true
+		switch column {
+		case "title":
+			task.Title, ok = value.(string)
+		case "notes":
+			task.Notes, ok = value.(string)
+		case "done":
+			task.Done, ok = value.(bool)
+		case "start_date":
+			task.StartDate, ok = value.(*time.Time)
+		case "end_date":
+			task.EndDate, ok = value.(*time.Time)
+		case "interval":
+			task.Interval, ok = value.(Interval)
+		case "frequency":
+			task.Frequency, ok = value.(int)
+		case "parent_id":
+			task.ParentId, ok = value.(*string)
+		case "auto_complete":
+			task.AutoComplete, ok = value.(bool)
+		case "project_id":
+			task.ProjectId, ok = value.(*string)
+		case "priority":
+			task.Priority, ok = value.(Priority)
+		case "position":
+			task.Position, ok = value.(string)
+		case "recurrence":
+			task.Recurrence, ok = value.(string)
+		case "next_id":
+			task.NextId, ok = value.(*string)
+		default:
+			return fmt.Errorf("Unknown task attribute \"%s\"", column)
+		}
+		if !ok {
+			return fmt.Errorf("Invalid value %v for task attribute \"%s\"", value, column)
+		}
+	}
+	return nil
+}
+
+func (db *memoryDB) CreateUser(username string, password string) (*User, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.lock()()
+
+	for _, user := range db.users {
+		if user.Username == username && user.DeletedAt == nil {
+			return nil, fmt.Errorf("Username \"%s\" is already taken", username)
+		}
+	}
+
+	now := time.Now()
+	user := &User{
+		Id:             db.nextUserId,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		Username:       username,
+		HashedPassword: hashedPassword,
+	}
+	nextUserId := db.nextUserId
+	db.onRollback(func() { db.nextUserId = nextUserId })
+	db.nextUserId++
+
+	db.saveUser(user.Id)
+	stored := *user
+	db.users[user.Id] = &stored
+	return user, nil
+}
+
+func (db *memoryDB) GetUserById(id uint64) (*User, error) {
+	defer db.rlock()()
+
+	user, ok := db.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	result := *user
+	return &result, nil
+}
+
+func (db *memoryDB) GetUserByUsername(username string) (*User, error) {
+	defer db.rlock()()
+
+	for _, user := range db.users {
+		if user.Username == username && user.DeletedAt == nil {
+			result := *user
+			return &result, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (db *memoryDB) AddAction(action *Action, userId uint64) error {
+	return db.WithTx(func(tx Database) error {
+		mtx := tx.(*memoryDB)
+		if mtx.findTask(action.TaskId, userId, nil) == nil {
+			return fmt.Errorf("Task %s does not exist for user %d", action.TaskId, userId)
+		}
+		if action.When == nil {
+			return fmt.Errorf("Action must have a time")
+		}
+		if action.Id == "" {
+			action.Id = newUUID()
+		}
+		if _, ok := mtx.actions[action.Id]; ok {
+			return fmt.Errorf("Action ID \"%s\" already exists", action.Id)
+		}
+		if err := validateTimerAction(tx, action, userId); err != nil {
+			return err
+		}
+
+		mtx.saveAction(action.Id)
+		mtx.saveOrder(&mtx.actionOrder)
+		stored := *action
+		mtx.actions[action.Id] = &stored
+		mtx.actionOrder = append(mtx.actionOrder, action.Id)
+		return completeAfterAction(tx, action, userId)
+	})
+}
+
+func (db *memoryDB) DeleteAction(id string, userId uint64) error {
+	defer db.lock()()
+
+	action, ok := db.actions[id]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	if db.findTask(action.TaskId, userId, nil) == nil {
+		return fmt.Errorf("Not authorized to delete action %s", id)
+	}
+	db.saveAction(id)
+	db.saveOrder(&db.actionOrder)
+	delete(db.actions, id)
+	db.actionOrder = without(db.actionOrder, id)
+	return nil
+}
+
+func (db *memoryDB) GetAction(id string, userId uint64) (*Action, error) {
+	defer db.rlock()()
+
+	action, ok := db.actions[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if task, ok := db.tasks[action.TaskId]; !ok || task.UserId != userId {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := *action
+	return &found, nil
+}
+
+// Returns the actions of each of the given tasks that belong to the user, keyed by task ID. Tasks
+// in the trash are included.
+func (db *memoryDB) GetActions(taskIds []string, userId uint64) (map[string][]Action, error) {
+	defer db.rlock()()
+
+	ids := make(map[string]bool)
+	for _, id := range taskIds {
+		if task, ok := db.tasks[id]; ok && task.UserId == userId {
+			ids[id] = true
+		}
+	}
+	actions := make(map[string][]Action)
+	for _, id := range db.actionOrder {
+		if action := db.actions[id]; ids[action.TaskId] {
+			actions[action.TaskId] = append(actions[action.TaskId], *action)
+		}
+	}
+	return actions, nil
+}
+
+func (db *memoryDB) AddHistory(entry *HistoryEntry) error {
+	defer db.lock()()
+
+	if entry.Id == "" {
+		entry.Id = newUUID()
+	}
+	entry.CreatedAt = time.Now()
+	history := db.history
+	db.onRollback(func() { db.history = history })
+	db.history = append(db.history, *entry)
+	return nil
+}
+
+// Returns the history of a task, oldest first. Entries outlive the task itself.
+func (db *memoryDB) GetHistory(taskId string, userId uint64) ([]HistoryEntry, error) {
+	defer db.rlock()()
+
+	entries := []HistoryEntry{}
+	for _, entry := range db.history {
+		if entry.TaskId == taskId && entry.UserId == userId {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (db *memoryDB) GetProject(projectId string, userId uint64) (*Project, error) {
+	defer db.rlock()()
+
+	project, ok := db.projects[projectId]
+	if !ok || project.UserId != userId {
+		return nil, gorm.ErrRecordNotFound
+	}
+	result := *project
+	return &result, nil
+}
+
+func (db *memoryDB) GetProjects(userId uint64, archived *bool) ([]Project, error) {
+	defer db.rlock()()
+
+	projects := []Project{}
+	for _, project := range db.projects {
+		if project.UserId == userId && (archived == nil || project.Archived == *archived) {
+			projects = append(projects, *project)
+		}
+	}
+	sort.Sort(byProjectPosition(projects))
+	return projects, nil
+}
+
+// Adds a project. Projects without a position are placed after the user's other projects.
+func (db *memoryDB) AddProject(project *Project, userId uint64) error {
+	project.UserId = userId
+	if err := validateProject(project); err != nil {
+		return err
+	}
+
+	defer db.lock()()
+
+	if project.Id == "" {
+		project.Id = newUUID()
+	}
+	if _, ok := db.projects[project.Id]; ok {
+		return fmt.Errorf("Project ID \"%s\" already exists", project.Id)
+	}
+	if project.Position == 0 {
+		for _, other := range db.projects {
+			if other.UserId == userId && other.Position >= project.Position {
+				project.Position = other.Position
+			}
+		}
+		project.Position++
+	}
+	now := time.Now()
+	project.CreatedAt = now
+	project.UpdatedAt = now
+
+	db.saveProject(project.Id)
+	stored := *project
+	db.projects[project.Id] = &stored
+	return nil
+}
+
+func (db *memoryDB) UpdateProject(projectId string, userId uint64, attrs map[string]interface{}) (*Project, error) {
+	defer db.lock()()
+
+	project, ok := db.projects[projectId]
+	if !ok || project.UserId != userId {
+		return nil, fmt.Errorf("Project ID \"%s\" does not exist for user \"%d\"", projectId, userId)
+	}
+	updated := *project
+	if err := setProjectAttrs(&updated, attrs); err != nil {
+		return nil, err
+	}
+	if err := validateProject(&updated); err != nil {
+		return nil, err
+	}
+	updated.UpdatedAt = time.Now()
+	db.saveProject(projectId)
+	*project = updated
+	return &updated, nil
+}
+
+// Deletes a project and moves its tasks and habits, including those in the trash, out of it.
+func (db *memoryDB) DeleteProject(projectId string, userId uint64) (bool, error) {
+	defer db.lock()()
+
+	project, ok := db.projects[projectId]
+	if !ok || project.UserId != userId {
+		return false, nil
+	}
+	db.saveProject(projectId)
+	delete(db.projects, projectId)
+	now := time.Now()
+	for _, task := range db.tasks {
+		if task.UserId == userId && task.ProjectId != nil && *task.ProjectId == projectId {
+			db.saveTask(task.Id)
+			task.ProjectId = nil
+			task.Version++
+			task.UpdatedAt = now
+		}
+	}
+	return true, nil
+}
+
+func (db *memoryDB) GetTags(userId uint64) ([]Tag, error) {
+	defer db.rlock()()
+
+	tags := []Tag{}
+	for _, tag := range db.tags {
+		if tag.UserId == userId {
+			tags = append(tags, *tag)
+		}
+	}
+	sort.Sort(byTagName(tags))
+	return tags, nil
+}
+
+// Returns the names of the task's tags. Callers must hold the lock.
+func (db *memoryDB) tagNamesOf(taskId string) map[string]bool {
+	names := make(map[string]bool)
+	for tagId := range db.taskTags[taskId] {
+		names[db.tags[tagId].Name] = true
+	}
+	return names
+}
+
+// Returns the tags of each of the given tasks that belong to the user, keyed by task ID and
+// ordered by name. Tasks in the trash are included.
+func (db *memoryDB) GetTaskTags(taskIds []string, userId uint64) (map[string][]Tag, error) {
+	defer db.rlock()()
+
+	tags := make(map[string][]Tag)
+	for _, taskId := range taskIds {
+		task, ok := db.tasks[taskId]
+		if !ok || task.UserId != userId || len(db.taskTags[taskId]) == 0 {
+			continue
+		}
+		for tagId := range db.taskTags[taskId] {
+			tags[taskId] = append(tags[taskId], *db.tags[tagId])
+		}
+		sort.Sort(byTagName(tags[taskId]))
+	}
+	return tags, nil
+}
+
+// Returns the user's tag with the given name, or nil if there is none. Callers must hold the lock.
+func (db *memoryDB) findTagByName(name string, userId uint64) *Tag {
+	for _, tag := range db.tags {
+		if tag.UserId == userId && tag.Name == name {
+			return tag
+		}
+	}
+	return nil
+}
+
+// Callers must hold the lock.
+func (db *memoryDB) findTag(tagId string, userId uint64) (*Tag, error) {
+	tag, ok := db.tags[tagId]
+	if !ok || tag.UserId != userId {
+		return nil, tagNotFound(tagId, userId)
+	}
+	return tag, nil
+}
+
+func (db *memoryDB) AddTag(taskId string, name string, userId uint64) (*Tag, error) {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.lock()()
+
+	if db.findTask(taskId, userId, nil) == nil {
+		return nil, fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
+	}
+	tag := db.findTagByName(name, userId)
+	if tag == nil {
+		now := time.Now()
+		tag = &Tag{
+			Id:        newUUID(),
+			CreatedAt: now,
+			UpdatedAt: now,
+			UserId:    userId,
+			Name:      name,
+		}
+		db.saveTag(tag.Id)
+		db.tags[tag.Id] = tag
+	}
+	db.saveSet(db.taskTags, taskId)
+	if db.taskTags[taskId] == nil {
+		db.taskTags[taskId] = make(map[string]bool)
+	}
+	db.taskTags[taskId][tag.Id] = true
+	result := *tag
+	return &result, nil
+}
+
+func (db *memoryDB) RemoveTag(taskId string, name string, userId uint64) (bool, error) {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return false, err
+	}
+
+	defer db.lock()()
+
+	if db.findTask(taskId, userId, nil) == nil {
+		return false, fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
+	}
+	tag := db.findTagByName(name, userId)
+	if tag == nil || !db.taskTags[taskId][tag.Id] {
+		return false, nil
+	}
+	db.saveSet(db.taskTags, taskId)
+	delete(db.taskTags[taskId], tag.Id)
+	return true, nil
+}
+
+func (db *memoryDB) RenameTag(tagId string, name string, userId uint64) (*Tag, error) {
+	name, err := normalizeTagName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.lock()()
+
+	tag, err := db.findTag(tagId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if other := db.findTagByName(name, userId); other != nil && other.Id != tagId {
+		return nil, tagNameTaken(name)
+	}
+	db.saveTag(tagId)
+	tag.Name = name
+	tag.UpdatedAt = time.Now()
+	result := *tag
+	return &result, nil
+}
+
+func (db *memoryDB) MergeTags(sourceId string, targetId string, userId uint64) (*Tag, error) {
+	if sourceId == targetId {
+		return nil, fmt.Errorf("Can't merge tag ID \"%s\" into itself", sourceId)
+	}
+
+	defer db.lock()()
+
+	if _, err := db.findTag(sourceId, userId); err != nil {
+		return nil, err
+	}
+	target, err := db.findTag(targetId, userId)
+	if err != nil {
+		return nil, err
+	}
+	for taskId, tagIds := range db.taskTags {
+		if tagIds[sourceId] {
+			db.saveSet(db.taskTags, taskId)
+			delete(tagIds, sourceId)
+			tagIds[targetId] = true
+		}
+	}
+	db.saveTag(sourceId)
+	delete(db.tags, sourceId)
+	result := *target
+	return &result, nil
+}
+
+func (db *memoryDB) DeleteTag(tagId string, userId uint64) (bool, error) {
+	defer db.lock()()
+
+	if _, err := db.findTag(tagId, userId); err != nil {
+		return false, nil
+	}
+	for taskId, tagIds := range db.taskTags {
+		if tagIds[tagId] {
+			db.saveSet(db.taskTags, taskId)
+			delete(tagIds, tagId)
+		}
+	}
+	db.saveTag(tagId)
+	delete(db.tags, tagId)
+	return true, nil
+}
+
+func (db *memoryDB) GetAttachment(attachmentId string, userId uint64) (*Attachment, error) {
+	defer db.rlock()()
+
+	attachment, ok := db.attachments[attachmentId]
+	if !ok || attachment.UserId != userId {
+		return nil, gorm.ErrRecordNotFound
+	}
+	result := *attachment
+	return &result, nil
+}
+
+// Returns the attachments of each of the given tasks that belong to the user, keyed by task ID
+// and oldest first. Tasks in the trash are included.
+func (db *memoryDB) GetAttachments(taskIds []string, userId uint64) (map[string][]Attachment, error) {
+	defer db.rlock()()
+
+	ids := make(map[string]bool)
+	for _, id := range taskIds {
+		ids[id] = true
+	}
+	attachments := make(map[string][]Attachment)
+	for _, id := range db.attachmentOrder {
+		if attachment := db.attachments[id]; ids[attachment.TaskId] && attachment.UserId == userId {
+			attachments[attachment.TaskId] = append(attachments[attachment.TaskId], *attachment)
+		}
+	}
+	return attachments, nil
+}
+
+func (db *memoryDB) AddAttachment(attachment *Attachment, quota int64, userId uint64) error {
+	defer db.lock()()
+
+	if db.findTask(attachment.TaskId, userId, nil) == nil {
+		return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", attachment.TaskId, userId)
+	}
+	if used := db.attachmentUsage(userId); used+attachment.Size > quota {
+		return quotaExceededError(quota, used)
+	}
+	if attachment.Id == "" {
+		attachment.Id = newUUID()
+	}
+	if _, ok := db.attachments[attachment.Id]; ok {
+		return fmt.Errorf("Attachment ID \"%s\" already exists", attachment.Id)
+	}
+	attachment.UserId = userId
+	attachment.CreatedAt = time.Now()
+
+	db.saveAttachment(attachment.Id)
+	db.saveOrder(&db.attachmentOrder)
+	stored := *attachment
+	db.attachments[attachment.Id] = &stored
+	db.attachmentOrder = append(db.attachmentOrder, attachment.Id)
+	return nil
+}
+
+func (db *memoryDB) DeleteAttachment(attachmentId string, userId uint64) (bool, error) {
+	defer db.lock()()
+
+	attachment, ok := db.attachments[attachmentId]
+	if !ok || attachment.UserId != userId {
+		return false, nil
+	}
+	db.saveAttachment(attachmentId)
+	db.saveOrder(&db.attachmentOrder)
+	delete(db.attachments, attachmentId)
+	db.attachmentOrder = without(db.attachmentOrder, attachmentId)
+	return true, nil
+}
+
+func (db *memoryDB) GetAttachmentUsage(userId uint64) (int64, error) {
+	defer db.rlock()()
+	return db.attachmentUsage(userId), nil
+}
+
+// Returns the total size in bytes of the user's attachments. Callers must hold the lock.
+func (db *memoryDB) attachmentUsage(userId uint64) int64 {
+	var usage int64
+	for _, attachment := range db.attachments {
+		if attachment.UserId == userId {
+			usage += attachment.Size
+		}
+	}
+	return usage
+}
+
+// Attachments are kept when their task is purged, so their content can be deleted from the
+// BlobStore before they are.
+func (db *memoryDB) GetOrphanedAttachments() ([]Attachment, error) {
+	defer db.rlock()()
+
+	attachments := []Attachment{}
+	for _, id := range db.attachmentOrder {
+		if attachment := db.attachments[id]; db.tasks[attachment.TaskId] == nil {
+			attachments = append(attachments, *attachment)
+		}
+	}
+	return attachments, nil
+}
+
+// Returns the reminders of each of the given tasks that belong to the user, keyed by task ID and
+// oldest first. Tasks in the trash are included.
+func (db *memoryDB) GetReminders(taskIds []string, userId uint64) (map[string][]Reminder, error) {
+	defer db.rlock()()
+
+	ids := make(map[string]bool)
+	for _, id := range taskIds {
+		ids[id] = true
+	}
+	reminders := make(map[string][]Reminder)
+	for _, id := range db.reminderOrder {
+		if reminder := db.reminders[id]; ids[reminder.TaskId] && reminder.UserId == userId {
+			reminders[reminder.TaskId] = append(reminders[reminder.TaskId], *reminder)
+		}
+	}
+	return reminders, nil
+}
+
+func (db *memoryDB) AddReminder(reminder *Reminder, userId uint64) error {
+	defer db.lock()()
+
+	task := db.findTask(reminder.TaskId, userId, nil)
+	if task == nil {
+		return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", reminder.TaskId, userId)
+	}
+	if err := scheduleReminder(reminder, task); err != nil {
+		return err
+	}
+	if reminder.Id == "" {
+		reminder.Id = newUUID()
+	}
+	if _, ok := db.reminders[reminder.Id]; ok {
+		return fmt.Errorf("Reminder ID \"%s\" already exists", reminder.Id)
+	}
+	reminder.UserId = userId
+	reminder.CreatedAt = time.Now()
+
+	db.saveReminder(reminder.Id)
+	db.saveOrder(&db.reminderOrder)
+	stored := *reminder
+	db.reminders[reminder.Id] = &stored
+	db.reminderOrder = append(db.reminderOrder, reminder.Id)
+	return nil
+}
+
+func (db *memoryDB) DeleteReminder(reminderId string, userId uint64) (bool, error) {
+	defer db.lock()()
+
+	reminder, ok := db.reminders[reminderId]
+	if !ok || reminder.UserId != userId {
+		return false, nil
+	}
+	db.saveReminder(reminderId)
+	db.saveOrder(&db.reminderOrder)
+	delete(db.reminders, reminderId)
+	db.reminderOrder = without(db.reminderOrder, reminderId)
+	return true, nil
+}
+
+type byFireAt []Reminder
+
+func (s byFireAt) Len() int      { return len(s) }
+func (s byFireAt) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byFireAt) Less(i, j int) bool {
+	if !s[i].FireAt.Equal(*s[j].FireAt) {
+		return s[i].FireAt.Before(*s[j].FireAt)
+	}
+	return s[i].Id < s[j].Id
+}
+
+func (db *memoryDB) GetDueReminders(now time.Time, limit int) ([]Reminder, error) {
+	defer db.rlock()()
+
+	due := []Reminder{}
+	for _, reminder := range db.reminders {
+		if reminder.FireAt != nil && !reminder.FireAt.After(now) {
+			due = append(due, *reminder)
+		}
+	}
+	sort.Sort(byFireAt(due))
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	return due, nil
+}
+
+func (db *memoryDB) ClaimReminder(reminderId string, fireAt time.Time, next *time.Time) (bool, error) {
+	defer db.lock()()
+
+	reminder, ok := db.reminders[reminderId]
+	if !ok || reminder.FireAt == nil || !reminder.FireAt.Equal(fireAt) {
+		return false, nil
+	}
+	now := time.Now()
+	db.saveReminder(reminderId)
+	reminder.FireAt = next
+	reminder.FiredAt = &now
+	return true, nil
+}
+
+func (db *memoryDB) GetRunningTimers(userId uint64) ([]Timer, error) {
+	defer db.rlock()()
+
+	return db.runningTimers(func(timer *Timer) bool {
+		return timer.UserId == userId
+	}), nil
+}
+
+func (db *memoryDB) GetForgottenTimers(startedBefore time.Time) ([]Timer, error) {
+	defer db.rlock()()
+
+	return db.runningTimers(func(timer *Timer) bool {
+		return timer.StartedAt.Before(startedBefore)
+	}), nil
+}
+
+// Returns the running timers that match, oldest first. Callers must hold the lock.
+func (db *memoryDB) runningTimers(matches func(*Timer) bool) []Timer {
+	stops := make(map[string][]time.Time)
+	for _, action := range db.actions {
+		if action.Kind == ActionStop && action.When != nil {
+			stops[action.TaskId] = append(stops[action.TaskId], *action.When)
+		}
+	}
+
+	timers := []Timer{}
+	for _, id := range db.actionOrder {
+		action := db.actions[id]
+		task, ok := db.tasks[action.TaskId]
+		if action.Kind != ActionStart || action.When == nil || !ok || task.DeletedAt != nil {
+			continue
+		}
+		stopped := false
+		for _, stop := range stops[action.TaskId] {
+			if !stop.Before(*action.When) {
+				stopped = true
+			}
+		}
+		timer := Timer{
+			TaskId:    action.TaskId,
+			UserId:    task.UserId,
+			ActionId:  action.Id,
+			StartedAt: *action.When,
+		}
+		if !stopped && matches(&timer) {
+			timers = append(timers, timer)
+		}
+	}
+	sort.Sort(byStartedAt(timers))
+	return timers
+}
+
+// Moves the reminders that are relative to the task's end date to its current end date. Callers
+// must hold the lock.
+func (db *memoryDB) rescheduleReminders(task *Task) {
+	for _, reminder := range db.reminders {
+		if reminder.TaskId == task.Id && reminder.OffsetMinutes != nil {
+			db.saveReminder(reminder.Id)
+			reminder.FireAt = reminderFireAt(reminder, task, time.Now())
+		}
+	}
+}
+
+// Returns the live tasks of the user with the given IDs, oldest first. Callers must hold the lock.
+func (db *memoryDB) liveTasks(ids map[string]bool, userId uint64) []Task {
+	tasks := []Task{}
+	for id := range ids {
+		if task := db.findTask(id, userId, nil); task != nil {
+			tasks = append(tasks, *copyTask(task))
+		}
+	}
+	sort.Sort(taskSorter{tasks, OrderCreatedAsc})
+	return tasks
+}
+
+// Reports whether the task is blocked by a live task that isn't done. Callers must hold the lock.
+func (db *memoryDB) isBlocked(taskId string) bool {
+	for blockerId := range db.dependencies[taskId] {
+		blocker := db.findTask(blockerId, db.tasks[taskId].UserId, nil)
+		if blocker != nil && !blocker.Done {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *memoryDB) GetBlockers(taskIds []string, userId uint64) (map[string][]Task, error) {
+	defer db.rlock()()
+
+	blockers := make(map[string][]Task)
+	for _, taskId := range taskIds {
+		if tasks := db.liveTasks(db.dependencies[taskId], userId); len(tasks) > 0 {
+			blockers[taskId] = tasks
+		}
+	}
+	return blockers, nil
+}
+
+func (db *memoryDB) GetDependents(taskIds []string, userId uint64) (map[string][]Task, error) {
+	defer db.rlock()()
+
+	dependentIds := make(map[string]map[string]bool)
+	for _, taskId := range taskIds {
+		dependentIds[taskId] = make(map[string]bool)
+	}
+	for dependentId, blockerIds := range db.dependencies {
+		for blockerId := range blockerIds {
+			if ids, ok := dependentIds[blockerId]; ok {
+				ids[dependentId] = true
+			}
+		}
+	}
+	dependents := make(map[string][]Task)
+	for taskId, ids := range dependentIds {
+		if tasks := db.liveTasks(ids, userId); len(tasks) > 0 {
+			dependents[taskId] = tasks
+		}
+	}
+	return dependents, nil
+}
+
+// Returns the IDs of the tasks blocking each of the given tasks, including tasks in the trash.
+func (db *memoryDB) blockerIds(taskIds []string) (map[string][]string, error) {
+	defer db.rlock()()
+
+	ids := make(map[string][]string)
+	for _, taskId := range taskIds {
+		for blockerId := range db.dependencies[taskId] {
+			ids[taskId] = append(ids[taskId], blockerId)
+		}
+	}
+	return ids, nil
+}
+
+func (db *memoryDB) AddDependency(taskId string, blockedById string, userId uint64) error {
+	return db.WithTx(func(tx Database) error {
+		mtx := tx.(*memoryDB)
+		if err := validateDependency(tx, mtx.blockerIds, taskId, blockedById, userId); err != nil {
+			return err
+		}
+		mtx.saveSet(mtx.dependencies, taskId)
+		if mtx.dependencies[taskId] == nil {
+			mtx.dependencies[taskId] = make(map[string]bool)
+		}
+		mtx.dependencies[taskId][blockedById] = true
+		return nil
+	})
+}
+
+func (db *memoryDB) RemoveDependency(taskId string, blockedById string, userId uint64) (bool, error) {
+	defer db.lock()()
+
+	task, ok := db.tasks[taskId]
+	if !ok || task.UserId != userId || !db.dependencies[taskId][blockedById] {
+		return false, nil
+	}
+	db.saveSet(db.dependencies, taskId)
+	delete(db.dependencies[taskId], blockedById)
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package data
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestMemoryDatabaseScopesTasksToUsers(t *testing.T) {
+	db := NewMemoryDatabase()
+	owner, err := db.CreateUser("owner", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := db.CreateUser("other", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Title: "Mine"}
+	if err := db.AddTask(task, owner.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetTask(task.Id, other.Id, nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetTask as another user returned %v, want gorm.ErrRecordNotFound", err)
+	}
+	habit := HabitEnum
+	if _, err := db.GetTask(task.Id, owner.Id, &habit); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetTask of a task as a habit returned %v, want gorm.ErrRecordNotFound", err)
+	}
+	if _, err := db.UpdateTask(task.Id, other.Id, map[string]interface{}{"title": "Theirs"}, nil); err == nil {
+		t.Error("UpdateTask as another user succeeded")
+	}
+	if deleted, err := db.DeleteTask(task.Id, other.Id); err != nil || deleted {
+		t.Errorf("DeleteTask as another user returned %v, %v, want false", deleted, err)
+	}
+	page, err := db.GetTasks(other.Id, TaskQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Tasks) != 0 {
+		t.Errorf("GetTasks as another user returned %v", page.Tasks)
+	}
+
+	got, err := db.GetTask(task.Id, owner.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Mine" {
+		t.Errorf("Title is %q after updates by another user, want %q", got.Title, "Mine")
+	}
+	if deleted, err := db.DeleteTask(task.Id, owner.Id); err != nil || !deleted {
+		t.Errorf("DeleteTask returned %v, %v, want true", deleted, err)
+	}
+	if _, err := db.GetTask(task.Id, owner.Id, nil); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetTask of a deleted task returned %v, want gorm.ErrRecordNotFound", err)
+	}
+}
+
+func TestMemoryDatabaseReturnsCopies(t *testing.T) {
+	db := NewMemoryDatabase()
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Title: "Original"}
+	if err := db.AddTask(task, user.Id); err != nil {
+		t.Fatal(err)
+	}
+	task.Title = "Changed after adding"
+	got, err := db.GetTask(task.Id, user.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Title = "Changed after getting"
+	got, err = db.GetTask(task.Id, user.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Original" {
+		t.Errorf("Title is %q, want %q", got.Title, "Original")
+	}
+}
+
+func TestMemoryDatabaseUsers(t *testing.T) {
+	db := NewMemoryDatabase()
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateUser("test", "other password"); err == nil {
+		t.Error("CreateUser with a taken username succeeded")
+	}
+	got, err := db.GetUserByUsername("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Id != user.Id {
+		t.Errorf("GetUserByUsername returned user %d, want %d", got.Id, user.Id)
+	}
+	if _, err := db.GetUserById(user.Id + 1); err != gorm.ErrRecordNotFound {
+		t.Errorf("GetUserById of a missing user returned %v, want gorm.ErrRecordNotFound", err)
+	}
+}
+
+func TestMemoryDatabaseConcurrentWrites(t *testing.T) {
+	db := NewMemoryDatabase()
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := db.AddTask(&Task{Title: fmt.Sprintf("Task %d", i)}, user.Id); err != nil {
+				t.Error(err)
+			}
+			if _, err := db.GetTasks(user.Id, TaskQuery{}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	page, err := db.GetTasks(user.Id, TaskQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Tasks) != 20 {
+		t.Errorf("GetTasks returned %d tasks, want 20", len(page.Tasks))
+	}
+}
@@ -0,0 +1,448 @@
+package data
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Upper bounds in seconds of the latency histogram buckets.
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	// Number of observations in each bucket, not cumulative. The last one counts observations
+	// above every bound.
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{
+		buckets: make([]uint64, len(latencyBuckets)+1),
+	}
+}
+
+func (h *histogram) observe(seconds float64) {
+	i := sort.SearchFloat64s(latencyBuckets, seconds)
+	h.buckets[i]++
+	h.count++
+	h.sum += seconds
+}
+
+// Metrics collects call counts, error counts and latencies of database calls and HTTP requests,
+// and serves them in the Prometheus text exposition format.
+type Metrics struct {
+	mu sync.Mutex
+	// Keyed by the rendered labels of each series, e.g. method="GetTask"
+	dbCalls       map[string]*histogram
+	dbErrors      map[string]uint64
+	httpRequests  map[string]uint64
+	httpDurations map[string]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		dbCalls:       make(map[string]*histogram),
+		dbErrors:      make(map[string]uint64),
+		httpRequests:  make(map[string]uint64),
+		httpDurations: make(map[string]*histogram),
+	}
+}
+
+// Records a database call that started at start. Meant to be deferred with a pointer to the
+// call's error result.
+func (metrics *Metrics) observeCall(method string, start time.Time, err *error) {
+	elapsed := time.Since(start).Seconds()
+	labels := fmt.Sprintf("method=%q", method)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	h, ok := metrics.dbCalls[labels]
+	if !ok {
+		h = newHistogram()
+		metrics.dbCalls[labels] = h
+	}
+	h.observe(elapsed)
+	if *err != nil {
+		metrics.dbErrors[labels]++
+	}
+}
+
+func (metrics *Metrics) observeRequest(handler string, method string, status int, start time.Time) {
+	elapsed := time.Since(start).Seconds()
+	durationLabels := fmt.Sprintf("handler=%q", handler)
+	requestLabels := fmt.Sprintf("handler=%q,method=%q,code=%q", handler, method, strconv.Itoa(status))
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.httpRequests[requestLabels]++
+	h, ok := metrics.httpDurations[durationLabels]
+	if !ok {
+		h = newHistogram()
+		metrics.httpDurations[durationLabels] = h
+	}
+	h.observe(elapsed)
+}
+
+// Remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Wraps an HTTP handler so that its requests are counted and timed under the given name.
+func (metrics *Metrics) InstrumentHandler(name string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{w, http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		metrics.observeRequest(name, r.Method, recorder.status, start)
+	})
+}
+
+func sortedKeys(series map[string]uint64) []string {
+	keys := []string{}
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedHistogramKeys(series map[string]*histogram) []string {
+	keys := []string{}
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeCounter(w io.Writer, name string, help string, series map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, labels := range sortedKeys(series) {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, labels, series[labels])
+	}
+}
+
+func writeHistogram(w io.Writer, name string, help string, series map[string]*histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, labels := range sortedHistogramKeys(series) {
+		h := series[labels]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+// Writes every metric in the Prometheus text exposition format.
+func (metrics *Metrics) WriteText(w io.Writer) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	dbCalls := make(map[string]uint64)
+	for labels, h := range metrics.dbCalls {
+		dbCalls[labels] = h.count
+	}
+	writeCounter(w, "duet_db_calls_total", "Database calls by method.", dbCalls)
+	writeCounter(w, "duet_db_errors_total", "Database calls that returned an error by method.", metrics.dbErrors)
+	writeHistogram(w, "duet_db_call_duration_seconds", "Latency of database calls by method.", metrics.dbCalls)
+	writeCounter(w, "duet_http_requests_total", "HTTP requests by handler, method and status code.", metrics.httpRequests)
+	writeHistogram(w, "duet_http_request_duration_seconds", "Latency of HTTP requests by handler.", metrics.httpDurations)
+}
+
+// Serves the metrics for Prometheus to scrape.
+func (metrics *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.WriteText(w)
+}
+
+// metricsDB records the count, latency and errors of every call made through it, except Close.
+type metricsDB struct {
+	Database
+	metrics *Metrics
+}
+
+// Wraps db so that its calls are recorded in metrics.
+func WithMetrics(db Database, metrics *Metrics) Database {
+	return metricsDB{db, metrics}
+}
+
+func (db metricsDB) WithTx(fn func(Database) error) (err error) {
+	defer db.metrics.observeCall("WithTx", time.Now(), &err)
+	return db.Database.WithTx(func(tx Database) error {
+		return fn(metricsDB{tx, db.metrics})
+	})
+}
+
+func (db metricsDB) GetTask(taskId string, userId uint64, kind *TaskKind) (task *Task, err error) {
+	defer db.metrics.observeCall("GetTask", time.Now(), &err)
+	return db.Database.GetTask(taskId, userId, kind)
+}
+
+func (db metricsDB) GetTasks(userId uint64, query TaskQuery) (page *TaskPage, err error) {
+	defer db.metrics.observeCall("GetTasks", time.Now(), &err)
+	return db.Database.GetTasks(userId, query)
+}
+
+func (db metricsDB) AddTask(task *Task, userId uint64) (err error) {
+	defer db.metrics.observeCall("AddTask", time.Now(), &err)
+	return db.Database.AddTask(task, userId)
+}
+
+func (db metricsDB) DeleteTask(taskId string, userId uint64) (deleted bool, err error) {
+	defer db.metrics.observeCall("DeleteTask", time.Now(), &err)
+	return db.Database.DeleteTask(taskId, userId)
+}
+
+func (db metricsDB) GetDeletedTasks(userId uint64) (tasks []Task, err error) {
+	defer db.metrics.observeCall("GetDeletedTasks", time.Now(), &err)
+	return db.Database.GetDeletedTasks(userId)
+}
+
+func (db metricsDB) RestoreTask(taskId string, userId uint64) (task *Task, err error) {
+	defer db.metrics.observeCall("RestoreTask", time.Now(), &err)
+	return db.Database.RestoreTask(taskId, userId)
+}
+
+func (db metricsDB) PurgeTask(taskId string, userId uint64) (purged bool, err error) {
+	defer db.metrics.observeCall("PurgeTask", time.Now(), &err)
+	return db.Database.PurgeTask(taskId, userId)
+}
+
+func (db metricsDB) PurgeDeletedTasks(deletedBefore time.Time) (purged []Task, err error) {
+	defer db.metrics.observeCall("PurgeDeletedTasks", time.Now(), &err)
+	return db.Database.PurgeDeletedTasks(deletedBefore)
+}
+
+func (db metricsDB) UpdateTask(taskId string, userId uint64, attrs map[string]interface{}, expectedVersion *int) (task *Task, err error) {
+	defer db.metrics.observeCall("UpdateTask", time.Now(), &err)
+	return db.Database.UpdateTask(taskId, userId, attrs, expectedVersion)
+}
+
+func (db metricsDB) AddTasks(tasks []*Task, userId uint64) (errs []error, err error) {
+	defer db.metrics.observeCall("AddTasks", time.Now(), &err)
+	return addTasks(db, tasks, userId)
+}
+
+func (db metricsDB) UpdateTasks(updates []TaskUpdate, userId uint64) (tasks []*Task, errs []error, err error) {
+	defer db.metrics.observeCall("UpdateTasks", time.Now(), &err)
+	return updateTasks(db, updates, userId)
+}
+
+func (db metricsDB) DeleteTasks(taskIds []string, userId uint64) (errs []error, err error) {
+	defer db.metrics.observeCall("DeleteTasks", time.Now(), &err)
+	return deleteTasks(db, taskIds, userId)
+}
+
+func (db metricsDB) AddActions(actions []*Action, userId uint64) (errs []error, err error) {
+	defer db.metrics.observeCall("AddActions", time.Now(), &err)
+	return addActions(db, actions, userId)
+}
+
+func (db metricsDB) CreateUser(username string, password string) (user *User, err error) {
+	defer db.metrics.observeCall("CreateUser", time.Now(), &err)
+	return db.Database.CreateUser(username, password)
+}
+
+func (db metricsDB) GetUserById(id uint64) (user *User, err error) {
+	defer db.metrics.observeCall("GetUserById", time.Now(), &err)
+	return db.Database.GetUserById(id)
+}
+
+func (db metricsDB) GetUserByUsername(username string) (user *User, err error) {
+	defer db.metrics.observeCall("GetUserByUsername", time.Now(), &err)
+	return db.Database.GetUserByUsername(username)
+}
+
+func (db metricsDB) AddAction(action *Action, userId uint64) (err error) {
+	defer db.metrics.observeCall("AddAction", time.Now(), &err)
+	return db.Database.AddAction(action, userId)
+}
+
+func (db metricsDB) DeleteAction(id string, userId uint64) (err error) {
+	defer db.metrics.observeCall("DeleteAction", time.Now(), &err)
+	return db.Database.DeleteAction(id, userId)
+}
+
+func (db metricsDB) GetAction(id string, userId uint64) (action *Action, err error) {
+	defer db.metrics.observeCall("GetAction", time.Now(), &err)
+	return db.Database.GetAction(id, userId)
+}
+
+func (db metricsDB) GetActions(taskIds []string, userId uint64) (actions map[string][]Action, err error) {
+	defer db.metrics.observeCall("GetActions", time.Now(), &err)
+	return db.Database.GetActions(taskIds, userId)
+}
+
+func (db metricsDB) AddHistory(entry *HistoryEntry) (err error) {
+	defer db.metrics.observeCall("AddHistory", time.Now(), &err)
+	return db.Database.AddHistory(entry)
+}
+
+func (db metricsDB) GetHistory(taskId string, userId uint64) (entries []HistoryEntry, err error) {
+	defer db.metrics.observeCall("GetHistory", time.Now(), &err)
+	return db.Database.GetHistory(taskId, userId)
+}
+
+func (db metricsDB) GetProject(projectId string, userId uint64) (project *Project, err error) {
+	defer db.metrics.observeCall("GetProject", time.Now(), &err)
+	return db.Database.GetProject(projectId, userId)
+}
+
+func (db metricsDB) GetProjects(userId uint64, archived *bool) (projects []Project, err error) {
+	defer db.metrics.observeCall("GetProjects", time.Now(), &err)
+	return db.Database.GetProjects(userId, archived)
+}
+
+func (db metricsDB) AddProject(project *Project, userId uint64) (err error) {
+	defer db.metrics.observeCall("AddProject", time.Now(), &err)
+	return db.Database.AddProject(project, userId)
+}
+
+func (db metricsDB) UpdateProject(projectId string, userId uint64, attrs map[string]interface{}) (project *Project, err error) {
+	defer db.metrics.observeCall("UpdateProject", time.Now(), &err)
+	return db.Database.UpdateProject(projectId, userId, attrs)
+}
+
+func (db metricsDB) DeleteProject(projectId string, userId uint64) (deleted bool, err error) {
+	defer db.metrics.observeCall("DeleteProject", time.Now(), &err)
+	return db.Database.DeleteProject(projectId, userId)
+}
+
+func (db metricsDB) GetTags(userId uint64) (tags []Tag, err error) {
+	defer db.metrics.observeCall("GetTags", time.Now(), &err)
+	return db.Database.GetTags(userId)
+}
+
+func (db metricsDB) GetTaskTags(taskIds []string, userId uint64) (tags map[string][]Tag, err error) {
+	defer db.metrics.observeCall("GetTaskTags", time.Now(), &err)
+	return db.Database.GetTaskTags(taskIds, userId)
+}
+
+func (db metricsDB) AddTag(taskId string, name string, userId uint64) (tag *Tag, err error) {
+	defer db.metrics.observeCall("AddTag", time.Now(), &err)
+	return db.Database.AddTag(taskId, name, userId)
+}
+
+func (db metricsDB) RemoveTag(taskId string, name string, userId uint64) (removed bool, err error) {
+	defer db.metrics.observeCall("RemoveTag", time.Now(), &err)
+	return db.Database.RemoveTag(taskId, name, userId)
+}
+
+func (db metricsDB) RenameTag(tagId string, name string, userId uint64) (tag *Tag, err error) {
+	defer db.metrics.observeCall("RenameTag", time.Now(), &err)
+	return db.Database.RenameTag(tagId, name, userId)
+}
+
+func (db metricsDB) MergeTags(sourceId string, targetId string, userId uint64) (tag *Tag, err error) {
+	defer db.metrics.observeCall("MergeTags", time.Now(), &err)
+	return db.Database.MergeTags(sourceId, targetId, userId)
+}
+
+func (db metricsDB) DeleteTag(tagId string, userId uint64) (deleted bool, err error) {
+	defer db.metrics.observeCall("DeleteTag", time.Now(), &err)
+	return db.Database.DeleteTag(tagId, userId)
+}
+
+func (db metricsDB) GetAttachment(attachmentId string, userId uint64) (attachment *Attachment, err error) {
+	defer db.metrics.observeCall("GetAttachment", time.Now(), &err)
+	return db.Database.GetAttachment(attachmentId, userId)
+}
+
+func (db metricsDB) GetAttachments(taskIds []string, userId uint64) (attachments map[string][]Attachment, err error) {
+	defer db.metrics.observeCall("GetAttachments", time.Now(), &err)
+	return db.Database.GetAttachments(taskIds, userId)
+}
+
+func (db metricsDB) AddAttachment(attachment *Attachment, quota int64, userId uint64) (err error) {
+	defer db.metrics.observeCall("AddAttachment", time.Now(), &err)
+	return db.Database.AddAttachment(attachment, quota, userId)
+}
+
+func (db metricsDB) DeleteAttachment(attachmentId string, userId uint64) (deleted bool, err error) {
+	defer db.metrics.observeCall("DeleteAttachment", time.Now(), &err)
+	return db.Database.DeleteAttachment(attachmentId, userId)
+}
+
+func (db metricsDB) GetAttachmentUsage(userId uint64) (usage int64, err error) {
+	defer db.metrics.observeCall("GetAttachmentUsage", time.Now(), &err)
+	return db.Database.GetAttachmentUsage(userId)
+}
+
+func (db metricsDB) GetOrphanedAttachments() (attachments []Attachment, err error) {
+	defer db.metrics.observeCall("GetOrphanedAttachments", time.Now(), &err)
+	return db.Database.GetOrphanedAttachments()
+}
+
+func (db metricsDB) GetReminders(taskIds []string, userId uint64) (reminders map[string][]Reminder, err error) {
+	defer db.metrics.observeCall("GetReminders", time.Now(), &err)
+	return db.Database.GetReminders(taskIds, userId)
+}
+
+func (db metricsDB) AddReminder(reminder *Reminder, userId uint64) (err error) {
+	defer db.metrics.observeCall("AddReminder", time.Now(), &err)
+	return db.Database.AddReminder(reminder, userId)
+}
+
+func (db metricsDB) DeleteReminder(reminderId string, userId uint64) (deleted bool, err error) {
+	defer db.metrics.observeCall("DeleteReminder", time.Now(), &err)
+	return db.Database.DeleteReminder(reminderId, userId)
+}
+
+func (db metricsDB) GetDueReminders(now time.Time, limit int) (reminders []Reminder, err error) {
+	defer db.metrics.observeCall("GetDueReminders", time.Now(), &err)
+	return db.Database.GetDueReminders(now, limit)
+}
+
+func (db metricsDB) ClaimReminder(reminderId string, fireAt time.Time, next *time.Time) (claimed bool, err error) {
+	defer db.metrics.observeCall("ClaimReminder", time.Now(), &err)
+	return db.Database.ClaimReminder(reminderId, fireAt, next)
+}
+
+func (db metricsDB) GetRunningTimers(userId uint64) (timers []Timer, err error) {
+	defer db.metrics.observeCall("GetRunningTimers", time.Now(), &err)
+	return db.Database.GetRunningTimers(userId)
+}
+
+func (db metricsDB) GetForgottenTimers(startedBefore time.Time) (timers []Timer, err error) {
+	defer db.metrics.observeCall("GetForgottenTimers", time.Now(), &err)
+	return db.Database.GetForgottenTimers(startedBefore)
+}
+
+func (db metricsDB) GetBlockers(taskIds []string, userId uint64) (blockers map[string][]Task, err error) {
+	defer db.metrics.observeCall("GetBlockers", time.Now(), &err)
+	return db.Database.GetBlockers(taskIds, userId)
+}
+
+func (db metricsDB) GetDependents(taskIds []string, userId uint64) (dependents map[string][]Task, err error) {
+	defer db.metrics.observeCall("GetDependents", time.Now(), &err)
+	return db.Database.GetDependents(taskIds, userId)
+}
+
+func (db metricsDB) AddDependency(taskId string, blockedById string, userId uint64) (err error) {
+	defer db.metrics.observeCall("AddDependency", time.Now(), &err)
+	return db.Database.AddDependency(taskId, blockedById, userId)
+}
+
+func (db metricsDB) RemoveDependency(taskId string, blockedById string, userId uint64) (removed bool, err error) {
+	defer db.metrics.observeCall("RemoveDependency", time.Now(), &err)
+	return db.Database.RemoveDependency(taskId, blockedById, userId)
+}
@@ -0,0 +1,608 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// A numbered, reversible change to the schema. Migrations must never reference the live models
+// since those keep changing; each one declares the columns it touches as of when it was written.
+type migration struct {
+	Version     int
+	Description string
+	Up          func(db *gorm.DB) error
+	Down        func(db *gorm.DB) error
+}
+
+// Records which migrations have been applied to a database.
+type schemaMigration struct {
+	Version   int `gorm:"primary_key;auto_increment:false"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+// Snapshots of the tables as originally created by AutoMigrate.
+type taskV1 struct {
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt *time.Time
+	Id        string   `gorm:"primary_key;type:uuid"`
+	Kind      TaskKind `gorm:"not_null"`
+	Title     string   `gorm:"not_null"`
+	Done      bool     `gorm:"not_null;default:false"`
+	UserId    uint64   `gorm:"not_null"`
+	StartDate *time.Time
+	EndDate   *time.Time
+	Interval  Interval
+	Frequency int
+}
+
+func (taskV1) TableName() string {
+	return "tasks"
+}
+
+type userV1 struct {
+	Id             uint64 `gorm:"primary_key"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      *time.Time
+	Username       string `gorm:"not_null;unique"`
+	HashedPassword []byte `gorm:"not_null"`
+}
+
+func (userV1) TableName() string {
+	return "users"
+}
+
+type actionV1 struct {
+	Id     string     `gorm:"primary_key;type:uuid"`
+	Kind   ActionKind `gorm:"not_null"`
+	When   *time.Time `gorm:"not_null"`
+	TaskId string     `gorm:"not_null;type:uuid"`
+}
+
+func (actionV1) TableName() string {
+	return "actions"
+}
+
+type taskV4 struct {
+	Version int `gorm:"not_null;default:1"`
+}
+
+func (taskV4) TableName() string {
+	return "tasks"
+}
+
+type historyEntryV5 struct {
+	Id        string `gorm:"primary_key;type:uuid"`
+	CreatedAt time.Time
+	TaskId    string `gorm:"not_null;type:uuid;index:idx_history_entries_task_id"`
+	UserId    uint64 `gorm:"not_null"`
+	Source    string `gorm:"not_null"`
+	Operation string `gorm:"not_null"`
+	Changes   string `gorm:"type:text"`
+}
+
+func (historyEntryV5) TableName() string {
+	return "history_entries"
+}
+
+type taskV6 struct {
+	ParentId     *string `gorm:"type:uuid"`
+	AutoComplete bool    `gorm:"not_null;default:false"`
+}
+
+func (taskV6) TableName() string {
+	return "tasks"
+}
+
+type projectV7 struct {
+	Id        string `gorm:"primary_key;type:uuid"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserId    uint64 `gorm:"not_null;index:idx_projects_user_id"`
+	Name      string `gorm:"not_null"`
+	Color     string `gorm:"not_null;default:''"`
+	Archived  bool   `gorm:"not_null;default:false"`
+	Position  int    `gorm:"not_null;default:0"`
+}
+
+func (projectV7) TableName() string {
+	return "projects"
+}
+
+type taskV7 struct {
+	ProjectId *string `gorm:"type:uuid"`
+}
+
+func (taskV7) TableName() string {
+	return "tasks"
+}
+
+type tagV8 struct {
+	Id        string `gorm:"primary_key;type:uuid"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserId    uint64 `gorm:"not_null;unique_index:idx_tags_user_id_name"`
+	Name      string `gorm:"not_null;unique_index:idx_tags_user_id_name"`
+}
+
+func (tagV8) TableName() string {
+	return "tags"
+}
+
+type taskTagV8 struct {
+	TaskId string `gorm:"primary_key;type:uuid"`
+	TagId  string `gorm:"primary_key;type:uuid;index:idx_task_tags_tag_id"`
+}
+
+func (taskTagV8) TableName() string {
+	return "task_tags"
+}
+
+type taskV9 struct {
+	Id        string `gorm:"primary_key;type:uuid"`
+	CreatedAt time.Time
+	UserId    uint64
+	Priority  int    `gorm:"not_null;default:0"`
+	Position  string `gorm:"not_null;default:''"`
+}
+
+func (taskV9) TableName() string {
+	return "tasks"
+}
+
+// The ranks of migration 9, as rankAfter made them when it was written.
+const rankDigitsV9 = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func rankDigitV9(rank string, i int) int {
+	if i >= len(rank) {
+		return 0
+	}
+	if d := strings.IndexByte(rankDigitsV9, rank[i]); d >= 0 {
+		return d
+	}
+	return 0
+}
+
+// Returns the rank after the given one, or the first rank if it is empty. Counts up in the first 4
+// digits, and once those run out, makes the rank longer.
+func rankAfterV9(rank string) string {
+	if rank == "" {
+		return "i"
+	}
+	digits := make([]byte, 4)
+	step := 1
+	for i := 3; i >= 0; i-- {
+		d := rankDigitV9(rank, i) + step
+		step = 0
+		if d == len(rankDigitsV9) {
+			d, step = 0, 1
+		}
+		digits[i] = rankDigitsV9[d]
+	}
+	if step == 0 {
+		return strings.TrimRight(string(digits), "0")
+	}
+	return rankPastV9(rank)
+}
+
+// Returns a rank that sorts after the given one with no upper bound.
+func rankPastV9(rank string) string {
+	lo := rankDigitV9(rank, 0)
+	if len(rankDigitsV9)-lo > 1 {
+		mid := (lo + len(rankDigitsV9)) / 2
+		return rankDigitsV9[mid : mid+1]
+	}
+	rest := ""
+	if len(rank) > 1 {
+		rest = rank[1:]
+	}
+	return rankDigitsV9[lo:lo+1] + rankPastV9(rest)
+}
+
+type taskV10 struct {
+	Notes string `gorm:"type:text;not_null;default:''"`
+}
+
+func (taskV10) TableName() string {
+	return "tasks"
+}
+
+type attachmentV11 struct {
+	Id          string `gorm:"primary_key;type:uuid"`
+	CreatedAt   time.Time
+	TaskId      string `gorm:"not_null;type:uuid;index:idx_attachments_task_id"`
+	UserId      uint64 `gorm:"not_null;index:idx_attachments_user_id"`
+	Filename    string `gorm:"not_null"`
+	ContentType string `gorm:"not_null"`
+	Size        int64  `gorm:"not_null"`
+}
+
+func (attachmentV11) TableName() string {
+	return "attachments"
+}
+
+type reminderV12 struct {
+	Id            string `gorm:"primary_key;type:uuid"`
+	CreatedAt     time.Time
+	TaskId        string `gorm:"not_null;type:uuid;index:idx_reminders_task_id"`
+	UserId        uint64 `gorm:"not_null"`
+	At            *time.Time
+	OffsetMinutes *int
+	FireAt        *time.Time `gorm:"index:idx_reminders_fire_at"`
+	FiredAt       *time.Time
+}
+
+func (reminderV12) TableName() string {
+	return "reminders"
+}
+
+type taskV13 struct {
+	Recurrence string  `gorm:"not_null;default:''"`
+	NextId     *string `gorm:"type:uuid"`
+}
+
+func (taskV13) TableName() string {
+	return "tasks"
+}
+
+type taskDependencyV14 struct {
+	TaskId      string `gorm:"primary_key;type:uuid"`
+	BlockedById string `gorm:"primary_key;type:uuid;index:idx_task_dependencies_blocked_by_id"`
+}
+
+func (taskDependencyV14) TableName() string {
+	return "task_dependencies"
+}
+
+// All migrations in the order they are applied. Versions must be consecutive.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "Create tasks, users and actions",
+		Up: func(db *gorm.DB) error {
+			// Databases created before migrations existed already have these tables
+			return db.AutoMigrate(&taskV1{}, &userV1{}, &actionV1{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&actionV1{}, &userV1{}, &taskV1{}).Error
+		},
+	},
+	{
+		Version:     2,
+		Description: "Index tasks by user and actions by task",
+		Up: func(db *gorm.DB) error {
+			if err := db.Model(&taskV1{}).AddIndex("idx_tasks_user_id", "user_id").Error; err != nil {
+				return err
+			}
+			return db.Model(&actionV1{}).AddIndex("idx_actions_task_id", "task_id").Error
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Model(&actionV1{}).RemoveIndex("idx_actions_task_id").Error; err != nil {
+				return err
+			}
+			return db.Model(&taskV1{}).RemoveIndex("idx_tasks_user_id").Error
+		},
+	},
+	{
+		Version:     3,
+		Description: "Index tasks by deletion time for trash retention",
+		Up: func(db *gorm.DB) error {
+			return db.Model(&taskV1{}).AddIndex("idx_tasks_deleted_at", "deleted_at").Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Model(&taskV1{}).RemoveIndex("idx_tasks_deleted_at").Error
+		},
+	},
+	{
+		Version:     4,
+		Description: "Add version to tasks for optimistic concurrency",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&taskV4{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Model(&taskV4{}).DropColumn("version").Error
+		},
+	},
+	{
+		Version:     5,
+		Description: "Create history_entries",
+		Up: func(db *gorm.DB) error {
+			return db.CreateTable(&historyEntryV5{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTable(&historyEntryV5{}).Error
+		},
+	},
+	{
+		Version:     6,
+		Description: "Add parent and auto-completion to tasks for subtasks",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&taskV6{}).Error; err != nil {
+				return err
+			}
+			return db.Model(&taskV6{}).AddIndex("idx_tasks_parent_id", "parent_id").Error
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Model(&taskV6{}).RemoveIndex("idx_tasks_parent_id").Error; err != nil {
+				return err
+			}
+			if err := db.Model(&taskV6{}).DropColumn("auto_complete").Error; err != nil {
+				return err
+			}
+			return db.Model(&taskV6{}).DropColumn("parent_id").Error
+		},
+	},
+	{
+		Version:     7,
+		Description: "Create projects and add a project to tasks",
+		Up: func(db *gorm.DB) error {
+			if err := db.CreateTable(&projectV7{}).Error; err != nil {
+				return err
+			}
+			if err := db.AutoMigrate(&taskV7{}).Error; err != nil {
+				return err
+			}
+			return db.Model(&taskV7{}).AddIndex("idx_tasks_project_id", "project_id").Error
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Model(&taskV7{}).RemoveIndex("idx_tasks_project_id").Error; err != nil {
+				return err
+			}
+			if err := db.Model(&taskV7{}).DropColumn("project_id").Error; err != nil {
+				return err
+			}
+			return db.DropTable(&projectV7{}).Error
+		},
+	},
+	{
+		Version:     8,
+		Description: "Create tags and the tags of tasks",
+		Up: func(db *gorm.DB) error {
+			return db.CreateTable(&tagV8{}, &taskTagV8{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTable(&taskTagV8{}, &tagV8{}).Error
+		},
+	},
+	{
+		Version:     9,
+		Description: "Add priority and manual position to tasks",
+		Up: func(db *gorm.DB) error {
+			if err := db.AutoMigrate(&taskV9{}).Error; err != nil {
+				return err
+			}
+			// Existing tasks, including those in the trash, keep their order of creation
+			var tasks []taskV9
+			if err := db.Order("user_id").Order("created_at").Order("id").Find(&tasks).Error; err != nil {
+				return err
+			}
+			last := make(map[uint64]string)
+			for _, task := range tasks {
+				last[task.UserId] = rankAfterV9(last[task.UserId])
+				if err := db.Model(&task).UpdateColumn("position", last[task.UserId]).Error; err != nil {
+					return err
+				}
+			}
+			return db.Model(&taskV9{}).AddIndex("idx_tasks_user_id_position", "user_id", "position").Error
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Model(&taskV9{}).RemoveIndex("idx_tasks_user_id_position").Error; err != nil {
+				return err
+			}
+			if err := db.Model(&taskV9{}).DropColumn("position").Error; err != nil {
+				return err
+			}
+			return db.Model(&taskV9{}).DropColumn("priority").Error
+		},
+	},
+	{
+		Version:     10,
+		Description: "Add notes to tasks",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&taskV10{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.Model(&taskV10{}).DropColumn("notes").Error
+		},
+	},
+	{
+		Version:     11,
+		Description: "Create attachments of tasks",
+		Up: func(db *gorm.DB) error {
+			return db.CreateTable(&attachmentV11{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTable(&attachmentV11{}).Error
+		},
+	},
+	{
+		Version:     12,
+		Description: "Create reminders of tasks and habits",
+		Up: func(db *gorm.DB) error {
+			return db.CreateTable(&reminderV12{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTable(&reminderV12{}).Error
+		},
+	},
+	{
+		Version:     13,
+		Description: "Add recurrence rules to tasks",
+		Up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&taskV13{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			if err := db.Model(&taskV13{}).DropColumn("next_id").Error; err != nil {
+				return err
+			}
+			return db.Model(&taskV13{}).DropColumn("recurrence").Error
+		},
+	},
+	{
+		Version:     14,
+		Description: "Create dependencies between tasks",
+		Up: func(db *gorm.DB) error {
+			return db.CreateTable(&taskDependencyV14{}).Error
+		},
+		Down: func(db *gorm.DB) error {
+			return db.DropTable(&taskDependencyV14{}).Error
+		},
+	},
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// Returns the version of the most recently applied migration, or 0 for an empty database.
+func SchemaVersion(db *gorm.DB) (int, error) {
+	if err := db.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := db.Model(&schemaMigration{}).Select("max(version)").Row().Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// Runs a single migration step and records the new version in the same transaction.
+func runMigration(db *gorm.DB, m migration, up bool) error {
+	tx := db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	var err error
+	if up {
+		err = m.Up(tx)
+		if err == nil {
+			err = tx.Create(&schemaMigration{Version: m.Version, AppliedAt: time.Now()}).Error
+		}
+	} else {
+		err = m.Down(tx)
+		if err == nil {
+			err = tx.Delete(&schemaMigration{Version: m.Version}).Error
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Migration %d (%s) failed: %s", m.Version, m.Description, err)
+	}
+	return tx.Commit().Error
+}
+
+// Applies every pending migration and returns the versions that were applied.
+func MigrateUp(db *gorm.DB) ([]int, error) {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if current > latestSchemaVersion() {
+		return nil, fmt.Errorf("Database schema version %d is newer than the latest known version %d", current, latestSchemaVersion())
+	}
+
+	applied := []int{}
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+		if err := runMigration(db, m, true); err != nil {
+			return applied, err
+		}
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
+
+// Reverting migrations drops columns, which SQLite only supports from 3.35 on. Older versions are
+// refused up front rather than failing partway through.
+func checkCanDropColumns(db *gorm.DB) error {
+	if db.Dialect().GetName() != "sqlite3" {
+		return nil
+	}
+	var version string
+	if err := db.Raw("SELECT sqlite_version()").Row().Scan(&version); err != nil {
+		return err
+	}
+	parts := strings.Split(version, ".")
+	major, minor := 0, 0
+	if len(parts) >= 2 {
+		major, _ = strconv.Atoi(parts[0])
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	if major < 3 || major == 3 && minor < 35 {
+		return fmt.Errorf("Reverting migrations needs SQLite 3.35 or newer to drop columns, but the database is SQLite %s", version)
+	}
+	return nil
+}
+
+// Reverts the given number of most recently applied migrations and returns the reverted versions.
+func MigrateDown(db *gorm.DB, steps int) ([]int, error) {
+	if err := checkCanDropColumns(db); err != nil {
+		return nil, err
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+	if current > latestSchemaVersion() {
+		return nil, fmt.Errorf("Database schema version %d is newer than the latest known version %d", current, latestSchemaVersion())
+	}
+
+	reverted := []int{}
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := migrations[i]
+		if m.Version > current {
+			continue
+		}
+		if err := runMigration(db, m, false); err != nil {
+			return reverted, err
+		}
+		reverted = append(reverted, m.Version)
+	}
+	return reverted, nil
+}
+
+// Lists every known migration along with when it was applied, if it has been.
+func GetMigrationStatus(db *gorm.DB) ([]MigrationStatus, error) {
+	if err := db.AutoMigrate(&schemaMigration{}).Error; err != nil {
+		return nil, err
+	}
+	var applied []schemaMigration
+	if err := db.Find(&applied).Error; err != nil {
+		return nil, err
+	}
+	appliedAt := make(map[int]time.Time)
+	for _, m := range applied {
+		appliedAt[m.Version] = m.AppliedAt
+	}
+
+	statuses := []MigrationStatus{}
+	for _, m := range migrations {
+		status := MigrationStatus{
+			Version:     m.Version,
+			Description: m.Description,
+		}
+		if t, ok := appliedAt[m.Version]; ok {
+			status.AppliedAt = &t
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
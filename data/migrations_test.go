@@ -0,0 +1,130 @@
+package data
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Opens an empty SQLite database in a temporary directory and returns it along with the function
+// that closes and removes it.
+func openTestDatabase(t *testing.T) (*gorm.DB, func()) {
+	dir, err := ioutil.TempDir("", "duet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := OpenDatabase("sqlite3", "", "", filepath.Join(dir, "duet.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func allMigrationVersions() []int {
+	versions := []int{}
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db, closeDB := openTestDatabase(t)
+	defer closeDB()
+
+	applied, err := MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := allMigrationVersions(); !equalInts(applied, want) {
+		t.Errorf("MigrateUp applied %v, want %v", applied, want)
+	}
+	if version, err := SchemaVersion(db); err != nil || version != latestSchemaVersion() {
+		t.Errorf("SchemaVersion is %d, %v after migrating up, want %d", version, err, latestSchemaVersion())
+	}
+	if applied, err := MigrateUp(db); err != nil || len(applied) != 0 {
+		t.Errorf("MigrateUp of an up to date schema applied %v, %v, want nothing", applied, err)
+	}
+	if !db.HasTable(&Task{}) || !db.HasTable(&taskDependency{}) {
+		t.Error("Tables are missing after migrating up")
+	}
+
+	reverted, err := MigrateDown(db, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := latestSchemaVersion()
+	if want := []int{latest, latest - 1}; !equalInts(reverted, want) {
+		t.Errorf("MigrateDown(2) reverted %v, want %v", reverted, want)
+	}
+	if version, err := SchemaVersion(db); err != nil || version != latest-2 {
+		t.Errorf("SchemaVersion is %d, %v after reverting 2 migrations, want %d", version, err, latest-2)
+	}
+	statuses, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if pending := status.AppliedAt == nil; pending != (status.Version > latest-2) {
+			t.Errorf("Migration %d has AppliedAt %v", status.Version, status.AppliedAt)
+		}
+	}
+
+	if _, err := MigrateDown(db, len(migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if db.HasTable(&Task{}) || db.HasTable(&User{}) || db.HasTable(&Action{}) {
+		t.Error("Tables are left after reverting every migration")
+	}
+
+	// The schema can be rebuilt and used after being torn down
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	user, err := gormDB{db}.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (gormDB{db}).AddTask(&Task{Title: "After migrating"}, user.Id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	db, closeDB := openTestDatabase(t)
+	defer closeDB()
+
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	newer := &schemaMigration{Version: latestSchemaVersion() + 1, AppliedAt: time.Now()}
+	if err := db.Create(newer).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MigrateUp(db); err == nil {
+		t.Error("MigrateUp of a newer schema succeeded")
+	}
+	if _, err := MigrateDown(db, 1); err == nil {
+		t.Error("MigrateDown of a newer schema succeeded")
+	}
+}
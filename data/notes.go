@@ -0,0 +1,272 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// An item of a checklist in a task's notes, written as "- [ ] text" or "- [x] text".
+type ChecklistItem struct {
+	// The line of the notes the item is on, counting from 0
+	Line    int    `json:"line"`
+	Text    string `json:"text"`
+	Checked bool   `json:"checked"`
+}
+
+var (
+	headingPattern   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*$`)
+	checklistPattern = regexp.MustCompile(`^\s*[-*+]\s+\[([ xX])\]\s+(.*)$`)
+	bulletPattern    = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	numberedPattern  = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	quotePattern     = regexp.MustCompile(`^\s*>\s?(.*)$`)
+)
+
+// Characters that a backslash makes literal
+const markdownPunctuation = "\\`*_{}[]()#+-.!>"
+
+// Link schemes allowed in rendered notes, so links can't run scripts
+var safeURLSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+func splitLines(text string) []string {
+	return strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+}
+
+func isFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+// Reports whether the line is a rule: three or more of the same one of -, * or _, optionally
+// separated by spaces.
+func isRule(line string) bool {
+	marks := strings.Replace(strings.TrimSpace(line), " ", "", -1)
+	if len(marks) < 3 || strings.IndexByte("-*_", marks[0]) < 0 {
+		return false
+	}
+	return strings.Count(marks, marks[:1]) == len(marks)
+}
+
+// Returns the checklist items in the notes, skipping any inside code blocks.
+func parseChecklist(notes string) []ChecklistItem {
+	items := []ChecklistItem{}
+	inCode := false
+	for i, line := range splitLines(notes) {
+		if isFence(line) {
+			inCode = !inCode
+			continue
+		}
+		if match := checklistPattern.FindStringSubmatch(line); match != nil && !inCode {
+			items = append(items, ChecklistItem{
+				Line:    i,
+				Text:    strings.TrimSpace(match[2]),
+				Checked: match[1] != " ",
+			})
+		}
+	}
+	return items
+}
+
+// Renders Markdown notes as HTML. Only a subset of Markdown is supported: paragraphs, headings,
+// bullet, numbered and checklist items, block quotes, rules, fenced code blocks, emphasis, inline
+// code and links. The result is safe to embed in a page: HTML in the notes is escaped rather than
+// passed through, and only http, https and mailto links are kept.
+func renderMarkdown(notes string) string {
+	var out bytes.Buffer
+	paragraph := []string{}
+	quote := []string{}
+	list := ""
+	inCode := false
+
+	// Closes whatever block is open
+	flush := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + renderInline(strings.Join(paragraph, "\n")) + "</p>\n")
+			paragraph = paragraph[:0]
+		}
+		if len(quote) > 0 {
+			out.WriteString("<blockquote><p>" + renderInline(strings.Join(quote, "\n")) + "</p></blockquote>\n")
+			quote = quote[:0]
+		}
+		if list != "" {
+			out.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	listItem := func(tag string, item string) {
+		if list != tag {
+			flush()
+			out.WriteString("<" + tag + ">\n")
+			list = tag
+		}
+		out.WriteString("<li>" + item + "</li>\n")
+	}
+
+	for _, line := range splitLines(notes) {
+		if inCode {
+			if isFence(line) {
+				out.WriteString("</code></pre>\n")
+				inCode = false
+			} else {
+				out.WriteString(html.EscapeString(line) + "\n")
+			}
+			continue
+		}
+
+		if isFence(line) {
+			flush()
+			out.WriteString("<pre><code>")
+			inCode = true
+		} else if strings.TrimSpace(line) == "" {
+			flush()
+		} else if match := headingPattern.FindStringSubmatch(line); match != nil {
+			flush()
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", len(match[1]), renderInline(match[2]), len(match[1]))
+		} else if isRule(line) {
+			flush()
+			out.WriteString("<hr>\n")
+		} else if match := checklistPattern.FindStringSubmatch(line); match != nil {
+			checked := ""
+			if match[1] != " " {
+				checked = " checked"
+			}
+			listItem("ul", `<input type="checkbox" disabled`+checked+`> `+renderInline(match[2]))
+		} else if match := bulletPattern.FindStringSubmatch(line); match != nil {
+			listItem("ul", renderInline(match[1]))
+		} else if match := numberedPattern.FindStringSubmatch(line); match != nil {
+			listItem("ol", renderInline(match[1]))
+		} else if match := quotePattern.FindStringSubmatch(line); match != nil {
+			if len(quote) == 0 {
+				flush()
+			}
+			quote = append(quote, match[1])
+		} else {
+			if len(paragraph) == 0 {
+				flush()
+			}
+			paragraph = append(paragraph, strings.TrimSpace(line))
+		}
+	}
+	if inCode {
+		out.WriteString("</code></pre>\n")
+	}
+	flush()
+	return out.String()
+}
+
+// Renders the emphasis, code spans and links in a line of Markdown, escaping everything else.
+func renderInline(text string) string {
+	var out bytes.Buffer
+	plain := 0
+	emit := func(i int, rendered string, next int) int {
+		out.WriteString(html.EscapeString(text[plain:i]))
+		out.WriteString(rendered)
+		plain = next
+		return next
+	}
+
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case '\\':
+			if i+1 < len(text) && strings.IndexByte(markdownPunctuation, text[i+1]) >= 0 {
+				i = emit(i, html.EscapeString(text[i+1:i+2]), i+2)
+				continue
+			}
+		case '`':
+			if end := strings.IndexByte(text[i+1:], '`'); end >= 0 {
+				i = emit(i, "<code>"+html.EscapeString(text[i+1:i+1+end])+"</code>", i+end+2)
+				continue
+			}
+		case '[':
+			if label, target, n, ok := parseLink(text[i:]); ok {
+				rendered := renderInline(label)
+				if safeURL(target) {
+					rendered = `<a href="` + html.EscapeString(target) + `" rel="nofollow noopener noreferrer">` + rendered + "</a>"
+				}
+				i = emit(i, rendered, i+n)
+				continue
+			}
+		case '*', '_':
+			delim := text[i : i+1]
+			if strings.HasPrefix(text[i:], delim+delim+delim) {
+				if inner, n, ok := parseEmphasis(text, i, delim+delim+delim); ok {
+					i = emit(i, "<strong><em>"+renderInline(inner)+"</em></strong>", i+n)
+					continue
+				}
+			}
+			if strings.HasPrefix(text[i:], delim+delim) {
+				if inner, n, ok := parseEmphasis(text, i, delim+delim); ok {
+					i = emit(i, "<strong>"+renderInline(inner)+"</strong>", i+n)
+					continue
+				}
+			}
+			if inner, n, ok := parseEmphasis(text, i, delim); ok {
+				i = emit(i, "<em>"+renderInline(inner)+"</em>", i+n)
+				continue
+			}
+		}
+		i++
+	}
+	out.WriteString(html.EscapeString(text[plain:]))
+	return out.String()
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// Parses emphasis starting at text[start] with the given delimiter and returns the emphasized text
+// and the length of the whole span. Underscores only count at the edges of words, so names like
+// snake_case stay as they are.
+func parseEmphasis(text string, start int, delim string) (string, int, bool) {
+	open := start + len(delim)
+	if open >= len(text) || text[open] == ' ' || text[open] == delim[0] {
+		return "", 0, false
+	}
+	if delim[0] == '_' && start > 0 && isWordByte(text[start-1]) {
+		return "", 0, false
+	}
+	for i := open + 1; i+len(delim) <= len(text); i++ {
+		if text[i:i+len(delim)] != delim || text[i-1] == ' ' {
+			continue
+		}
+		end := i + len(delim)
+		if end < len(text) && text[end] == delim[0] {
+			continue
+		}
+		if delim[0] == '_' && end < len(text) && isWordByte(text[end]) {
+			continue
+		}
+		return text[open:i], end - start, true
+	}
+	return "", 0, false
+}
+
+// Parses a link like [label](target) at the start of text and returns its label, its target and
+// its length.
+func parseLink(text string) (string, string, int, bool) {
+	labelEnd := strings.IndexByte(text, ']')
+	if labelEnd < 0 || labelEnd+1 >= len(text) || text[labelEnd+1] != '(' {
+		return "", "", 0, false
+	}
+	targetEnd := strings.IndexByte(text[labelEnd+2:], ')')
+	if targetEnd < 0 {
+		return "", "", 0, false
+	}
+	target := strings.TrimSpace(text[labelEnd+2 : labelEnd+2+targetEnd])
+	if strings.ContainsAny(target, " \t\n") {
+		return "", "", 0, false
+	}
+	return text[1:labelEnd], target, labelEnd + 3 + targetEnd, true
+}
+
+func safeURL(target string) bool {
+	parsed, err := url.Parse(target)
+	return err == nil && safeURLSchemes[strings.ToLower(parsed.Scheme)]
+}
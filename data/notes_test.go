@@ -0,0 +1,53 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		notes string
+		want  string
+	}{
+		{"Hello *world*", "<p>Hello <em>world</em></p>\n"},
+		{"# Title", "<h1>Title</h1>\n"},
+		{"- [x] done\n- [ ] todo", "<ul>\n<li><input type=\"checkbox\" disabled checked> done</li>\n<li><input type=\"checkbox\" disabled> todo</li>\n</ul>\n"},
+		{"1. one\n2. two", "<ol>\n<li>one</li>\n<li>two</li>\n</ol>\n"},
+		{"```\n<b>code</b>\n```", "<pre><code>&lt;b&gt;code&lt;/b&gt;\n</code></pre>\n"},
+		{"[site](https://example.com)", "<p><a href=\"https://example.com\" rel=\"nofollow noopener noreferrer\">site</a></p>\n"},
+	}
+	for _, test := range tests {
+		if got := renderMarkdown(test.notes); got != test.want {
+			t.Errorf("renderMarkdown(%q) = %q, want %q", test.notes, got, test.want)
+		}
+	}
+}
+
+func TestRenderMarkdownEscapesHTML(t *testing.T) {
+	for _, notes := range []string{
+		"<script>alert(1)</script>",
+		"<img src=x onerror=alert(1)>",
+		"# <script>alert(1)</script>",
+		"- <iframe src=javascript:alert(1)>",
+		"> <svg onload=alert(1)>",
+		"*<script>alert(1)</script>*",
+		"`</code><script>alert(1)</script>`",
+		"[<script>alert(1)</script>](https://example.com)",
+		"[click](javascript:alert(1))",
+		"[click](JavaScript:alert(1))",
+		"[click]( javascript:alert(1))",
+		"[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)",
+		"[click](vbscript:msgbox(1))",
+		"[click](https://example.com\" onmouseover=\"alert(1))",
+		"\\<script>alert(1)\\</script>",
+	} {
+		got := renderMarkdown(notes)
+		lower := strings.ToLower(got)
+		for _, bad := range []string{"<script", "<img", "<iframe", "<svg", "href=\"javascript:", "href=\"vbscript:", "href=\"data:", "\" onmouseover"} {
+			if strings.Contains(lower, bad) {
+				t.Errorf("renderMarkdown(%q) = %q, which contains %q", notes, got, bad)
+			}
+		}
+	}
+}
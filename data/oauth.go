@@ -119,30 +119,31 @@ func SyncTodoist(db Database, userId uint64, oauthToken string) error {
 		return fmt.Errorf("Error parsing Todoist response")
 	}
 
-	for _, item := range sync.Items {
-		var endDate *time.Time
-		if item.DueDate != "" {
-			const longForm = "Mon 02 Jan 2006 15:04:05 +0000"
-			t, err := time.Parse(longForm, item.DueDate)
-			if err != nil {
-				log.Printf("Error parsing due date '%s': '%s'", item.DueDate)
-			} else {
-				endDate = &t
+	// Import everything or nothing so a failed sync can simply be retried
+	return WithHistory(db, SourceTodoist).WithTx(func(tx Database) error {
+		for _, item := range sync.Items {
+			var endDate *time.Time
+			if item.DueDate != "" {
+				const longForm = "Mon 02 Jan 2006 15:04:05 +0000"
+				t, err := time.Parse(longForm, item.DueDate)
+				if err != nil {
+					log.Printf("Error parsing due date '%s': '%s'", item.DueDate, err)
+				} else {
+					endDate = &t
+				}
 			}
+			task := Task{
+				Kind:    TaskEnum,
+				Title:   item.Title,
+				Done:    item.Checked == 1,
+				EndDate: endDate,
+			}
+			if err := tx.AddTask(&task, userId); err != nil {
+				log.Printf("Error adding task: '%s'", err)
+				return fmt.Errorf("Error importing Todoist tasks")
+			}
+			log.Printf("Added task %s", task.Id)
 		}
-		task := Task{
-			Kind:    TaskEnum,
-			Title:   item.Title,
-			Done:    item.Checked == 1,
-			EndDate: endDate,
-		}
-		err = db.AddTask(&task, userId)
-		if err != nil {
-			log.Printf("Error adding task: '%s'", err)
-		} else {
-			log.Printf("Added task %s", task)
-		}
-	}
-
-	return nil
+		return nil
+	})
 }
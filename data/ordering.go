@@ -0,0 +1,210 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Priority int
+
+const (
+	PriorityNone Priority = iota
+	PriorityLow
+	PriorityMedium
+	PriorityHigh
+)
+
+// Tasks are kept in a manual order by their position, a rank that sorts lexicographically. A task
+// can be moved between two others by giving it a rank that sorts between theirs, so reordering
+// only ever rewrites the moved task. Ranks only use lowercase letters and digits, which sort the
+// same in every collation, and never end in the lowest digit so there is always room before them.
+const rankDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// Ranks placed after the last task or before the first one count up or down in their first
+// rankWidth digits, so they stay short.
+const rankWidth = 4
+
+// Returns the value of the rank's i-th digit, reading digits past its end as zero.
+func rankDigit(rank string, i int) int {
+	if i >= len(rank) {
+		return 0
+	}
+	if d := strings.IndexByte(rankDigits, rank[i]); d >= 0 {
+		return d
+	}
+	return 0
+}
+
+// Adds step to the number formed by the first rankWidth digits of the rank. Returns "" if the
+// result doesn't fit or is zero.
+func rankStep(rank string, step int) string {
+	digits := make([]byte, rankWidth)
+	for i := rankWidth - 1; i >= 0; i-- {
+		d := rankDigit(rank, i) + step
+		step = 0
+		if d < 0 {
+			d, step = len(rankDigits)-1, -1
+		} else if d == len(rankDigits) {
+			d, step = 0, 1
+		}
+		digits[i] = rankDigits[d]
+	}
+	if step != 0 {
+		return ""
+	}
+	return strings.TrimRight(string(digits), rankDigits[:1])
+}
+
+// Returns a rank that sorts after the given one, or the first rank if it is empty.
+func rankAfter(rank string) string {
+	if rank == "" {
+		// Start in the middle to leave room before the first task
+		return rankDigits[len(rankDigits)/2 : len(rankDigits)/2+1]
+	}
+	if after := rankStep(rank, 1); after != "" {
+		return after
+	}
+	return rankBetween(rank, "")
+}
+
+// Returns a rank that sorts before the given one.
+func rankBefore(rank string) string {
+	if before := rankStep(rank, -1); before != "" {
+		return before
+	}
+	return rankBetween("", rank)
+}
+
+// Returns a rank that sorts strictly between lower and upper, where an empty upper rank has no
+// bound. lower must sort before upper.
+func rankBetween(lower string, upper string) string {
+	if upper != "" {
+		// Keep the digits both ranks start with, reading missing digits of lower as zeros
+		n := 0
+		for n < len(upper) && rankDigit(lower, n) == rankDigit(upper, n) {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(lower) {
+				rest = lower[n:]
+			}
+			return upper[:n] + rankBetween(rest, upper[n:])
+		}
+	}
+
+	lo := rankDigit(lower, 0)
+	hi := len(rankDigits)
+	if upper != "" {
+		hi = rankDigit(upper, 0)
+	}
+	if hi-lo > 1 {
+		return rankDigits[(lo+hi)/2 : (lo+hi)/2+1]
+	}
+	// The first digits are consecutive, so the rank has to be longer
+	if len(upper) > 1 {
+		return upper[:1]
+	}
+	rest := ""
+	if len(lower) > 1 {
+		rest = lower[1:]
+	}
+	return rankDigits[lo:lo+1] + rankBetween(rest, "")
+}
+
+// Returns the position of the first task after the given one in the order that doesn't share its
+// position, skipping the task being moved, or "" if there is none.
+func adjacentPosition(db Database, userId uint64, task *Task, order TaskOrder, movedId string) (string, error) {
+	query := TaskQuery{
+		Order: order,
+		First: 10,
+		After: TaskCursor(task, order),
+	}
+	for {
+		page, err := db.GetTasks(userId, query)
+		if err != nil {
+			return "", err
+		}
+		for _, next := range page.Tasks {
+			if next.Id != movedId && next.Position != task.Position {
+				return next.Position, nil
+			}
+		}
+		if !page.HasNextPage {
+			return "", nil
+		}
+		query.After = page.EndCursor()
+	}
+}
+
+// Moves a task or habit in the manual order so it comes right before the task with ID before
+// and/or right after the one with ID after. Only the moved task is updated. If expectedVersion is
+// set and the task has since been modified, nothing is changed and a *ConflictError is returned.
+func MoveTask(db Database, taskId string, userId uint64, before *string, after *string, expectedVersion *int) (*Task, error) {
+	if before == nil && after == nil {
+		return nil, fmt.Errorf("A task to move task ID \"%s\" before or after is required", taskId)
+	}
+
+	var task *Task
+	err := db.WithTx(func(tx Database) error {
+		if _, err := tx.GetTask(taskId, userId, nil); err != nil {
+			return fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", taskId, userId)
+		}
+		neighbor := func(id string) (*Task, error) {
+			if id == taskId {
+				return nil, fmt.Errorf("Task ID \"%s\" can't be moved next to itself", taskId)
+			}
+			found, err := tx.GetTask(id, userId, nil)
+			if err != nil {
+				return nil, fmt.Errorf("Task ID \"%s\" does not exist for user \"%d\"", id, userId)
+			}
+			return found, nil
+		}
+
+		lower, upper := "", ""
+		if after != nil {
+			previous, err := neighbor(*after)
+			if err != nil {
+				return err
+			}
+			lower = previous.Position
+			if before == nil {
+				if upper, err = adjacentPosition(tx, userId, previous, OrderPositionAsc, taskId); err != nil {
+					return err
+				}
+			}
+		}
+		if before != nil {
+			next, err := neighbor(*before)
+			if err != nil {
+				return err
+			}
+			upper = next.Position
+			if after == nil {
+				if lower, err = adjacentPosition(tx, userId, next, OrderPositionDesc, taskId); err != nil {
+					return err
+				}
+			}
+		}
+		if after != nil && before != nil && lower >= upper {
+			return fmt.Errorf("Task ID \"%s\" does not come before task ID \"%s\"", *after, *before)
+		}
+
+		var position string
+		switch {
+		case upper == "":
+			position = rankAfter(lower)
+		case lower == "":
+			position = rankBefore(upper)
+		default:
+			position = rankBetween(lower, upper)
+		}
+		var err error
+		task, err = tx.UpdateTask(taskId, userId, map[string]interface{}{"position": position}, expectedVersion)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
@@ -0,0 +1,70 @@
+package data
+
+import (
+	"strings"
+	"testing"
+)
+
+// Checks that rank sorts strictly between lower and upper and is a valid rank.
+func checkRankBetween(t *testing.T, lower string, upper string, rank string) {
+	if rank <= lower || upper != "" && rank >= upper {
+		t.Errorf("rankBetween(%q, %q) = %q, which isn't between them", lower, upper, rank)
+	}
+	if rank == "" || strings.HasSuffix(rank, rankDigits[:1]) {
+		t.Errorf("rankBetween(%q, %q) = %q, which ends in the lowest digit", lower, upper, rank)
+	}
+	for i := range rank {
+		if strings.IndexByte(rankDigits, rank[i]) < 0 {
+			t.Errorf("rankBetween(%q, %q) = %q, which has a digit that isn't allowed", lower, upper, rank)
+		}
+	}
+}
+
+func TestRankBetween(t *testing.T) {
+	tests := []struct {
+		lower string
+		upper string
+		want  string
+	}{
+		{"", "", "i"},
+		{"a", "", "n"},
+		{"", "a", "5"},
+		{"a", "c", "b"},
+		{"a", "b", "ai"},
+		{"a", "a1", "a0i"},
+		{"az", "b", "azi"},
+		{"ab", "ac", "abi"},
+		{"z", "", "zi"},
+		{"", "1", "0i"},
+		{"a5", "b", "ak"},
+	}
+	for _, test := range tests {
+		got := rankBetween(test.lower, test.upper)
+		if got != test.want {
+			t.Errorf("rankBetween(%q, %q) = %q, want %q", test.lower, test.upper, got, test.want)
+		}
+		checkRankBetween(t, test.lower, test.upper, got)
+	}
+}
+
+func TestRankBetweenRepeatedly(t *testing.T) {
+	// Inserting over and over at the same spot is the worst case for the length of ranks
+	lower, upper := "", ""
+	for i := 0; i < 200; i++ {
+		rank := rankBetween(lower, upper)
+		checkRankBetween(t, lower, upper, rank)
+		if i%2 == 0 {
+			upper = rank
+		} else {
+			lower = rank
+		}
+	}
+	ranks := []string{rankAfter("")}
+	for i := 0; i < 100; i++ {
+		ranks = append(ranks, rankAfter(ranks[len(ranks)-1]))
+		ranks = append([]string{rankBefore(ranks[0])}, ranks...)
+	}
+	for i := 1; i < len(ranks); i++ {
+		checkRankBetween(t, ranks[i-1], "", ranks[i])
+	}
+}
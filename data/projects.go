@@ -0,0 +1,94 @@
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// A list that groups some of a user's tasks and habits. Deleting a project moves its tasks and
+// habits out of it rather than deleting them.
+type Project struct {
+	Id        string    `json:"id" gorm:"primary_key;type:uuid"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserId    uint64    `json:"user_id" gorm:"not_null"`
+	Name      string    `json:"name" gorm:"not_null"`
+	// A hex color like #4a90e2, or empty for the default
+	Color    string `json:"color" gorm:"not_null;default:''"`
+	Archived bool   `json:"archived" gorm:"not_null;default:false"`
+	// Projects are listed by ascending position
+	Position int `json:"position" gorm:"not_null;default:0"`
+}
+
+func (project *Project) BeforeCreate(scope *gorm.Scope) error {
+	if project.Id == "" {
+		return scope.SetColumn("Id", newUUID())
+	}
+	return nil
+}
+
+var projectColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func validateProject(project *Project) error {
+	if project.Name == "" {
+		return fmt.Errorf("Project must have a name")
+	}
+	if project.Color != "" && !projectColorPattern.MatchString(project.Color) {
+		return fmt.Errorf("Invalid project color \"%s\", expected a hex color like #4a90e2", project.Color)
+	}
+	return nil
+}
+
+// Applies attributes keyed by column name, as accepted by gorm's Updates, to the project.
+func setProjectAttrs(project *Project, attrs map[string]interface{}) error {
+	for column, value := range attrs {
+		ok := true
+		switch column {
+		case "name":
+			project.Name, ok = value.(string)
+		case "color":
+			project.Color, ok = value.(string)
+		case "archived":
+			project.Archived, ok = value.(bool)
+		case "position":
+			project.Position, ok = value.(int)
+		default:
+			return fmt.Errorf("Unknown project attribute \"%s\"", column)
+		}
+		if !ok {
+			return fmt.Errorf("Invalid value %v for project attribute \"%s\"", value, column)
+		}
+	}
+	return nil
+}
+
+// Checks that the project a task is put in, if any, belongs to the user.
+func validateTaskProject(db Database, projectId *string, userId uint64) error {
+	if projectId == nil {
+		return nil
+	}
+	if _, err := db.GetProject(*projectId, userId); err != nil {
+		return fmt.Errorf("Project ID \"%s\" does not exist for user \"%d\"", *projectId, userId)
+	}
+	return nil
+}
+
+// Checks the project that the attributes of an update move a task into, if any.
+func validateProjectAttr(db Database, attrs map[string]interface{}, userId uint64) error {
+	projectId, _ := attrs["project_id"].(*string)
+	return validateTaskProject(db, projectId, userId)
+}
+
+type byProjectPosition []Project
+
+func (s byProjectPosition) Len() int      { return len(s) }
+func (s byProjectPosition) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byProjectPosition) Less(i, j int) bool {
+	if s[i].Position != s[j].Position {
+		return s[i].Position < s[j].Position
+	}
+	return s[i].CreatedAt.Before(s[j].CreatedAt)
+}
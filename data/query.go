@@ -0,0 +1,486 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type TaskOrder int
+
+const (
+	OrderCreatedAsc TaskOrder = iota
+	OrderCreatedDesc
+	OrderUpdatedAsc
+	OrderUpdatedDesc
+	OrderDueAsc
+	OrderDueDesc
+	OrderPriorityAsc
+	OrderPriorityDesc
+	OrderPositionAsc
+	OrderPositionDesc
+)
+
+// Selects a page of a user's tasks. The zero value matches every task of any kind, oldest first.
+type TaskQuery struct {
+	Kind          *TaskKind
+	Done          *bool
+	DueAfter      *time.Time
+	DueBefore     *time.Time
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+	// Only match the subtasks of this task
+	ParentId *string
+	// Only match the subtasks of any of these tasks
+	ParentIds []string
+	// Only match tasks in this project, or tasks in no project if empty
+	ProjectId *string
+	// Only match tasks that repeat, or that don't if false
+	Recurring *bool
+	// Only match tasks that aren't done or blocked by a task that isn't done, or only those that
+	// are if false
+	Actionable *bool
+	// Only match tasks with any of these tag names, or with all of them if MatchAll is set
+	Tags     []string
+	MatchAll bool
+	Order    TaskOrder
+	// Maximum number of tasks to return. Zero means no limit.
+	First int
+	// Cursor of the task to start after, as returned by TaskCursor.
+	After string
+}
+
+type TaskPage struct {
+	Tasks       []Task
+	Order       TaskOrder
+	HasNextPage bool
+}
+
+// Returns the cursor of the last task in the page, or "" if the page is empty.
+func (page *TaskPage) EndCursor() string {
+	if len(page.Tasks) == 0 {
+		return ""
+	}
+	return TaskCursor(&page.Tasks[len(page.Tasks)-1], page.Order)
+}
+
+// The value of the column a task is sorted by. The field for the column's type is set, unless the
+// value is null.
+type sortValue struct {
+	Time   *time.Time `json:"v"`
+	Int    *int       `json:"i,omitempty"`
+	String *string    `json:"s,omitempty"`
+}
+
+func (value sortValue) isNull() bool {
+	return value.Time == nil && value.Int == nil && value.String == nil
+}
+
+// Returns the value as an argument for a query. Must not be called on a null value.
+func (value sortValue) arg() interface{} {
+	switch {
+	case value.Time != nil:
+		return *value.Time
+	case value.Int != nil:
+		return *value.Int
+	}
+	return *value.String
+}
+
+// Compares two values of the same column that aren't null.
+func (value sortValue) compare(other sortValue) int {
+	switch {
+	case value.Time != nil && other.Time != nil:
+		if value.Time.Before(*other.Time) {
+			return -1
+		}
+		if other.Time.Before(*value.Time) {
+			return 1
+		}
+	case value.Int != nil && other.Int != nil:
+		if *value.Int < *other.Int {
+			return -1
+		}
+		if *value.Int > *other.Int {
+			return 1
+		}
+	case value.String != nil && other.String != nil:
+		if *value.String < *other.String {
+			return -1
+		}
+		if *value.String > *other.String {
+			return 1
+		}
+	}
+	return 0
+}
+
+// The position of a task within an ordering. Ties on the sort column are broken by ID.
+type taskCursor struct {
+	Order TaskOrder `json:"o"`
+	sortValue
+	Id string `json:"id"`
+}
+
+// Describes the column an order sorts by and whether it can be null.
+func (order TaskOrder) column() (column string, nullable bool, descending bool) {
+	switch order {
+	case OrderCreatedDesc:
+		return "created_at", false, true
+	case OrderUpdatedAsc:
+		return "updated_at", false, false
+	case OrderUpdatedDesc:
+		return "updated_at", false, true
+	case OrderDueAsc:
+		return "end_date", true, false
+	case OrderDueDesc:
+		return "end_date", true, true
+	case OrderPriorityAsc:
+		return "priority", false, false
+	case OrderPriorityDesc:
+		return "priority", false, true
+	case OrderPositionAsc:
+		return "position", false, false
+	case OrderPositionDesc:
+		return "position", false, true
+	}
+	return "created_at", false, false
+}
+
+func (order TaskOrder) valueOf(task *Task) sortValue {
+	switch order {
+	case OrderUpdatedAsc, OrderUpdatedDesc:
+		return sortValue{Time: &task.UpdatedAt}
+	case OrderDueAsc, OrderDueDesc:
+		return sortValue{Time: task.EndDate}
+	case OrderPriorityAsc, OrderPriorityDesc:
+		priority := int(task.Priority)
+		return sortValue{Int: &priority}
+	case OrderPositionAsc, OrderPositionDesc:
+		return sortValue{String: &task.Position}
+	}
+	return sortValue{Time: &task.CreatedAt}
+}
+
+// Returns an opaque cursor for the task's position within the given order.
+func TaskCursor(task *Task, order TaskOrder) string {
+	encoded, err := json.Marshal(taskCursor{
+		Order:     order,
+		sortValue: order.valueOf(task),
+		Id:        task.Id,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return base64.URLEncoding.EncodeToString(encoded)
+}
+
+func decodeTaskCursor(cursor string, order TaskOrder) (*taskCursor, error) {
+	decoded, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor \"%s\"", cursor)
+	}
+	var c taskCursor
+	if err := json.Unmarshal(decoded, &c); err != nil {
+		return nil, fmt.Errorf("Invalid cursor \"%s\"", cursor)
+	}
+	if c.Order != order {
+		return nil, fmt.Errorf("Cursor \"%s\" belongs to a different order", cursor)
+	}
+	return &c, nil
+}
+
+// Adds the query's filters, ordering, cursor and limit to a gorm query on tasks. One more task
+// than requested is selected so callers can tell whether there is a next page.
+func (query *TaskQuery) scope(db *gorm.DB) (*gorm.DB, error) {
+	if query.Kind != nil {
+		db = db.Where("kind = ?", *query.Kind)
+	}
+	if query.Done != nil {
+		db = db.Where("done = ?", *query.Done)
+	}
+	if query.DueAfter != nil {
+		db = db.Where("end_date >= ?", *query.DueAfter)
+	}
+	if query.DueBefore != nil {
+		db = db.Where("end_date < ?", *query.DueBefore)
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *query.CreatedBefore)
+	}
+	if query.UpdatedAfter != nil {
+		db = db.Where("updated_at >= ?", *query.UpdatedAfter)
+	}
+	if query.UpdatedBefore != nil {
+		db = db.Where("updated_at < ?", *query.UpdatedBefore)
+	}
+	if query.ParentId != nil {
+		db = db.Where("parent_id = ?", *query.ParentId)
+	}
+	if len(query.ParentIds) > 0 {
+		db = db.Where("parent_id IN (?)", query.ParentIds)
+	}
+	if query.ProjectId != nil && *query.ProjectId == "" {
+		db = db.Where("project_id IS NULL")
+	} else if query.ProjectId != nil {
+		db = db.Where("project_id = ?", *query.ProjectId)
+	}
+	if query.Recurring != nil && *query.Recurring {
+		db = db.Where("recurrence <> ''")
+	} else if query.Recurring != nil {
+		db = db.Where("recurrence = ''")
+	}
+	if query.Actionable != nil {
+		blocked := "EXISTS (SELECT 1 FROM task_dependencies JOIN tasks blockers ON blockers.id = task_dependencies.blocked_by_id " +
+			"WHERE task_dependencies.task_id = tasks.id AND blockers.done = ? AND blockers.deleted_at IS NULL)"
+		if *query.Actionable {
+			db = db.Where("done = ? AND NOT "+blocked, false, false)
+		} else {
+			db = db.Where("done = ? OR "+blocked, true, false)
+		}
+	}
+
+	if err := query.normalizeTags(); err != nil {
+		return nil, err
+	}
+	if len(query.Tags) > 0 {
+		tagged := "SELECT task_tags.task_id FROM task_tags JOIN tags ON tags.id = task_tags.tag_id WHERE tags.name IN (?)"
+		if query.MatchAll {
+			db = db.Where("id IN ("+tagged+" GROUP BY task_tags.task_id HAVING COUNT(*) = ?)", query.Tags, len(query.Tags))
+		} else {
+			db = db.Where("id IN ("+tagged+")", query.Tags)
+		}
+	}
+
+	column, nullable, descending := query.Order.column()
+	if query.After != "" {
+		after, err := decodeTaskCursor(query.After, query.Order)
+		if err != nil {
+			return nil, err
+		}
+		cmp := ">"
+		if descending {
+			cmp = "<"
+		}
+		switch {
+		case !after.isNull() && (!nullable || descending):
+			db = db.Where(fmt.Sprintf("%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?)", column, cmp), after.arg(), after.arg(), after.Id)
+		case !after.isNull():
+			db = db.Where(fmt.Sprintf("%[1]s IS NULL OR %[1]s > ? OR (%[1]s = ? AND id > ?)", column), after.arg(), after.arg(), after.Id)
+		case descending:
+			db = db.Where(fmt.Sprintf("(%[1]s IS NULL AND id < ?) OR %[1]s IS NOT NULL", column), after.Id)
+		default:
+			db = db.Where(fmt.Sprintf("%s IS NULL AND id > ?", column), after.Id)
+		}
+	}
+
+	direction := ""
+	if descending {
+		direction = " DESC"
+	}
+	if nullable {
+		db = db.Order(fmt.Sprintf("%s IS NULL%s", column, direction))
+	}
+	db = db.Order(column + direction).Order("id" + direction)
+
+	if query.First < 0 {
+		return nil, fmt.Errorf("Page size must not be negative")
+	}
+	if query.First > 0 {
+		db = db.Limit(query.First + 1)
+	}
+	return db, nil
+}
+
+// Trims the extra task selected by scope and builds the resulting page.
+func (query *TaskQuery) page(tasks []Task) *TaskPage {
+	page := &TaskPage{
+		Tasks: tasks,
+		Order: query.Order,
+	}
+	if query.First > 0 && len(tasks) > query.First {
+		page.Tasks = tasks[:query.First]
+		page.HasNextPage = true
+	}
+	return page
+}
+
+func timeInRange(t *time.Time, from *time.Time, until *time.Time) bool {
+	if t == nil {
+		return from == nil && until == nil
+	}
+	return (from == nil || !t.Before(*from)) && (until == nil || t.Before(*until))
+}
+
+func containsString(strings []string, s string) bool {
+	for _, str := range strings {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the task passes the query's filters, for backends that filter in Go.
+func (query *TaskQuery) matches(task *Task) bool {
+	if query.Kind != nil && task.Kind != *query.Kind {
+		return false
+	}
+	if query.Done != nil && task.Done != *query.Done {
+		return false
+	}
+	if query.ParentId != nil && (task.ParentId == nil || *task.ParentId != *query.ParentId) {
+		return false
+	}
+	if len(query.ParentIds) > 0 && (task.ParentId == nil || !containsString(query.ParentIds, *task.ParentId)) {
+		return false
+	}
+	if query.ProjectId != nil {
+		projectId := ""
+		if task.ProjectId != nil {
+			projectId = *task.ProjectId
+		}
+		if projectId != *query.ProjectId {
+			return false
+		}
+	}
+	if query.Recurring != nil && (task.Recurrence != "") != *query.Recurring {
+		return false
+	}
+	return timeInRange(task.EndDate, query.DueAfter, query.DueBefore) &&
+		timeInRange(&task.CreatedAt, query.CreatedAfter, query.CreatedBefore) &&
+		timeInRange(&task.UpdatedAt, query.UpdatedAfter, query.UpdatedBefore)
+}
+
+// Reports whether a task that is or isn't blocked passes the query's actionable filter, for backends
+// that filter in Go.
+func (query *TaskQuery) matchesActionable(task *Task, blocked bool) bool {
+	return query.Actionable == nil || (!task.Done && !blocked) == *query.Actionable
+}
+
+// Replaces the query's tag names with the names AddTag would store them under, without duplicates.
+// Fails on a blank name, like AddTag.
+func (query *TaskQuery) normalizeTags() error {
+	if len(query.Tags) == 0 {
+		return nil
+	}
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, name := range query.Tags {
+		name, err := normalizeTagName(name)
+		if err != nil {
+			return err
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	query.Tags = names
+	return nil
+}
+
+func (query *TaskQuery) tagNames() map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range query.Tags {
+		names[name] = true
+	}
+	return names
+}
+
+// Reports whether a task with the given tag names passes the query's tag filter, for backends that
+// filter in Go.
+func (query *TaskQuery) matchesTags(names map[string]bool) bool {
+	if len(query.Tags) == 0 {
+		return true
+	}
+	for name := range query.tagNames() {
+		if names[name] && !query.MatchAll {
+			return true
+		}
+		if !names[name] && query.MatchAll {
+			return false
+		}
+	}
+	return query.MatchAll
+}
+
+// Compares the positions of two tasks within an order. Matches the SQL ordering in scope, where
+// ascending orders sort null values last and descending orders are the exact reverse.
+func (order TaskOrder) less(a *Task, aValue sortValue, b *Task, bValue sortValue) bool {
+	var cmp int
+	switch {
+	case aValue.isNull() && !bValue.isNull():
+		cmp = 1
+	case !aValue.isNull() && bValue.isNull():
+		cmp = -1
+	case !aValue.isNull():
+		cmp = aValue.compare(bValue)
+	}
+	if cmp == 0 && a.Id != b.Id {
+		cmp = 1
+		if a.Id < b.Id {
+			cmp = -1
+		}
+	}
+	if _, _, descending := order.column(); descending {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+type taskSorter struct {
+	tasks []Task
+	order TaskOrder
+}
+
+func (s taskSorter) Len() int {
+	return len(s.tasks)
+}
+
+func (s taskSorter) Swap(i, j int) {
+	s.tasks[i], s.tasks[j] = s.tasks[j], s.tasks[i]
+}
+
+func (s taskSorter) Less(i, j int) bool {
+	a, b := &s.tasks[i], &s.tasks[j]
+	return s.order.less(a, s.order.valueOf(a), b, s.order.valueOf(b))
+}
+
+// Sorts and paginates tasks that have already been filtered, for backends that query in Go.
+func (query *TaskQuery) paginate(tasks []Task) (*TaskPage, error) {
+	if query.First < 0 {
+		return nil, fmt.Errorf("Page size must not be negative")
+	}
+	sort.Sort(taskSorter{tasks, query.Order})
+
+	if query.After != "" {
+		after, err := decodeTaskCursor(query.After, query.Order)
+		if err != nil {
+			return nil, err
+		}
+		cursorTask := &Task{Id: after.Id}
+		start := len(tasks)
+		for i := range tasks {
+			if query.Order.less(cursorTask, after.sortValue, &tasks[i], query.Order.valueOf(&tasks[i])) {
+				start = i
+				break
+			}
+		}
+		tasks = tasks[start:]
+	}
+
+	if query.First > 0 && len(tasks) > query.First+1 {
+		tasks = tasks[:query.First+1]
+	}
+	return query.page(tasks), nil
+}
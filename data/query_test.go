@@ -0,0 +1,200 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns an in-memory database and a migrated SQLite database, so tests can check that both
+// behave the same, along with the function that closes them.
+func openTestDatabases(t *testing.T) ([]Database, func()) {
+	db, closeDB := openTestDatabase(t)
+	if _, err := MigrateUp(db); err != nil {
+		closeDB()
+		t.Fatal(err)
+	}
+	return []Database{NewMemoryDatabase(), gormDB{db}}, closeDB
+}
+
+// Adds tasks for the user, failing the test if any can't be added.
+func addTestTasks(t *testing.T, db Database, userId uint64, tasks ...*Task) {
+	for _, task := range tasks {
+		if err := db.AddTask(task, userId); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func titlesOf(tasks []Task) []string {
+	titles := []string{}
+	for _, task := range tasks {
+		titles = append(titles, task.Title)
+	}
+	return titles
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Adds tasks that sort differently in most orders, and returns the time they're relative to.
+func addPaginationTasks(t *testing.T, db Database, userId uint64) time.Time {
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	at := func(days int) *time.Time {
+		t := start.AddDate(0, 0, days)
+		return &t
+	}
+	addTestTasks(t, db, userId,
+		&Task{Title: "a", CreatedAt: *at(0), EndDate: at(3), Priority: PriorityMedium},
+		&Task{Title: "b", CreatedAt: *at(1), Priority: PriorityNone},
+		&Task{Title: "c", CreatedAt: *at(2), EndDate: at(1), Priority: PriorityMedium},
+		&Task{Title: "d", CreatedAt: *at(3), EndDate: at(2), Priority: PriorityLow, Done: true},
+		&Task{Title: "e", CreatedAt: *at(4), EndDate: at(5), Kind: HabitEnum},
+	)
+	return start
+}
+
+func TestGetTasksPaginates(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addPaginationTasks(t, db, user.Id)
+
+		tests := []struct {
+			order TaskOrder
+			want  []string
+		}{
+			{OrderCreatedAsc, []string{"a", "b", "c", "d", "e"}},
+			{OrderCreatedDesc, []string{"e", "d", "c", "b", "a"}},
+			// Tasks without a due date come last
+			{OrderDueAsc, []string{"c", "d", "a", "e", "b"}},
+			{OrderPositionAsc, []string{"a", "b", "c", "d", "e"}},
+		}
+		for _, test := range tests {
+			got := []string{}
+			query := TaskQuery{Order: test.order, First: 2}
+			for pages := 0; ; pages++ {
+				if pages > len(test.want) {
+					t.Fatalf("%T: order %d never ran out of pages", db, test.order)
+				}
+				page, err := db.GetTasks(user.Id, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(page.Tasks) > query.First {
+					t.Errorf("%T: order %d returned a page of %d tasks, want at most %d", db, test.order, len(page.Tasks), query.First)
+				}
+				got = append(got, titlesOf(page.Tasks)...)
+				if !page.HasNextPage {
+					break
+				}
+				query.After = page.EndCursor()
+			}
+			if !equalStrings(got, test.want) {
+				t.Errorf("%T: order %d returned %v, want %v", db, test.order, got, test.want)
+			}
+		}
+
+		// Ties on priority are broken the same way on every page
+		page, err := db.GetTasks(user.Id, TaskQuery{Order: OrderPriorityDesc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := titlesOf(page.Tasks)
+		got := []string{}
+		query := TaskQuery{Order: OrderPriorityDesc, First: 1}
+		for {
+			page, err := db.GetTasks(user.Id, query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, titlesOf(page.Tasks)...)
+			if !page.HasNextPage {
+				break
+			}
+			query.After = page.EndCursor()
+		}
+		if !equalStrings(got, want) || want[0] != "a" && want[0] != "c" {
+			t.Errorf("%T: paging by priority returned %v, want %v with a or c first", db, got, want)
+		}
+	}
+}
+
+func TestGetTasksFilters(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := addPaginationTasks(t, db, user.Id)
+		done := true
+		notDone := false
+		habit := HabitEnum
+		dueBefore := start.AddDate(0, 0, 3)
+		createdAfter := start.AddDate(0, 0, 1)
+
+		tests := []struct {
+			query TaskQuery
+			want  []string
+		}{
+			{TaskQuery{Done: &done}, []string{"d"}},
+			{TaskQuery{Done: &notDone}, []string{"a", "b", "c", "e"}},
+			{TaskQuery{Kind: &habit}, []string{"e"}},
+			{TaskQuery{DueBefore: &dueBefore}, []string{"c", "d"}},
+			{TaskQuery{CreatedAfter: &createdAfter, Done: &notDone}, []string{"b", "c", "e"}},
+		}
+		for _, test := range tests {
+			page, err := db.GetTasks(user.Id, test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := titlesOf(page.Tasks); !equalStrings(got, test.want) {
+				t.Errorf("%T: GetTasks(%+v) returned %v, want %v", db, test.query, got, test.want)
+			}
+		}
+	}
+}
+
+func TestGetTasksRejectsBadCursors(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addPaginationTasks(t, db, user.Id)
+		page, err := db.GetTasks(user.Id, TaskQuery{First: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		queries := []TaskQuery{
+			{After: "not a cursor"},
+			{After: page.EndCursor(), Order: OrderDueAsc},
+			{First: -1},
+		}
+		for _, query := range queries {
+			if _, err := db.GetTasks(user.Id, query); err == nil {
+				t.Errorf("%T: GetTasks(%+v) succeeded, want an error", db, query)
+			}
+		}
+	}
+}
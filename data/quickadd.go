@@ -0,0 +1,387 @@
+package data
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How a line of quick-add text was understood, such as "Pay rent every month on the 1st #finance
+// !high" or "Run 3 times a week".
+type QuickAdd struct {
+	Kind  TaskKind `json:"kind"`
+	Title string   `json:"title"`
+	// When a task is due, at midnight unless a time was given
+	EndDate *time.Time `json:"end_date"`
+	// RRULE a task repeats by, or empty if it doesn't repeat
+	Recurrence string   `json:"recurrence"`
+	Priority   Priority `json:"priority"`
+	// How often a habit is done, such as 3 times a Weekly interval
+	Interval  Interval `json:"interval"`
+	Frequency int      `json:"frequency"`
+	Tags      []string `json:"tags"`
+}
+
+// Names of weekdays and months are matched by their first three letters.
+const (
+	weekdayPattern = `(?:monday|mon|tuesday|tues|tue|wednesday|wed|thursday|thurs|thur|thu|friday|fri|saturday|sat|sunday|sun)`
+	monthPattern   = `(?:january|jan|february|feb|march|mar|april|apr|may|june|jun|july|jul|august|aug|september|sept|sep|october|oct|november|nov|december|dec)`
+	ordinalPattern = `(\d{1,2})(?:st|nd|rd|th)?`
+	// Words that may introduce a date, like "on friday" or "by tomorrow"
+	datePrefixPattern = `(?:(?:on|by|due)\s+)?`
+)
+
+var (
+	quickTagPattern        = regexp.MustCompile(`(?:^|\s)#([^\s#]+)`)
+	quickPriorityPattern   = regexp.MustCompile(`(?i)(?:^|\s)!(none|low|medium|high)\b`)
+	quickHabitPattern      = regexp.MustCompile(`(?i)\b(?:(\d+)\s*(?:x|times?)|(once|twice|thrice))\s+(?:a|an|per|each|every)\s+(day|week|month)\b`)
+	quickEveryPattern      = regexp.MustCompile(`(?i)\bevery\s+(?:(other)\s+|(\d+)\s+)?(day|week|month|year)s?(?:\s+on\s+(?:the\s+)?(?:` + ordinalPattern + `|(last)\s+day))?\b`)
+	quickWeekdaysPattern   = regexp.MustCompile(`(?i)\bevery\s+(?:(other)\s+)?(weekday|weekend|` + weekdayPattern + `(?:\s*(?:,|and|&)\s*` + weekdayPattern + `)*)\b`)
+	quickMonthDayPattern   = regexp.MustCompile(`(?i)\bevery\s+` + ordinalPattern + `\b`)
+	quickFrequentPattern   = regexp.MustCompile(`(?i)\b(daily|weekly|monthly|yearly|annually)\b`)
+	quickTimePattern       = regexp.MustCompile(`(?i)\b(?:at\s+)?(?:(\d{1,2})(?::(\d{2}))?\s*(am|pm)|(noon|midnight))\b`)
+	quickClockPattern      = regexp.MustCompile(`(?i)\bat\s+(\d{1,2}):(\d{2})\b`)
+	quickRelativePattern   = regexp.MustCompile(`(?i)\b` + datePrefixPattern + `(today|tonight|tomorrow)\b`)
+	quickInPattern         = regexp.MustCompile(`(?i)\bin\s+(\d+|an?)\s+(day|week|month)s?\b`)
+	quickWeekdayPattern    = regexp.MustCompile(`(?i)\b` + datePrefixPattern + `(?:(next|this)\s+)?(` + weekdayPattern + `)\b`)
+	quickISODatePattern    = regexp.MustCompile(`\b` + datePrefixPattern + `(\d{4})-(\d{2})-(\d{2})\b`)
+	quickMonthFirstPattern = regexp.MustCompile(`(?i)\b` + datePrefixPattern + `(` + monthPattern + `)\s+` + ordinalPattern + `(?:,?\s+(\d{4}))?\b`)
+	quickDayFirstPattern   = regexp.MustCompile(`(?i)\b` + datePrefixPattern + ordinalPattern + `\s+(?:of\s+)?(` + monthPattern + `)(?:,?\s+(\d{4}))?\b`)
+	// Separates the weekdays of a list like "mon, wed and fri"
+	quickListPattern = regexp.MustCompile(`(?i)\s*(?:,|and|&)\s*`)
+)
+
+var quickWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+var quickMonths = map[string]time.Month{
+	"jan": time.January,
+	"feb": time.February,
+	"mar": time.March,
+	"apr": time.April,
+	"may": time.May,
+	"jun": time.June,
+	"jul": time.July,
+	"aug": time.August,
+	"sep": time.September,
+	"oct": time.October,
+	"nov": time.November,
+	"dec": time.December,
+}
+
+var quickIntervals = map[string]Interval{
+	"day":   Daily,
+	"week":  Weekly,
+	"month": Monthly,
+}
+
+var quickFrequencies = map[string]string{
+	"day":      "DAILY",
+	"daily":    "DAILY",
+	"week":     "WEEKLY",
+	"weekly":   "WEEKLY",
+	"month":    "MONTHLY",
+	"monthly":  "MONTHLY",
+	"year":     "YEARLY",
+	"yearly":   "YEARLY",
+	"annually": "YEARLY",
+}
+
+// Removes the first match of pattern from text and returns its submatches, or nil if there is none.
+func takeMatch(text *string, pattern *regexp.Regexp) []string {
+	match := pattern.FindStringSubmatch(*text)
+	if match == nil {
+		return nil
+	}
+	bounds := pattern.FindStringIndex(*text)
+	*text = (*text)[:bounds[0]] + " " + (*text)[bounds[1]:]
+	return match
+}
+
+func quickWeekday(name string) time.Weekday {
+	return quickWeekdays[strings.ToLower(name)[:3]]
+}
+
+// Returns the date of the given day in loc, or nil if there is no such day, like February 30.
+func quickDate(year int, month time.Month, day int, loc *time.Location) *time.Time {
+	if month < time.January || month > time.December || day < 1 || day > daysIn(year, month) {
+		return nil
+	}
+	date := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	return &date
+}
+
+// Parses quick-add text as of now, in the time zone of now. Text that says how many times
+// something is done a day, week or month, like "3 times a week" or "twice a day", is a habit, and
+// anything else is a task. #tags apply to both. Tasks can also have a !priority, a due date like
+// "tomorrow", "friday", "mar 3" or "in 2 weeks" with an optional time like "at 5pm", and a
+// recurrence like "every month on the 1st", "every other monday" or "daily". A recurring task is
+// due on the first day its rule selects. Habits have no dates or priority, so those words stay in
+// their title.
+func ParseQuickAdd(text string, now time.Time) (*QuickAdd, error) {
+	quick := &QuickAdd{
+		Kind: TaskEnum,
+		Tags: []string{},
+	}
+	for match := takeMatch(&text, quickTagPattern); match != nil; match = takeMatch(&text, quickTagPattern) {
+		quick.Tags = append(quick.Tags, match[1])
+	}
+
+	if match := takeMatch(&text, quickHabitPattern); match != nil {
+		quick.Kind = HabitEnum
+		quick.Interval = quickIntervals[strings.ToLower(match[3])]
+		switch strings.ToLower(match[2]) {
+		case "once":
+			quick.Frequency = 1
+		case "twice":
+			quick.Frequency = 2
+		case "thrice":
+			quick.Frequency = 3
+		default:
+			quick.Frequency, _ = strconv.Atoi(match[1])
+		}
+		if quick.Frequency < 1 {
+			return nil, fmt.Errorf("A habit must be done at least once")
+		}
+	} else {
+		if match := takeMatch(&text, quickPriorityPattern); match != nil {
+			quick.Priority, _ = parsePriority(strings.ToLower(match[1]))
+		}
+		rule, err := parseQuickRecurrence(&text)
+		if err != nil {
+			return nil, err
+		}
+		if quick.EndDate, err = parseQuickDue(&text, rule, now); err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			quick.Recurrence = rule.String()
+		}
+	}
+
+	quick.Title = strings.Join(strings.Fields(text), " ")
+	if quick.Title == "" {
+		return nil, fmt.Errorf("Quick add text must have a title")
+	}
+	return quick, nil
+}
+
+// Removes the first recurrence in the text and returns its rule, or nil if there is none.
+func parseQuickRecurrence(text *string) (*RecurrenceRule, error) {
+	parts := []string{}
+	if match := takeMatch(text, quickWeekdaysPattern); match != nil {
+		parts = append(parts, "FREQ=WEEKLY")
+		if match[1] != "" {
+			parts = append(parts, "INTERVAL=2")
+		}
+		switch strings.ToLower(match[2]) {
+		case "weekday":
+			parts = append(parts, "BYDAY=MO,TU,WE,TH,FR")
+		case "weekend":
+			parts = append(parts, "BYDAY=SA,SU")
+		default:
+			codes := []string{}
+			for _, name := range quickListPattern.Split(match[2], -1) {
+				codes = append(codes, weekdayCode(quickWeekday(name)))
+			}
+			parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+		}
+	} else if match := takeMatch(text, quickEveryPattern); match != nil {
+		parts = append(parts, "FREQ="+quickFrequencies[strings.ToLower(match[3])])
+		if match[1] != "" {
+			parts = append(parts, "INTERVAL=2")
+		} else if match[2] != "" {
+			parts = append(parts, "INTERVAL="+match[2])
+		}
+		if match[4] != "" {
+			parts = append(parts, "BYMONTHDAY="+match[4])
+		} else if match[5] != "" {
+			parts = append(parts, "BYMONTHDAY=-1")
+		}
+	} else if match := takeMatch(text, quickMonthDayPattern); match != nil {
+		parts = append(parts, "FREQ=MONTHLY", "BYMONTHDAY="+match[1])
+	} else if match := takeMatch(text, quickFrequentPattern); match != nil {
+		parts = append(parts, "FREQ="+quickFrequencies[strings.ToLower(match[1])])
+	} else {
+		return nil, nil
+	}
+	return ParseRecurrenceRule(strings.Join(parts, ";"))
+}
+
+// Removes the first due date and time in the text and returns when the task is due, or nil if
+// neither is given and it doesn't recur. Recurring tasks are due on the first day from the due
+// date, or from today, that their rule selects.
+func parseQuickDue(text *string, rule *RecurrenceRule, now time.Time) (*time.Time, error) {
+	hour, minute, hasTime := 0, 0, false
+	if match := takeMatch(text, quickClockPattern); match != nil {
+		hour, _ = strconv.Atoi(match[1])
+		minute, _ = strconv.Atoi(match[2])
+		hasTime = true
+	} else if match := takeMatch(text, quickTimePattern); match != nil {
+		switch strings.ToLower(match[4]) {
+		case "noon":
+			hour = 12
+		case "midnight":
+			hour = 0
+		default:
+			hour, _ = strconv.Atoi(match[1])
+			minute, _ = strconv.Atoi(match[2])
+			if hour < 1 || hour > 12 {
+				return nil, fmt.Errorf("Invalid time \"%s\"", strings.TrimSpace(match[0]))
+			}
+			hour %= 12
+			if strings.ToLower(match[3]) == "pm" {
+				hour += 12
+			}
+		}
+		hasTime = true
+	}
+	if hour > 23 || minute > 59 {
+		return nil, fmt.Errorf("Invalid time %d:%02d", hour, minute)
+	}
+
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	date, err := parseQuickDate(text, today)
+	if err != nil {
+		return nil, err
+	}
+	if date == nil && !hasTime && rule == nil {
+		return nil, nil
+	}
+
+	due := today
+	if date != nil {
+		due = *date
+	}
+	due = time.Date(due.Year(), due.Month(), due.Day(), hour, minute, 0, 0, loc)
+	// A time without a date is the next time it comes around
+	if date == nil && hasTime && due.Before(now) {
+		due = due.AddDate(0, 0, 1)
+	}
+	if rule != nil {
+		return rule.firstOnOrAfter(due), nil
+	}
+	return &due, nil
+}
+
+// Removes the first date in the text and returns it, or nil if there is none. Dates without a year
+// are the next time they come around.
+func parseQuickDate(text *string, today time.Time) (*time.Time, error) {
+	if match := takeMatch(text, quickRelativePattern); match != nil {
+		if strings.ToLower(match[1]) == "tomorrow" {
+			tomorrow := today.AddDate(0, 0, 1)
+			return &tomorrow, nil
+		}
+		return &today, nil
+	}
+	if match := takeMatch(text, quickInPattern); match != nil {
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			// "a" or "an"
+			n = 1
+		}
+		var date time.Time
+		switch strings.ToLower(match[2]) {
+		case "day":
+			date = today.AddDate(0, 0, n)
+		case "week":
+			date = today.AddDate(0, 0, 7*n)
+		case "month":
+			date = today.AddDate(0, n, 0)
+		}
+		return &date, nil
+	}
+
+	var date *time.Time
+	var match []string
+	if match = takeMatch(text, quickISODatePattern); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		month, _ := strconv.Atoi(match[2])
+		day, _ := strconv.Atoi(match[3])
+		date = quickDate(year, time.Month(month), day, today.Location())
+	} else if match = takeMatch(text, quickMonthFirstPattern); match != nil {
+		day, _ := strconv.Atoi(match[2])
+		date = quickDayOfMonth(quickMonths[strings.ToLower(match[1])[:3]], day, match[3], today)
+	} else if match = takeMatch(text, quickDayFirstPattern); match != nil {
+		day, _ := strconv.Atoi(match[1])
+		date = quickDayOfMonth(quickMonths[strings.ToLower(match[2])[:3]], day, match[3], today)
+	}
+	if match != nil {
+		if date == nil {
+			return nil, fmt.Errorf("Invalid date \"%s\"", strings.TrimSpace(match[0]))
+		}
+		return date, nil
+	}
+
+	if match := takeMatch(text, quickWeekdayPattern); match != nil {
+		days := (int(quickWeekday(match[2])) - int(today.Weekday()) + 7) % 7
+		if days == 0 && strings.ToLower(match[1]) == "next" {
+			days = 7
+		}
+		date := today.AddDate(0, 0, days)
+		return &date, nil
+	}
+	return nil, nil
+}
+
+// Returns the day of the month in the given year, or in the next year it isn't past if year is
+// empty.
+func quickDayOfMonth(month time.Month, day int, year string, today time.Time) *time.Time {
+	if year != "" {
+		y, _ := strconv.Atoi(year)
+		return quickDate(y, month, day, today.Location())
+	}
+	date := quickDate(today.Year(), month, day, today.Location())
+	if date != nil && date.Before(today) {
+		date = quickDate(today.Year()+1, month, day, today.Location())
+	}
+	return date
+}
+
+// Builds the task or habit that the quick add describes.
+func (quick *QuickAdd) task() *Task {
+	return &Task{
+		Kind:       quick.Kind,
+		Title:      quick.Title,
+		EndDate:    quick.EndDate,
+		Recurrence: quick.Recurrence,
+		Priority:   quick.Priority,
+		Interval:   quick.Interval,
+		Frequency:  quick.Frequency,
+	}
+}
+
+// Parses quick-add text as of now and adds the task or habit it describes, along with its tags.
+// Returns the added task and how the text was understood.
+func QuickAddTask(db Database, userId uint64, text string, now time.Time) (*Task, *QuickAdd, error) {
+	quick, err := ParseQuickAdd(text, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	task := quick.task()
+	err = db.WithTx(func(tx Database) error {
+		if err := tx.AddTask(task, userId); err != nil {
+			return err
+		}
+		for _, name := range quick.Tags {
+			if _, err := tx.AddTag(task.Id, name, userId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return task, quick, nil
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuickAdd(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+	quick, err := ParseQuickAdd("Pay rent every month on the 1st #finance !high", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quick.Kind != TaskEnum || quick.Title != "Pay rent" || quick.Priority != PriorityHigh {
+		t.Errorf("Got %+v", quick)
+	}
+	if quick.Recurrence != "FREQ=MONTHLY;BYMONTHDAY=1" {
+		t.Errorf("Got recurrence %q", quick.Recurrence)
+	}
+	if !equalStrings(quick.Tags, []string{"finance"}) {
+		t.Errorf("Got tags %v", quick.Tags)
+	}
+	if quick.EndDate == nil || !quick.EndDate.Equal(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Got end date %v", quick.EndDate)
+	}
+
+	quick, err = ParseQuickAdd("Run 3 times a week", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quick.Kind != HabitEnum || quick.Title != "Run" || quick.Interval != Weekly || quick.Frequency != 3 {
+		t.Errorf("Got %+v", quick)
+	}
+	if quick.EndDate != nil || quick.Recurrence != "" || len(quick.Tags) != 0 {
+		t.Errorf("Got %+v", quick)
+	}
+}
+
+func TestParseQuickAddTasks(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		text       string
+		title      string
+		endDate    string
+		recurrence string
+	}{
+		{"Just a task", "Just a task", "", ""},
+		{"Call mom tomorrow at 5pm", "Call mom", "2026-10-15T17:00:00Z", ""},
+		{"Dentist on friday at 9:30am", "Dentist", "2026-10-16T09:30:00Z", ""},
+		{"Taxes due apr 30", "Taxes", "2027-04-30T00:00:00Z", ""},
+		{"Check in 2 weeks", "Check", "2026-10-28T00:00:00Z", ""},
+		{"Standup every weekday at 9am", "Standup", "2026-10-15T09:00:00Z", "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"},
+		{"Review every other week", "Review", "2026-10-14T00:00:00Z", "FREQ=WEEKLY;INTERVAL=2"},
+	}
+	for _, test := range tests {
+		quick, err := ParseQuickAdd(test.text, now)
+		if err != nil {
+			t.Errorf("ParseQuickAdd(%q) failed: %s", test.text, err)
+			continue
+		}
+		endDate := ""
+		if quick.EndDate != nil {
+			endDate = quick.EndDate.Format(time.RFC3339)
+		}
+		if quick.Kind != TaskEnum || quick.Title != test.title || endDate != test.endDate || quick.Recurrence != test.recurrence {
+			t.Errorf("ParseQuickAdd(%q) = %+v with end date %q", test.text, quick, endDate)
+		}
+	}
+}
+
+func TestParseQuickAddErrors(t *testing.T) {
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	for _, text := range []string{"", "#onlytag", "tomorrow !high", "Run 0 times a day", "Feb 30 thing", "Thing at 13pm"} {
+		if _, err := ParseQuickAdd(text, now); err == nil {
+			t.Errorf("ParseQuickAdd(%q) succeeded, want an error", text)
+		}
+	}
+}
@@ -0,0 +1,713 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type frequency int
+
+const (
+	freqDaily frequency = iota
+	freqWeekly
+	freqMonthly
+	freqYearly
+)
+
+var frequencyNames = map[string]frequency{
+	"DAILY":   freqDaily,
+	"WEEKLY":  freqWeekly,
+	"MONTHLY": freqMonthly,
+	"YEARLY":  freqYearly,
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// A day of the week in a BYDAY rule, like "TU" for every Tuesday, "2TU" for the second Tuesday or
+// "-1FR" for the last Friday.
+type weekdayRule struct {
+	weekday time.Weekday
+	// The occurrence of the weekday within the month or year, counting from the end if negative, or
+	// zero for every occurrence
+	n int
+}
+
+// A recurrence rule in the RRULE format of RFC 5545, such as "FREQ=MONTHLY;BYDAY=2TU" for every
+// second Tuesday or "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1" for the last weekday of every
+// month. The FREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH, BYSETPOS and WKST parts are
+// supported, with frequencies from DAILY to YEARLY.
+type RecurrenceRule struct {
+	freq     frequency
+	interval int
+	// Number of occurrences including the first, or zero for no limit
+	count int
+	until *time.Time
+	// Whether until was given as a date, which includes the whole day
+	untilDate  bool
+	byDay      []weekdayRule
+	byMonthDay []int
+	byMonth    []time.Month
+	bySetPos   []int
+	weekStart  time.Weekday
+}
+
+// Occurrences stop being looked for once this many years pass without one, which only happens for
+// rules that can never match, like the 30th of February.
+const maxRecurrenceGapYears = 10
+
+// Rules with a COUNT are followed from their first occurrence to count them, so COUNT is limited
+// to keep that cheap.
+const maxRecurrenceCount = 10000
+
+// Parses a list of integers between min and max, excluding zero.
+func parseRuleInts(key string, value string, min int, max int) ([]int, error) {
+	ints := []int{}
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n == 0 || n < min || n > max {
+			return nil, fmt.Errorf("Invalid %s \"%s\" in recurrence rule", key, part)
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+func parseRuleUntil(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("Invalid UNTIL \"%s\" in recurrence rule", value)
+}
+
+// Parses an RRULE like "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU", with or without the "RRULE:" prefix.
+func ParseRecurrenceRule(text string) (*RecurrenceRule, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	text = strings.TrimPrefix(text, "RRULE:")
+	rule := &RecurrenceRule{
+		freq:      -1,
+		interval:  1,
+		weekStart: time.Monday,
+	}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(text, ";") {
+		pair := strings.SplitN(part, "=", 2)
+		if len(pair) != 2 || pair[1] == "" {
+			return nil, fmt.Errorf("Invalid part \"%s\" in recurrence rule", part)
+		}
+		key, value := pair[0], pair[1]
+		if seen[key] {
+			return nil, fmt.Errorf("%s is repeated in recurrence rule", key)
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			freq, ok := frequencyNames[value]
+			if !ok {
+				return nil, fmt.Errorf("Unsupported FREQ \"%s\" in recurrence rule", value)
+			}
+			rule.freq = freq
+		case "INTERVAL":
+			rule.interval, err = strconv.Atoi(value)
+			if err != nil || rule.interval < 1 {
+				return nil, fmt.Errorf("Invalid INTERVAL \"%s\" in recurrence rule", value)
+			}
+		case "COUNT":
+			rule.count, err = strconv.Atoi(value)
+			if err != nil || rule.count < 1 || rule.count > maxRecurrenceCount {
+				return nil, fmt.Errorf("Invalid COUNT \"%s\" in recurrence rule", value)
+			}
+		case "UNTIL":
+			until, date, err := parseRuleUntil(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.until, rule.untilDate = &until, date
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				if len(day) < 2 {
+					return nil, fmt.Errorf("Invalid BYDAY \"%s\" in recurrence rule", day)
+				}
+				weekday, ok := weekdayNames[day[len(day)-2:]]
+				if !ok {
+					return nil, fmt.Errorf("Invalid BYDAY \"%s\" in recurrence rule", day)
+				}
+				n := 0
+				if ordinal := day[:len(day)-2]; ordinal != "" {
+					n, err = strconv.Atoi(ordinal)
+					if err != nil || n == 0 || n < -53 || n > 53 {
+						return nil, fmt.Errorf("Invalid BYDAY \"%s\" in recurrence rule", day)
+					}
+				}
+				rule.byDay = append(rule.byDay, weekdayRule{weekday, n})
+			}
+		case "BYMONTHDAY":
+			rule.byMonthDay, err = parseRuleInts(key, value, -31, 31)
+		case "BYMONTH":
+			months, err := parseRuleInts(key, value, 1, 12)
+			if err != nil {
+				return nil, err
+			}
+			for _, month := range months {
+				rule.byMonth = append(rule.byMonth, time.Month(month))
+			}
+		case "BYSETPOS":
+			rule.bySetPos, err = parseRuleInts(key, value, -366, 366)
+		case "WKST":
+			weekStart, ok := weekdayNames[value]
+			if !ok {
+				return nil, fmt.Errorf("Invalid WKST \"%s\" in recurrence rule", value)
+			}
+			rule.weekStart = weekStart
+		default:
+			return nil, fmt.Errorf("%s is not supported in recurrence rules", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if rule.freq < 0 {
+		return nil, fmt.Errorf("Recurrence rule must have a FREQ")
+	}
+	if rule.count > 0 && rule.until != nil {
+		return nil, fmt.Errorf("Recurrence rule can't have both COUNT and UNTIL")
+	}
+	if len(rule.bySetPos) > 0 && len(rule.byDay) == 0 && len(rule.byMonthDay) == 0 && len(rule.byMonth) == 0 {
+		return nil, fmt.Errorf("BYSETPOS must be used with another BY part in recurrence rules")
+	}
+	for _, day := range rule.byDay {
+		if day.n != 0 && rule.freq != freqMonthly && rule.freq != freqYearly {
+			return nil, fmt.Errorf("Numbered BYDAY is only allowed with MONTHLY or YEARLY recurrence")
+		}
+	}
+	if len(rule.byMonthDay) > 0 && rule.freq == freqWeekly {
+		return nil, fmt.Errorf("BYMONTHDAY is not allowed with WEEKLY recurrence")
+	}
+	return rule, nil
+}
+
+// Checks that text is empty or a recurrence rule that can be parsed.
+func validateRecurrence(text string) error {
+	if text == "" {
+		return nil
+	}
+	_, err := ParseRecurrenceRule(text)
+	return err
+}
+
+func validateRecurrenceAttr(attrs map[string]interface{}) error {
+	if text, ok := attrs["recurrence"].(string); ok {
+		return validateRecurrence(text)
+	}
+	return nil
+}
+
+// Formats the rule in the RRULE format, without the "RRULE:" prefix.
+func (rule *RecurrenceRule) String() string {
+	var out bytes.Buffer
+	for name, freq := range frequencyNames {
+		if freq == rule.freq {
+			out.WriteString("FREQ=" + name)
+		}
+	}
+	if rule.interval > 1 {
+		fmt.Fprintf(&out, ";INTERVAL=%d", rule.interval)
+	}
+	if rule.count > 0 {
+		fmt.Fprintf(&out, ";COUNT=%d", rule.count)
+	}
+	if rule.until != nil && rule.untilDate {
+		out.WriteString(";UNTIL=" + rule.until.Format("20060102"))
+	} else if rule.until != nil {
+		out.WriteString(";UNTIL=" + rule.until.Format("20060102T150405Z"))
+	}
+	joinInts := func(key string, ints []int) {
+		if len(ints) == 0 {
+			return
+		}
+		parts := make([]string, len(ints))
+		for i, n := range ints {
+			parts[i] = strconv.Itoa(n)
+		}
+		out.WriteString(";" + key + "=" + strings.Join(parts, ","))
+	}
+	if len(rule.byDay) > 0 {
+		parts := make([]string, len(rule.byDay))
+		for i, day := range rule.byDay {
+			parts[i] = weekdayCode(day.weekday)
+			if day.n != 0 {
+				parts[i] = strconv.Itoa(day.n) + parts[i]
+			}
+		}
+		out.WriteString(";BYDAY=" + strings.Join(parts, ","))
+	}
+	joinInts("BYMONTHDAY", rule.byMonthDay)
+	months := make([]int, len(rule.byMonth))
+	for i, month := range rule.byMonth {
+		months[i] = int(month)
+	}
+	joinInts("BYMONTH", months)
+	joinInts("BYSETPOS", rule.bySetPos)
+	if rule.weekStart != time.Monday {
+		out.WriteString(";WKST=" + weekdayCode(rule.weekStart))
+	}
+	return out.String()
+}
+
+func weekdayCode(weekday time.Weekday) string {
+	for code, day := range weekdayNames {
+		if day == weekday {
+			return code
+		}
+	}
+	return ""
+}
+
+// Days are handled as midnight UTC, so adding days is never thrown off by daylight saving time.
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// Returns the first day of the k-th period of the rule, such as the k-th week of a weekly rule,
+// counting from the period that contains start, along with the first day of the period after it.
+func (rule *RecurrenceRule) period(start time.Time, k int) (time.Time, time.Time) {
+	step := k * rule.interval
+	switch rule.freq {
+	case freqDaily:
+		first := start.AddDate(0, 0, step)
+		return first, first.AddDate(0, 0, 1)
+	case freqWeekly:
+		weekStart := start.AddDate(0, 0, -((int(start.Weekday()) - int(rule.weekStart) + 7) % 7))
+		first := weekStart.AddDate(0, 0, 7*step)
+		return first, first.AddDate(0, 0, 7)
+	case freqMonthly:
+		first := time.Date(start.Year(), start.Month()+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+		return first, first.AddDate(0, 1, 0)
+	default:
+		first := time.Date(start.Year()+step, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return first, first.AddDate(1, 0, 0)
+	}
+}
+
+// Returns the number of the period of the rule that contains t, counting from the period that
+// contains start, see period.
+func (rule *RecurrenceRule) periodOf(start time.Time, t time.Time) int {
+	// Durations can't span more than about 290 years, so days are counted from Unix times
+	days := func(from time.Time, to time.Time) int {
+		return int((dateOf(to).Unix() - dateOf(from).Unix()) / (24 * 60 * 60))
+	}
+	var units int
+	switch rule.freq {
+	case freqDaily:
+		units = days(start, t)
+	case freqWeekly:
+		weekStart, _ := rule.period(dateOf(start), 0)
+		units = days(weekStart, t) / 7
+	case freqMonthly:
+		units = (t.Year()-start.Year())*12 + int(t.Month()) - int(start.Month())
+	default:
+		units = t.Year() - start.Year()
+	}
+	return units / rule.interval
+}
+
+// Returns the days of the k-th period of the rule, see period.
+func (rule *RecurrenceRule) periodDays(start time.Time, k int) []time.Time {
+	days := []time.Time{}
+	first, next := rule.period(start, k)
+	for day := first; day.Before(next); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+func containsInt(ints []int, n int) bool {
+	for _, i := range ints {
+		if i == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the day matches one of the rule's BYDAY parts. Numbered weekdays count within
+// the month, or within the year for yearly rules without BYMONTH.
+func (rule *RecurrenceRule) matchesWeekday(day time.Time) bool {
+	for _, byDay := range rule.byDay {
+		if day.Weekday() != byDay.weekday {
+			continue
+		}
+		if byDay.n == 0 {
+			return true
+		}
+		index, length := day.Day(), daysIn(day.Year(), day.Month())
+		if rule.freq == freqYearly && len(rule.byMonth) == 0 {
+			index, length = day.YearDay(), time.Date(day.Year(), time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+		}
+		if byDay.n > 0 && (index-1)/7+1 == byDay.n || byDay.n < 0 && (length-index)/7+1 == -byDay.n {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the days of a period that the rule selects, in order.
+func (rule *RecurrenceRule) selectDays(days []time.Time, start time.Time) []time.Time {
+	selected := []time.Time{}
+	for _, day := range days {
+		if len(rule.byMonth) > 0 && !containsMonth(rule.byMonth, day.Month()) {
+			continue
+		}
+		if len(rule.byMonthDay) > 0 {
+			fromEnd := day.Day() - daysIn(day.Year(), day.Month()) - 1
+			if !containsInt(rule.byMonthDay, day.Day()) && !containsInt(rule.byMonthDay, fromEnd) {
+				continue
+			}
+		}
+		if len(rule.byDay) > 0 && !rule.matchesWeekday(day) {
+			continue
+		}
+		// Without BYDAY or BYMONTHDAY, the rule repeats the day of the start
+		if len(rule.byDay) == 0 && len(rule.byMonthDay) == 0 {
+			switch rule.freq {
+			case freqWeekly:
+				if day.Weekday() != start.Weekday() {
+					continue
+				}
+			case freqMonthly:
+				if day.Day() != start.Day() {
+					continue
+				}
+			case freqYearly:
+				if day.Day() != start.Day() || len(rule.byMonth) == 0 && day.Month() != start.Month() {
+					continue
+				}
+			}
+		}
+		selected = append(selected, day)
+	}
+
+	if len(rule.bySetPos) == 0 {
+		return selected
+	}
+	positioned := []time.Time{}
+	for i, day := range selected {
+		if containsInt(rule.bySetPos, i+1) || containsInt(rule.bySetPos, i-len(selected)) {
+			positioned = append(positioned, day)
+		}
+	}
+	return positioned
+}
+
+func containsMonth(months []time.Month, month time.Month) bool {
+	for _, m := range months {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
+
+// Calls fn with each occurrence of the rule starting at start, in order, until it returns false or
+// the occurrences run out. The start is always the first occurrence, even if the rule wouldn't
+// select it, and occurrences keep its time of day.
+func (rule *RecurrenceRule) each(start time.Time, fn func(time.Time) bool) {
+	rule.eachFrom(start, start, fn)
+}
+
+// Like each, but skips ahead to the period before the one that contains from, so fn may not be
+// called with occurrences before from. Rules with a COUNT are followed from the start regardless,
+// since every occurrence has to be counted.
+func (rule *RecurrenceRule) eachFrom(start time.Time, from time.Time, fn func(time.Time) bool) {
+	until := rule.until
+	if until != nil && rule.untilDate {
+		// A date includes the whole of that day where the series takes place
+		end := time.Date(until.Year(), until.Month(), until.Day()+1, 0, 0, 0, 0, start.Location()).Add(-time.Nanosecond)
+		until = &end
+	}
+	if until != nil && start.After(*until) || !fn(start) {
+		return
+	}
+	count := 1
+
+	startDate := dateOf(start)
+	last := startDate
+	skip := 0
+	if rule.count == 0 && from.After(start) {
+		// The period before from's is included in case from's time zone puts it on another day
+		if skip = rule.periodOf(startDate, from) - 1; skip > 0 {
+			last, _ = rule.period(startDate, skip)
+		} else {
+			skip = 0
+		}
+	}
+	for k := skip; ; k++ {
+		for _, day := range rule.selectDays(rule.periodDays(startDate, k), start) {
+			occurrence := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+			if !occurrence.After(start) {
+				continue
+			}
+			if rule.count > 0 && count >= rule.count || until != nil && occurrence.After(*until) {
+				return
+			}
+			if !fn(occurrence) {
+				return
+			}
+			count++
+			last = day
+		}
+		if _, next := rule.period(startDate, k); next.After(last.AddDate(maxRecurrenceGapYears, 0, 0)) {
+			return
+		}
+	}
+}
+
+// Returns the first occurrence of the rule starting at start that comes after the given time, or
+// nil if there is none.
+func (rule *RecurrenceRule) after(start time.Time, after time.Time) *time.Time {
+	var next *time.Time
+	rule.each(start, func(occurrence time.Time) bool {
+		if occurrence.After(after) {
+			next = &occurrence
+			return false
+		}
+		return true
+	})
+	return next
+}
+
+// Returns the first time on or after t, at the time of day of t, that the rule would select if the
+// series started then, or nil if it never would. Rules that only repeat the day they start on
+// select t itself. Used to start a series on a day that fits its rule, since the start of a series
+// is always its first occurrence.
+func (rule *RecurrenceRule) firstOnOrAfter(t time.Time) *time.Time {
+	if len(rule.byDay) == 0 && len(rule.byMonthDay) == 0 {
+		return &t
+	}
+	// Every period is considered from the day before, so the interval doesn't skip the first one
+	unbounded := *rule
+	unbounded.interval = 1
+	unbounded.count = 0
+	unbounded.until = nil
+	dayBefore := t.AddDate(0, 0, -1)
+	return unbounded.after(dayBefore, dayBefore)
+}
+
+// Returns the date a recurring task's occurrences are reckoned from: its start date, or its end
+// date if it has no start date. Returns nil if it has neither.
+func recurrenceAnchor(task *Task) *time.Time {
+	if task.StartDate != nil {
+		return task.StartDate
+	}
+	return task.EndDate
+}
+
+func shiftTime(t *time.Time, by time.Duration) *time.Time {
+	if t == nil {
+		return nil
+	}
+	shifted := t.Add(by)
+	return &shifted
+}
+
+// Creates the next occurrence of a recurring task that an update completed, with its start and
+// end dates moved to the next date of the rule. Tasks without dates recur from when they are
+// completed, and their next occurrence starts on the next date. The new task has the same tags and
+// reminders before its end date, and its rule counts one fewer occurrence if it has a COUNT.
+func recurAfterUpdate(db Database, before *Task, after *Task, userId uint64) error {
+	if before.Done || !after.Done || after.Kind != TaskEnum || after.Recurrence == "" || after.NextId != nil {
+		return nil
+	}
+	rule, err := ParseRecurrenceRule(after.Recurrence)
+	if err != nil {
+		return err
+	}
+	anchor := recurrenceAnchor(after)
+	if anchor == nil {
+		now := time.Now()
+		anchor = &now
+	}
+	next := rule.after(*anchor, *anchor)
+	if next == nil {
+		return nil
+	}
+
+	shift := next.Sub(*anchor)
+	if rule.count > 0 {
+		rule.count--
+	}
+	occurrence := &Task{
+		Kind:         TaskEnum,
+		Title:        after.Title,
+		Notes:        after.Notes,
+		ProjectId:    after.ProjectId,
+		ParentId:     after.ParentId,
+		AutoComplete: after.AutoComplete,
+		Priority:     after.Priority,
+		Recurrence:   rule.String(),
+		StartDate:    shiftTime(after.StartDate, shift),
+		EndDate:      shiftTime(after.EndDate, shift),
+	}
+	if recurrenceAnchor(after) == nil {
+		occurrence.StartDate = next
+	}
+	if err := db.AddTask(occurrence, userId); err != nil {
+		return err
+	}
+
+	tags, err := db.GetTaskTags([]string{after.Id}, userId)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags[after.Id] {
+		if _, err := db.AddTag(occurrence.Id, tag.Name, userId); err != nil {
+			return err
+		}
+	}
+	reminders, err := db.GetReminders([]string{after.Id}, userId)
+	if err != nil {
+		return err
+	}
+	for _, reminder := range reminders[after.Id] {
+		if reminder.OffsetMinutes == nil {
+			continue
+		}
+		err := db.AddReminder(&Reminder{TaskId: occurrence.Id, OffsetMinutes: reminder.OffsetMinutes}, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.UpdateTask(after.Id, userId, map[string]interface{}{"next_id": &occurrence.Id}, nil)
+	return err
+}
+
+// Completes a recurring task that a done action was added to, which creates its next occurrence.
+func completeAfterAction(db Database, action *Action, userId uint64) error {
+	if action.Kind != ActionDone {
+		return nil
+	}
+	task, err := db.GetTask(action.TaskId, userId, nil)
+	if err != nil {
+		return err
+	}
+	if task.Kind != TaskEnum || task.Recurrence == "" || task.Done {
+		return nil
+	}
+	_, err = db.UpdateTask(task.Id, userId, map[string]interface{}{"done": true}, nil)
+	return err
+}
+
+// Returns the occurrence that a change to a recurring task created, given the task before and
+// after the change, or nil if it created none.
+func generatedOccurrence(db Database, before *Task, after *Task, userId uint64) (*Task, error) {
+	if before == nil || after == nil || before.NextId != nil || after.NextId == nil {
+		return nil, nil
+	}
+	return db.GetTask(*after.NextId, userId, nil)
+}
+
+// Maximum number of occurrences returned by ExpandOccurrences
+const maxOccurrences = 1000
+
+// Maximum number of days between the dates ExpandOccurrences looks between
+const maxOccurrenceDays = 366
+
+// An occurrence of a recurring task, with the dates it would have.
+type Occurrence struct {
+	Task      *Task      `json:"task"`
+	StartDate *time.Time `json:"start_date"`
+	EndDate   *time.Time `json:"end_date"`
+}
+
+type byOccurrenceDate []Occurrence
+
+func (s byOccurrenceDate) Len() int      { return len(s) }
+func (s byOccurrenceDate) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byOccurrenceDate) Less(i, j int) bool {
+	a, b := s[i].date(), s[j].date()
+	if !a.Equal(b) {
+		return a.Before(b)
+	}
+	return s[i].Task.Id < s[j].Task.Id
+}
+
+// Returns the start date of the occurrence, or its end date if it has no start date.
+func (occurrence *Occurrence) date() time.Time {
+	if occurrence.StartDate != nil {
+		return *occurrence.StartDate
+	}
+	return *occurrence.EndDate
+}
+
+// Returns up to limit occurrences of the user's recurring tasks that aren't done, whose start date,
+// or end date if they have no start date, is within [from, to). The current occurrence of each task
+// is included. Tasks without dates have no schedule and are left out.
+func ExpandOccurrences(db Database, userId uint64, from time.Time, to time.Time, limit int) ([]Occurrence, error) {
+	if limit < 1 || limit > maxOccurrences {
+		return nil, fmt.Errorf("Limit must be between 1 and %d", maxOccurrences)
+	}
+	if to.Sub(from) > maxOccurrenceDays*24*time.Hour {
+		return nil, fmt.Errorf("Occurrences can only be listed for up to %d days at a time", maxOccurrenceDays)
+	}
+	kind, done, recurring := TaskEnum, false, true
+	page, err := db.GetTasks(userId, TaskQuery{Kind: &kind, Done: &done, Recurring: &recurring})
+	if err != nil {
+		return nil, err
+	}
+
+	occurrences := []Occurrence{}
+	for i := range page.Tasks {
+		task := &page.Tasks[i]
+		anchor := recurrenceAnchor(task)
+		if anchor == nil {
+			continue
+		}
+		rule, err := ParseRecurrenceRule(task.Recurrence)
+		if err != nil {
+			return nil, err
+		}
+		found := 0
+		rule.eachFrom(*anchor, from, func(occurrence time.Time) bool {
+			if !occurrence.Before(to) || found == limit {
+				return false
+			}
+			if !occurrence.Before(from) {
+				shift := occurrence.Sub(*anchor)
+				occurrences = append(occurrences, Occurrence{
+					Task:      task,
+					StartDate: shiftTime(task.StartDate, shift),
+					EndDate:   shiftTime(task.EndDate, shift),
+				})
+				found++
+			}
+			return true
+		})
+	}
+	sort.Sort(byOccurrenceDate(occurrences))
+	if len(occurrences) > limit {
+		occurrences = occurrences[:limit]
+	}
+	return occurrences, nil
+}
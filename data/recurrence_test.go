@@ -0,0 +1,199 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecurrenceRuleErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;FREQ=WEEKLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=DAILY;COUNT=2;UNTIL=20270101",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=YEARLY;BYMONTH=13",
+		"FREQ=MONTHLY;BYDAY=MO;BYSETPOS=0",
+		"FREQ=DAILY;BYWEEKNO=1",
+		"FREQ=DAILY;INTERVAL",
+	} {
+		if _, err := ParseRecurrenceRule(text); err == nil {
+			t.Errorf("ParseRecurrenceRule(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestRecurrenceRuleEach(t *testing.T) {
+	tests := []struct {
+		rule  string
+		start string
+		want  []string
+	}{
+		{"FREQ=DAILY", "2026-01-30T09:00:00Z", []string{
+			"2026-01-30T09:00:00Z", "2026-01-31T09:00:00Z", "2026-02-01T09:00:00Z", "2026-02-02T09:00:00Z",
+		}},
+		{"RRULE:freq=weekly;interval=2", "2026-01-05T09:00:00Z", []string{
+			"2026-01-05T09:00:00Z", "2026-01-19T09:00:00Z", "2026-02-02T09:00:00Z", "2026-02-16T09:00:00Z",
+		}},
+		{"FREQ=WEEKLY;BYDAY=TU,TH", "2026-01-06T09:00:00Z", []string{
+			"2026-01-06T09:00:00Z", "2026-01-08T09:00:00Z", "2026-01-13T09:00:00Z", "2026-01-15T09:00:00Z",
+		}},
+		{"FREQ=MONTHLY;BYDAY=2TU", "2026-01-13T18:30:00Z", []string{
+			"2026-01-13T18:30:00Z", "2026-02-10T18:30:00Z", "2026-03-10T18:30:00Z", "2026-04-14T18:30:00Z",
+		}},
+		{"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", "2026-01-30T09:00:00Z", []string{
+			"2026-01-30T09:00:00Z", "2026-02-27T09:00:00Z", "2026-03-31T09:00:00Z", "2026-04-30T09:00:00Z",
+		}},
+		{"FREQ=MONTHLY;BYMONTHDAY=31", "2026-01-31T09:00:00Z", []string{
+			"2026-01-31T09:00:00Z", "2026-03-31T09:00:00Z", "2026-05-31T09:00:00Z", "2026-07-31T09:00:00Z",
+		}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", "2026-01-31T09:00:00Z", []string{
+			"2026-01-31T09:00:00Z", "2026-02-28T09:00:00Z", "2026-03-31T09:00:00Z", "2026-04-30T09:00:00Z",
+		}},
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=29", "2024-02-29T09:00:00Z", []string{
+			"2024-02-29T09:00:00Z", "2028-02-29T09:00:00Z", "2032-02-29T09:00:00Z", "2036-02-29T09:00:00Z",
+		}},
+		{"FREQ=DAILY;COUNT=3", "2026-01-01T09:00:00Z", []string{
+			"2026-01-01T09:00:00Z", "2026-01-02T09:00:00Z", "2026-01-03T09:00:00Z",
+		}},
+		{"FREQ=DAILY;UNTIL=20260102", "2026-01-01T09:00:00Z", []string{
+			"2026-01-01T09:00:00Z", "2026-01-02T09:00:00Z",
+		}},
+		{"FREQ=DAILY;UNTIL=20260102T000000Z", "2026-01-01T09:00:00Z", []string{
+			"2026-01-01T09:00:00Z",
+		}},
+		// The start is always the first occurrence, even on a day the rule doesn't select
+		{"FREQ=WEEKLY;BYDAY=MO", "2026-01-07T09:00:00Z", []string{
+			"2026-01-07T09:00:00Z", "2026-01-12T09:00:00Z", "2026-01-19T09:00:00Z", "2026-01-26T09:00:00Z",
+		}},
+		// The 30th of February never comes
+		{"FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", "2026-01-01T09:00:00Z", []string{
+			"2026-01-01T09:00:00Z",
+		}},
+	}
+	for _, test := range tests {
+		rule, err := ParseRecurrenceRule(test.rule)
+		if err != nil {
+			t.Errorf("ParseRecurrenceRule(%q) failed: %s", test.rule, err)
+			continue
+		}
+		start, err := time.Parse(time.RFC3339, test.start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Rules that don't run out are only followed for four occurrences
+		got := []string{}
+		rule.each(start, func(occurrence time.Time) bool {
+			got = append(got, occurrence.Format(time.RFC3339))
+			return len(got) < 4
+		})
+		if !equalStrings(got, test.want) {
+			t.Errorf("%q from %s gave %v, want %v", test.rule, test.start, got, test.want)
+		}
+	}
+}
+
+func TestRecurrenceRuleString(t *testing.T) {
+	for _, text := range []string{
+		"FREQ=DAILY",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=TU",
+		"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+		"FREQ=YEARLY;COUNT=5;BYMONTH=3;BYMONTHDAY=1",
+	} {
+		rule, err := ParseRecurrenceRule(text)
+		if err != nil {
+			t.Errorf("ParseRecurrenceRule(%q) failed: %s", text, err)
+			continue
+		}
+		again, err := ParseRecurrenceRule(rule.String())
+		if err != nil || again.String() != rule.String() {
+			t.Errorf("%q became %q, which doesn't parse back the same", text, rule.String())
+		}
+	}
+}
+
+// Lists the occurrences within [from, to), found with eachFrom if skip is set or each otherwise.
+func occurrencesBetween(rule *RecurrenceRule, start time.Time, from time.Time, to time.Time, skip bool) []string {
+	occurrences := []string{}
+	fn := func(occurrence time.Time) bool {
+		if !occurrence.Before(to) {
+			return false
+		}
+		if !occurrence.Before(from) {
+			occurrences = append(occurrences, occurrence.Format(time.RFC3339))
+		}
+		return true
+	}
+	if skip {
+		rule.eachFrom(start, from, fn)
+	} else {
+		rule.each(start, fn)
+	}
+	return occurrences
+}
+
+func TestRecurrenceRuleEachFrom(t *testing.T) {
+	start := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+	from := time.Date(2029, 6, 3, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 2, 0)
+	for _, text := range []string{
+		"FREQ=DAILY;INTERVAL=3",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR",
+		"FREQ=MONTHLY;BYMONTHDAY=-1",
+		"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+		"FREQ=YEARLY;BYMONTH=7",
+		"FREQ=DAILY;COUNT=5000",
+	} {
+		rule, err := ParseRecurrenceRule(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := occurrencesBetween(rule, start, from, to, false)
+		if got := occurrencesBetween(rule, start, from, to, true); !equalStrings(got, want) {
+			t.Errorf("%q skipping ahead to %s gave %v, want %v", text, from, got, want)
+		}
+	}
+}
+
+func TestExpandOccurrences(t *testing.T) {
+	db := NewMemoryDatabase()
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endDate := time.Date(2026, 1, 5, 17, 0, 0, 0, time.UTC)
+	addTestTasks(t, db, user.Id, &Task{Title: "daily", EndDate: &endDate, Recurrence: "FREQ=DAILY"})
+
+	tests := []struct {
+		from  time.Time
+		days  int
+		limit int
+		want  []string
+	}{
+		{endDate.AddDate(0, 0, -1), 3, 10, []string{"2026-01-05T17:00:00Z", "2026-01-06T17:00:00Z"}},
+		{endDate.AddDate(10, 0, 0), 2, 10, []string{"2036-01-05T17:00:00Z", "2036-01-06T17:00:00Z"}},
+		{endDate, 30, 3, []string{"2026-01-05T17:00:00Z", "2026-01-06T17:00:00Z", "2026-01-07T17:00:00Z"}},
+	}
+	for _, test := range tests {
+		occurrences, err := ExpandOccurrences(db, user.Id, test.from, test.from.AddDate(0, 0, test.days), test.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, occurrence := range occurrences {
+			got = append(got, occurrence.EndDate.Format(time.RFC3339))
+		}
+		if !equalStrings(got, test.want) {
+			t.Errorf("Occurrences from %s for %d days are %v, want %v", test.from, test.days, got, test.want)
+		}
+	}
+
+	if _, err := ExpandOccurrences(db, user.Id, endDate, endDate.AddDate(2, 0, 0), 10); err == nil {
+		t.Error("Listing occurrences for 2 years succeeded")
+	}
+}
@@ -0,0 +1,202 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// A reminder of a task or habit. Reminders of tasks fire once, either at a time or a number of
+// minutes before the task's end date. Reminders of habits are at a time and fire again every
+// interval of the habit after it.
+type Reminder struct {
+	Id        string    `json:"id" gorm:"primary_key;type:uuid"`
+	CreatedAt time.Time `json:"created_at"`
+	TaskId    string    `json:"task_id" gorm:"not_null;type:uuid"`
+	UserId    uint64    `json:"user_id" gorm:"not_null"`
+	// Exactly one of At and OffsetMinutes is set
+	At            *time.Time `json:"at"`
+	OffsetMinutes *int       `json:"offset_minutes"`
+	// When the reminder is next due, or nil if it won't fire again
+	FireAt  *time.Time `json:"fire_at"`
+	FiredAt *time.Time `json:"fired_at"`
+}
+
+func (reminder *Reminder) BeforeCreate(scope *gorm.Scope) error {
+	if reminder.Id == "" {
+		return scope.SetColumn("Id", newUUID())
+	}
+	return nil
+}
+
+// Number of reminders fired per query for due reminders
+const reminderBatchSize = 100
+
+// Returns the first of the habit's occurrences of a reminder at the given time that comes after
+// the given time.
+func nextOccurrence(at time.Time, interval Interval, after time.Time) time.Time {
+	next := at
+	for i := 1; !next.After(after); i++ {
+		switch interval {
+		case Weekly:
+			next = at.AddDate(0, 0, 7*i)
+		case Monthly:
+			next = at.AddDate(0, i, 0)
+		default:
+			next = at.AddDate(0, 0, i)
+		}
+	}
+	return next
+}
+
+// Returns when the reminder of the task is next due, or nil if it has none. Times are kept in UTC
+// so that they compare the same in every database.
+func reminderFireAt(reminder *Reminder, task *Task, now time.Time) *time.Time {
+	var fireAt time.Time
+	switch {
+	case reminder.At != nil && task.Kind == HabitEnum:
+		fireAt = nextOccurrence(*reminder.At, task.Interval, now.Add(-time.Nanosecond))
+	case reminder.At != nil:
+		fireAt = *reminder.At
+	case task.EndDate != nil:
+		fireAt = task.EndDate.Add(-time.Duration(*reminder.OffsetMinutes) * time.Minute)
+	default:
+		return nil
+	}
+	fireAt = fireAt.UTC()
+	return &fireAt
+}
+
+// Checks that the reminder can be set on the task and sets when it is first due.
+func scheduleReminder(reminder *Reminder, task *Task) error {
+	if (reminder.At == nil) == (reminder.OffsetMinutes == nil) {
+		return fmt.Errorf("Reminder must have either a time or an offset before the end date")
+	}
+	if reminder.OffsetMinutes != nil && *reminder.OffsetMinutes < 0 {
+		return fmt.Errorf("Reminder offset can't be negative")
+	}
+	if reminder.OffsetMinutes != nil && task.Kind == HabitEnum {
+		return fmt.Errorf("Habits have no end date, so their reminders must have a time")
+	}
+	reminder.FireAt = reminderFireAt(reminder, task, time.Now())
+	return nil
+}
+
+func endDateChanged(before *Task, after *Task) bool {
+	if before.EndDate == nil || after.EndDate == nil {
+		return before.EndDate != after.EndDate
+	}
+	return !before.EndDate.Equal(*after.EndDate)
+}
+
+// A reminder being delivered.
+type Notification struct {
+	ReminderId string     `json:"reminder_id"`
+	UserId     uint64     `json:"user_id"`
+	TaskId     string     `json:"task_id"`
+	Kind       TaskKind   `json:"kind"`
+	Title      string     `json:"title"`
+	EndDate    *time.Time `json:"end_date"`
+	// When the reminder was due
+	FireAt time.Time `json:"fire_at"`
+}
+
+// Notifier delivers reminders to their users.
+type Notifier interface {
+	Notify(notification Notification) error
+}
+
+// logNotifier is a Notifier that writes each notification as a line of JSON, for local use.
+type logNotifier struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// Returns a Notifier that writes each notification to out as a line of JSON.
+func NewLogNotifier(out io.Writer) Notifier {
+	return &logNotifier{out: out}
+}
+
+func (notifier *logNotifier) Notify(notification Notification) error {
+	line, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+	_, err = notifier.out.Write(append(line, '\n'))
+	return err
+}
+
+// Delivers every reminder due by now and returns how many were delivered. Each reminder is claimed
+// in the database before it is delivered, so it is delivered at most once even if the process
+// exits or another instance fires reminders at the same time. A reminder whose delivery fails is
+// not retried. Reminders of tasks that are done or in the trash when due are dropped.
+func FireDueReminders(db Database, notifier Notifier, now time.Time) (int, error) {
+	fired := 0
+	for {
+		due, err := db.GetDueReminders(now, reminderBatchSize)
+		if err != nil {
+			return fired, err
+		}
+		for _, reminder := range due {
+			delivered, err := fireReminder(db, notifier, reminder, now)
+			if err != nil {
+				return fired, err
+			}
+			if delivered {
+				fired++
+			}
+		}
+		if len(due) < reminderBatchSize {
+			return fired, nil
+		}
+	}
+}
+
+func fireReminder(db Database, notifier Notifier, reminder Reminder, now time.Time) (bool, error) {
+	task, err := db.GetTask(reminder.TaskId, reminder.UserId, nil)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return false, err
+	}
+	var next *time.Time
+	if task != nil && task.Kind == HabitEnum && reminder.At != nil {
+		// Occurrences missed while the server was down are skipped rather than all delivered late
+		following := nextOccurrence(*reminder.At, task.Interval, now).UTC()
+		next = &following
+	}
+	claimed, err := db.ClaimReminder(reminder.Id, *reminder.FireAt, next)
+	if err != nil || !claimed || task == nil || task.Done {
+		return false, err
+	}
+
+	err = notifier.Notify(Notification{
+		ReminderId: reminder.Id,
+		UserId:     reminder.UserId,
+		TaskId:     task.Id,
+		Kind:       task.Kind,
+		Title:      task.Title,
+		EndDate:    task.EndDate,
+		FireAt:     *reminder.FireAt,
+	})
+	if err != nil {
+		log.Printf("Error delivering reminder %s: %s", reminder.Id, err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Periodically delivers due reminders. Runs until the process exits.
+func RunReminders(db Database, notifier Notifier, interval time.Duration) {
+	for {
+		if _, err := FireDueReminders(db, notifier, time.Now()); err != nil {
+			log.Printf("Error firing reminders: %s", err)
+		}
+		time.Sleep(interval)
+	}
+}
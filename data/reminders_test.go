@@ -0,0 +1,142 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	at := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		interval Interval
+		after    time.Time
+		want     time.Time
+	}{
+		{Daily, at.Add(-time.Minute), at},
+		{Daily, at, at.AddDate(0, 0, 1)},
+		{Daily, at.AddDate(0, 0, 3), at.AddDate(0, 0, 4)},
+		{Weekly, at.AddDate(0, 0, 1), at.AddDate(0, 0, 7)},
+		// Monthly occurrences are counted from the first, so they don't drift after short months
+		{Monthly, at.AddDate(0, 1, 5), time.Date(2026, 3, 31, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		if got := nextOccurrence(at, test.interval, test.after); !got.Equal(test.want) {
+			t.Errorf("nextOccurrence(%s, %d, %s) = %s, want %s", at, test.interval, test.after, got, test.want)
+		}
+	}
+}
+
+type recordingNotifier struct {
+	notifications []Notification
+}
+
+func (notifier *recordingNotifier) Notify(notification Notification) error {
+	notifier.notifications = append(notifier.notifications, notification)
+	return nil
+}
+
+// Fires the reminders due at now and fails the test unless the given tasks were notified about.
+func checkFired(t *testing.T, db Database, now time.Time, titles ...string) {
+	notifier := &recordingNotifier{}
+	fired, err := FireDueReminders(db, notifier, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, notification := range notifier.notifications {
+		got = append(got, notification.Title)
+	}
+	if fired != len(got) || !equalStrings(got, titles) {
+		t.Errorf("%T: at %s, fired %d reminders of %v, want %v", db, now, fired, got, titles)
+	}
+}
+
+func TestFireDueReminders(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		endDate := now.Add(2 * time.Hour)
+		task := &Task{Title: "task", EndDate: &endDate}
+		done := &Task{Title: "done", EndDate: &endDate}
+		addTestTasks(t, db, user.Id, task, done)
+		hour := 60
+		for _, taskId := range []string{task.Id, done.Id} {
+			if err := db.AddReminder(&Reminder{TaskId: taskId, OffsetMinutes: &hour}, user.Id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.UpdateTask(done.Id, user.Id, map[string]interface{}{"done": true}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		checkFired(t, db, now)
+		checkFired(t, db, now.Add(90*time.Minute), "task")
+		// Reminders fire at most once
+		checkFired(t, db, now.Add(90*time.Minute))
+
+		// Moving the end date reschedules reminders relative to it
+		later := endDate.Add(24 * time.Hour)
+		if _, err := db.UpdateTask(task.Id, user.Id, map[string]interface{}{"end_date": &later}, nil); err != nil {
+			t.Fatal(err)
+		}
+		checkFired(t, db, now.Add(12*time.Hour))
+		checkFired(t, db, now.Add(25*time.Hour), "task")
+	}
+}
+
+func TestFireDueRemindersOfHabits(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		habit := &Task{Title: "habit", Kind: HabitEnum, Interval: Weekly, Frequency: 1}
+		addTestTasks(t, db, user.Id, habit)
+		at := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+		if err := db.AddReminder(&Reminder{TaskId: habit.Id, At: &at}, user.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		checkFired(t, db, at, "habit")
+		checkFired(t, db, at.AddDate(0, 0, 1))
+		checkFired(t, db, at.AddDate(0, 0, 7), "habit")
+		// Occurrences missed in the meantime are skipped
+		checkFired(t, db, at.AddDate(0, 0, 30), "habit")
+		checkFired(t, db, at.AddDate(0, 0, 31))
+	}
+}
+
+func TestAddReminderValidates(t *testing.T) {
+	db := NewMemoryDatabase()
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Title: "task"}
+	habit := &Task{Title: "habit", Kind: HabitEnum}
+	addTestTasks(t, db, user.Id, task, habit)
+	at := time.Now()
+	offset := 10
+	negative := -10
+
+	tests := []*Reminder{
+		{TaskId: task.Id},
+		{TaskId: task.Id, At: &at, OffsetMinutes: &offset},
+		{TaskId: task.Id, OffsetMinutes: &negative},
+		{TaskId: habit.Id, OffsetMinutes: &offset},
+	}
+	for _, reminder := range tests {
+		if err := db.AddReminder(reminder, user.Id); err == nil {
+			t.Errorf("AddReminder(%+v) succeeded, want an error", reminder)
+		}
+	}
+}
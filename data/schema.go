@@ -1,6 +1,7 @@
 package data
 
 import (
+	"encoding/json"
 	"strconv"
 	"time"
 
@@ -15,7 +16,169 @@ func userIdOfContext(p graphql.ResolveParams) uint64 {
 	return id
 }
 
+func tasksOfKind(tasks []Task, kind TaskKind) []Task {
+	result := []Task{}
+	for _, task := range tasks {
+		if task.Kind == kind {
+			result = append(result, task)
+		}
+	}
+	return result
+}
+
+type taskEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Task  `json:"node"`
+}
+
+// Builds the TaskQuery for a tasks or habits field from its arguments.
+func taskQueryOfArgs(p graphql.ResolveParams, kind TaskKind) TaskQuery {
+	query := TaskQuery{
+		Kind: &kind,
+	}
+	if done, ok := p.Args["done"].(bool); ok {
+		query.Done = &done
+	}
+	query.DueAfter, _ = p.Args["dueAfter"].(*time.Time)
+	query.DueBefore, _ = p.Args["dueBefore"].(*time.Time)
+	query.CreatedAfter, _ = p.Args["createdAfter"].(*time.Time)
+	query.CreatedBefore, _ = p.Args["createdBefore"].(*time.Time)
+	query.UpdatedAfter, _ = p.Args["updatedAfter"].(*time.Time)
+	query.UpdatedBefore, _ = p.Args["updatedBefore"].(*time.Time)
+	if project, ok := p.Args["project"].(string); ok {
+		query.ProjectId = &project
+	}
+	if recurring, ok := p.Args["recurring"].(bool); ok {
+		query.Recurring = &recurring
+	}
+	if actionable, ok := p.Args["actionable"].(bool); ok {
+		query.Actionable = &actionable
+	}
+	if tags, ok := p.Args["tags"].([]interface{}); ok {
+		for _, tag := range tags {
+			if name, ok := tag.(string); ok {
+				query.Tags = append(query.Tags, name)
+			}
+		}
+	}
+	query.MatchAll, _ = p.Args["matchAll"].(bool)
+	query.Order, _ = p.Args["orderBy"].(TaskOrder)
+	query.First, _ = p.Args["first"].(int)
+	query.After, _ = p.Args["after"].(string)
+	return query
+}
+
+// Builds a task from the arguments of addTask, or an item of addTasks.
+func newTaskOfArgs(args map[string]interface{}) *Task {
+	id, _ := args["id"].(string)
+	title, _ := args["title"].(string)
+	notes, _ := args["notes"].(string)
+	startDate, _ := args["start_date"].(*time.Time)
+	endDate, _ := args["end_date"].(*time.Time)
+	done, _ := args["done"].(bool)
+	autoComplete, _ := args["auto_complete"].(bool)
+	priority, _ := args["priority"].(Priority)
+	recurrence, _ := args["recurrence"].(string)
+
+	task := &Task{
+		Id:           id,
+		Title:        title,
+		Notes:        notes,
+		StartDate:    startDate,
+		EndDate:      endDate,
+		Done:         done,
+		AutoComplete: autoComplete,
+		Priority:     priority,
+		Recurrence:   recurrence,
+		Kind:         TaskEnum,
+	}
+	if parent, ok := args["parent"].(string); ok && parent != "" {
+		task.ParentId = &parent
+	}
+	if project, ok := args["project"].(string); ok && project != "" {
+		task.ProjectId = &project
+	}
+	return task
+}
+
+// Collects the attributes to change from the arguments of updateTask or updateHabit, or an item
+// of updateTasks.
+func taskAttrsOfArgs(args map[string]interface{}) map[string]interface{} {
+	attrs := make(map[string]interface{})
+
+	if title, ok := args["title"].(string); ok {
+		attrs["title"] = title
+	}
+	if notes, ok := args["notes"].(string); ok {
+		attrs["notes"] = notes
+	}
+	if startDate, ok := args["start_date"].(*time.Time); ok {
+		attrs["start_date"] = startDate
+	}
+	if endDate, ok := args["end_date"].(*time.Time); ok {
+		attrs["end_date"] = endDate
+	}
+	if interval, ok := args["interval"].(Interval); ok {
+		attrs["interval"] = interval
+	}
+	if frequency, ok := args["frequency"].(int); ok {
+		attrs["frequency"] = frequency
+	}
+	if done, ok := args["done"].(bool); ok {
+		attrs["done"] = done
+	}
+	// An empty parent moves the task back to the top level
+	if parent, ok := args["parent"].(string); ok {
+		if parent == "" {
+			attrs["parent_id"] = (*string)(nil)
+		} else {
+			attrs["parent_id"] = &parent
+		}
+	}
+	if autoComplete, ok := args["auto_complete"].(bool); ok {
+		attrs["auto_complete"] = autoComplete
+	}
+	if priority, ok := args["priority"].(Priority); ok {
+		attrs["priority"] = priority
+	}
+	if recurrence, ok := args["recurrence"].(string); ok {
+		attrs["recurrence"] = recurrence
+	}
+	// An empty project moves the task or habit out of its project
+	if project, ok := args["project"].(string); ok {
+		if project == "" {
+			attrs["project_id"] = (*string)(nil)
+		} else {
+			attrs["project_id"] = &project
+		}
+	}
+	return attrs
+}
+
+func expectedVersionOfArgs(args map[string]interface{}) *int {
+	if version, ok := args["expectedVersion"].(int); ok {
+		return &version
+	}
+	return nil
+}
+
+// Builds the result of one item of a batch mutation, which holds either the item or its error.
+func batchResult(field string, item interface{}, err error) map[string]interface{} {
+	if err != nil {
+		return map[string]interface{}{
+			field:   nil,
+			"error": err.Error(),
+		}
+	}
+	return map[string]interface{}{
+		field:   item,
+		"error": nil,
+	}
+}
+
 func GetSchema(db Database) *graphql.Schema {
+	db = WithHistory(db, SourceGraphQL)
+
 	dateType := graphql.NewScalar(graphql.ScalarConfig{
 		Name:        "Date",
 		Description: "Date and time",
@@ -72,6 +235,33 @@ func GetSchema(db Database) *graphql.Schema {
 				Value:       ActionDone,
 				Description: "User has completed the task",
 			},
+			"START": &graphql.EnumValueConfig{
+				Value:       ActionStart,
+				Description: "User started a timer on the task",
+			},
+			"STOP": &graphql.EnumValueConfig{
+				Value:       ActionStop,
+				Description: "User stopped the timer on the task",
+			},
+		},
+	})
+
+	priority := graphql.NewEnum(graphql.EnumConfig{
+		Name:        "Priority",
+		Description: "How much a task matters",
+		Values: graphql.EnumValueConfigMap{
+			"NONE": &graphql.EnumValueConfig{
+				Value: PriorityNone,
+			},
+			"LOW": &graphql.EnumValueConfig{
+				Value: PriorityLow,
+			},
+			"MEDIUM": &graphql.EnumValueConfig{
+				Value: PriorityMedium,
+			},
+			"HIGH": &graphql.EnumValueConfig{
+				Value: PriorityHigh,
+			},
 		},
 	})
 
@@ -94,6 +284,142 @@ func GetSchema(db Database) *graphql.Schema {
 		},
 	})
 
+	kind := graphql.NewEnum(graphql.EnumConfig{
+		Name:        "Kind",
+		Description: "Whether an item is a task or a habit",
+		Values: graphql.EnumValueConfigMap{
+			"TASK": &graphql.EnumValueConfig{
+				Value: TaskEnum,
+			},
+			"HABIT": &graphql.EnumValueConfig{
+				Value: HabitEnum,
+			},
+		},
+	})
+
+	taskOrder := graphql.NewEnum(graphql.EnumConfig{
+		Name:        "TaskOrder",
+		Description: "The order to list tasks or habits in",
+		Values: graphql.EnumValueConfigMap{
+			"CREATED_AT_ASC": &graphql.EnumValueConfig{
+				Value:       OrderCreatedAsc,
+				Description: "Oldest first",
+			},
+			"CREATED_AT_DESC": &graphql.EnumValueConfig{
+				Value:       OrderCreatedDesc,
+				Description: "Newest first",
+			},
+			"UPDATED_AT_ASC": &graphql.EnumValueConfig{
+				Value:       OrderUpdatedAsc,
+				Description: "Least recently updated first",
+			},
+			"UPDATED_AT_DESC": &graphql.EnumValueConfig{
+				Value:       OrderUpdatedDesc,
+				Description: "Most recently updated first",
+			},
+			"DUE_DATE_ASC": &graphql.EnumValueConfig{
+				Value:       OrderDueAsc,
+				Description: "Earliest end date first, with undated tasks last",
+			},
+			"DUE_DATE_DESC": &graphql.EnumValueConfig{
+				Value:       OrderDueDesc,
+				Description: "Undated tasks first, then latest end date first",
+			},
+			"PRIORITY_ASC": &graphql.EnumValueConfig{
+				Value:       OrderPriorityAsc,
+				Description: "Lowest priority first",
+			},
+			"PRIORITY_DESC": &graphql.EnumValueConfig{
+				Value:       OrderPriorityDesc,
+				Description: "Highest priority first",
+			},
+			"POSITION_ASC": &graphql.EnumValueConfig{
+				Value:       OrderPositionAsc,
+				Description: "The user's manual order, as arranged with moveTask and moveHabit",
+			},
+			"POSITION_DESC": &graphql.EnumValueConfig{
+				Value:       OrderPositionDesc,
+				Description: "The user's manual order, reversed",
+			},
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PageInfo",
+		Description: "Where a page sits within a paginated list",
+		Fields: graphql.Fields{
+			"endCursor": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*TaskPage).EndCursor(), nil
+				},
+			},
+			"hasNextPage": &graphql.Field{
+				Type: graphql.Boolean,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*TaskPage).HasNextPage, nil
+				},
+			},
+		},
+	})
+
+	// Pagination and filtering arguments shared by the tasks and habits fields
+	taskQueryArgs := graphql.FieldConfigArgument{
+		"first": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: "Maximum number of items to return",
+		},
+		"after": &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: "Cursor to start after, from a previous page",
+		},
+		"orderBy": &graphql.ArgumentConfig{
+			Type:         taskOrder,
+			DefaultValue: OrderCreatedAsc,
+		},
+		"done": &graphql.ArgumentConfig{
+			Type: graphql.Boolean,
+		},
+		"dueAfter": &graphql.ArgumentConfig{
+			Type: dateType,
+		},
+		"dueBefore": &graphql.ArgumentConfig{
+			Type: dateType,
+		},
+		"createdAfter": &graphql.ArgumentConfig{
+			Type: dateType,
+		},
+		"createdBefore": &graphql.ArgumentConfig{
+			Type: dateType,
+		},
+		"updatedAfter": &graphql.ArgumentConfig{
+			Type: dateType,
+		},
+		"updatedBefore": &graphql.ArgumentConfig{
+			Type: dateType,
+		},
+		"project": &graphql.ArgumentConfig{
+			Type:        graphql.ID,
+			Description: "Only list items in this project, or items in no project if empty",
+		},
+		"recurring": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "Only list items that repeat, or that don't",
+		},
+		"actionable": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "Only list items that aren't done or blocked by an item that isn't done, or only those that are",
+		},
+		"tags": &graphql.ArgumentConfig{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "Only list items with any of these tags",
+		},
+		"matchAll": &graphql.ArgumentConfig{
+			Type:        graphql.Boolean,
+			Description: "Only list items with all of the tags instead",
+		},
+	}
+
 	actionType := graphql.NewObject(graphql.ObjectConfig{
 		Name:        "Action",
 		Description: "An action that is performed on a task or habit",
@@ -110,197 +436,1329 @@ func GetSchema(db Database) *graphql.Schema {
 		},
 	})
 
-	taskType := graphql.NewObject(graphql.ObjectConfig{
-		Name:        "Task",
-		Description: "A TODO task",
+	tagType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Tag",
+		Description: "A label that can be put on any number of tasks and habits",
 		Fields: graphql.Fields{
 			"id": &graphql.Field{
 				Type: graphql.ID,
 			},
-			"title": &graphql.Field{
+			"name": &graphql.Field{
 				Type: graphql.String,
 			},
-			"start_date": &graphql.Field{
+			"created_at": &graphql.Field{
 				Type: dateType,
 			},
-			"end_date": &graphql.Field{
-				Type: dateType,
+		},
+	})
+
+	attachmentType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Attachment",
+		Description: "A file attached to a task",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
 			},
-			"done": &graphql.Field{
-				Type: graphql.Boolean,
+			"filename": &graphql.Field{
+				Type: graphql.String,
 			},
-			"actions": &graphql.Field{
-				Type: graphql.NewList(actionType),
+			"content_type": &graphql.Field{
+				Type: graphql.String,
+			},
+			"size": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Size of the file in bytes",
 			},
 			"created_at": &graphql.Field{
 				Type: dateType,
 			},
-			"updated_at": &graphql.Field{
-				Type: dateType,
+			"url": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Where to download the file, with the same authorization as /graphql",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return "/attachments/" + p.Source.(Attachment).Id, nil
+				},
 			},
 		},
 	})
 
-	habitType := graphql.NewObject(graphql.ObjectConfig{
-		Name:        "Habit",
-		Description: "A recurring habit",
+	reminderType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Reminder",
+		Description: "A reminder of a task or habit",
 		Fields: graphql.Fields{
 			"id": &graphql.Field{
 				Type: graphql.ID,
 			},
-			"title": &graphql.Field{
-				Type: graphql.String,
-			},
-			"interval": &graphql.Field{
-				Type: interval,
-			},
-			"frequency": &graphql.Field{
-				Type: graphql.Int,
+			"at": &graphql.Field{
+				Type:        dateType,
+				Description: "When the reminder fires. Reminders of habits fire again every interval after it",
 			},
-			"done": &graphql.Field{
-				Type: graphql.Boolean,
+			"offset_minutes": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "How many minutes before the end date of the task the reminder fires",
 			},
-			"actions": &graphql.Field{
-				Type: graphql.NewList(actionType),
+			"fire_at": &graphql.Field{
+				Type:        dateType,
+				Description: "When the reminder is next due, or null if it won't fire again",
 			},
-			"created_at": &graphql.Field{
+			"fired_at": &graphql.Field{
 				Type: dateType,
 			},
-			"updated_at": &graphql.Field{
+			"created_at": &graphql.Field{
 				Type: dateType,
 			},
 		},
 	})
 
-	userType := graphql.NewObject(graphql.ObjectConfig{
-		Name:        "User",
-		Description: "A Duet user",
+	taskIdOfSource := func(p graphql.ResolveParams) string {
+		switch task := p.Source.(type) {
+		case *Task:
+			return task.Id
+		case Task:
+			return task.Id
+		}
+		return ""
+	}
+
+	notesOfSource := func(p graphql.ResolveParams) string {
+		switch task := p.Source.(type) {
+		case *Task:
+			return task.Notes
+		case Task:
+			return task.Notes
+		}
+		return ""
+	}
+
+	checklistItemType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ChecklistItem",
+		Description: "A \"- [ ]\" or \"- [x]\" item in the notes of a task or habit",
 		Fields: graphql.Fields{
-			"id": &graphql.Field{
-				Type: graphql.ID,
+			"line": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "The line of the notes the item is on, counting from 0",
 			},
-			"username": &graphql.Field{
+			"text": &graphql.Field{
 				Type: graphql.String,
 			},
+			"checked": &graphql.Field{
+				Type: graphql.Boolean,
+			},
 		},
 	})
 
-	userQuery := &graphql.Field{
-		Type: userType,
+	// Fields derived from the Markdown notes of a task or habit
+	notesHTMLField := &graphql.Field{
+		Type:        graphql.String,
+		Description: "The notes rendered as sanitized HTML",
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			user, err := db.GetUserById(userIdOfContext(p))
-			if err != nil {
-				return nil, err
-			}
-			// Type of the nil matters apparently
-			if user == nil {
-				return nil, nil
-			}
-			return user, nil
+			return renderMarkdown(notesOfSource(p)), nil
+		},
+	}
+	checklistField := &graphql.Field{
+		Type:        graphql.NewList(checklistItemType),
+		Description: "The checklist items in the notes, in order",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return parseChecklist(notesOfSource(p)), nil
 		},
 	}
 
-	taskQuery := &graphql.Field{
-		Type: taskType,
-		Args: graphql.FieldConfigArgument{
-			"id": &graphql.ArgumentConfig{
-				Type:         graphql.ID,
-				DefaultValue: nil,
+	// Actions, tags, attachments and reminders are loaded in one batch for all the tasks in a
+	// request, and only when selected
+	resolveActions := func(p graphql.ResolveParams) (interface{}, error) {
+		return taskLoaderOfContext(p, db).LoadActions(taskIdOfSource(p))
+	}
+	resolveTags := func(p graphql.ResolveParams) (interface{}, error) {
+		return taskLoaderOfContext(p, db).LoadTags(taskIdOfSource(p))
+	}
+	resolveAttachments := func(p graphql.ResolveParams) (interface{}, error) {
+		return taskLoaderOfContext(p, db).LoadAttachments(taskIdOfSource(p))
+	}
+	resolveReminders := func(p graphql.ResolveParams) (interface{}, error) {
+		return taskLoaderOfContext(p, db).LoadReminders(taskIdOfSource(p))
+	}
+	// Blockers and dependents are loaded in the same way, and are primed in turn
+	resolveBlockers := func(p graphql.ResolveParams) (interface{}, error) {
+		loader := taskLoaderOfContext(p, db)
+		blockers, err := loader.LoadBlockers(taskIdOfSource(p))
+		if err != nil {
+			return nil, err
+		}
+		loader.Prime(blockers...)
+		return blockers, nil
+	}
+	resolveDependents := func(p graphql.ResolveParams) (interface{}, error) {
+		loader := taskLoaderOfContext(p, db)
+		dependents, err := loader.LoadDependents(taskIdOfSource(p))
+		if err != nil {
+			return nil, err
+		}
+		loader.Prime(dependents...)
+		return dependents, nil
+	}
+
+	trackedDayType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "TrackedDay",
+		Description: "The time tracked on a task or habit on one day",
+		Fields: graphql.Fields{
+			"date": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The day as YYYY-MM-DD",
+			},
+			"seconds": &graphql.Field{
+				Type: graphql.Int,
 			},
 		},
+	})
+
+	// Fields derived from the start and stop actions of a task or habit. Running timers count up to
+	// the time of the request.
+	loadTimeEntries := func(p graphql.ResolveParams) ([]TimeEntry, error) {
+		actions, err := taskLoaderOfContext(p, db).LoadActions(taskIdOfSource(p))
+		if err != nil {
+			return nil, err
+		}
+		return timeEntries(actions), nil
+	}
+	trackedSecondsField := &graphql.Field{
+		Type:        graphql.Int,
+		Description: "Total time tracked with timers in seconds",
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			id := p.Args["id"].(string)
-			kind := TaskEnum
-			task, err := db.GetTask(id, userIdOfContext(p), &kind)
+			entries, err := loadTimeEntries(p)
 			if err != nil {
 				return nil, err
 			}
-			// Type of the nil matters apparently
-			if task == nil {
-				return nil, nil
-			}
-			return task, nil
+			return int(trackedTime(entries, time.Now()) / time.Second), nil
 		},
 	}
-
-	habitQuery := &graphql.Field{
-		Type: habitType,
+	trackedByDayField := &graphql.Field{
+		Type: graphql.NewList(trackedDayType),
 		Args: graphql.FieldConfigArgument{
-			"id": &graphql.ArgumentConfig{
-				Type:         graphql.ID,
-				DefaultValue: nil,
+			"timezone": &graphql.ArgumentConfig{
+				Type:         graphql.String,
+				DefaultValue: "UTC",
+				Description:  "IANA time zone the days are in, such as \"America/Toronto\"",
 			},
 		},
+		Description: "Time tracked with timers on each day it was tracked, oldest first",
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			id := p.Args["id"].(string)
-			kind := HabitEnum
-			task, err := db.GetTask(id, userIdOfContext(p), &kind)
+			timezone, _ := p.Args["timezone"].(string)
+			loc, err := time.LoadLocation(timezone)
 			if err != nil {
 				return nil, err
 			}
-			// Type of the nil matters apparently
-			if task == nil {
-				return nil, nil
+			entries, err := loadTimeEntries(p)
+			if err != nil {
+				return nil, err
 			}
-			return task, nil
-		},
-	}
-
-	tasksQuery := &graphql.Field{
-		Type: graphql.NewList(taskType),
-		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			kind := TaskEnum
-			return db.GetTasks(userIdOfContext(p), &kind)
+			return trackedByDay(entries, time.Now(), loc), nil
 		},
 	}
-
-	habitsQuery := &graphql.Field{
-		Type: graphql.NewList(habitType),
+	timerStartedAtField := &graphql.Field{
+		Type:        dateType,
+		Description: "When the running timer was started, or null if no timer is running",
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			kind := HabitEnum
-			return db.GetTasks(userIdOfContext(p), &kind)
+			entries, err := loadTimeEntries(p)
+			if err != nil || len(entries) == 0 || entries[len(entries)-1].Stop != nil {
+				return nil, err
+			}
+			return &entries[len(entries)-1].Start, nil
 		},
 	}
 
-	addTaskMutation := &graphql.Field{
-		Type: taskType,
-		Args: graphql.FieldConfigArgument{
-			"id": &graphql.ArgumentConfig{
+	taskType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Task",
+		Description: "A TODO task",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
 				Type: graphql.ID,
 			},
-			"title": &graphql.ArgumentConfig{
-				Type: graphql.NewNonNull(graphql.String),
+			"title": &graphql.Field{
+				Type: graphql.String,
 			},
-			"start_date": &graphql.ArgumentConfig{
+			"notes": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Long-form description in Markdown",
+			},
+			"notes_html": notesHTMLField,
+			"checklist":  checklistField,
+			"start_date": &graphql.Field{
 				Type: dateType,
 			},
-			"end_date": &graphql.ArgumentConfig{
+			"end_date": &graphql.Field{
 				Type: dateType,
 			},
-			"done": &graphql.ArgumentConfig{
+			"done": &graphql.Field{
 				Type: graphql.Boolean,
 			},
-		},
-		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
-			id, _ := p.Args["id"].(string)
-			title, _ := p.Args["title"].(string)
-			startDate, _ := p.Args["start_date"].(*time.Time)
-			endDate, _ := p.Args["end_date"].(*time.Time)
-			done, _ := p.Args["done"].(bool)
-
-			newTask := &Task{
-				Id:        id,
-				Title:     title,
-				StartDate: startDate,
-				EndDate:   endDate,
-				Done:      done,
-				Kind:      TaskEnum,
-			}
-
-			if err := db.AddTask(newTask, userIdOfContext(p)); err != nil {
-				return nil, err
+			"parent_id": &graphql.Field{
+				Type:        graphql.ID,
+				Description: "The task this is a subtask of",
+			},
+			"auto_complete": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether the task is marked done once all its subtasks are",
+			},
+			"priority": &graphql.Field{
+				Type: priority,
+			},
+			"position": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Opaque rank of the task in the manual order, which sorts by ascending position",
+			},
+			"recurrence": &graphql.Field{
+				Type:        graphql.String,
+				Description: "RFC 5545 RRULE the task repeats by, or empty if it doesn't repeat",
+			},
+			"next_id": &graphql.Field{
+				Type:        graphql.ID,
+				Description: "The next occurrence, created when this occurrence of a recurring task was completed",
+			},
+			"version": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Incremented on every update",
+			},
+			"actions": &graphql.Field{
+				Type:    graphql.NewList(actionType),
+				Resolve: resolveActions,
+			},
+			"tracked_seconds":  trackedSecondsField,
+			"tracked_by_day":   trackedByDayField,
+			"timer_started_at": timerStartedAtField,
+			"tags": &graphql.Field{
+				Type:    graphql.NewList(tagType),
+				Resolve: resolveTags,
+			},
+			"attachments": &graphql.Field{
+				Type:    graphql.NewList(attachmentType),
+				Resolve: resolveAttachments,
+			},
+			"reminders": &graphql.Field{
+				Type:    graphql.NewList(reminderType),
+				Resolve: resolveReminders,
+			},
+			"isBlocked": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Whether any of the tasks blocking this one isn't done",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					blockers, err := taskLoaderOfContext(p, db).LoadBlockers(taskIdOfSource(p))
+					if err != nil {
+						return nil, err
+					}
+					return isBlocked(blockers), nil
+				},
+			},
+			"created_at": &graphql.Field{
+				Type: dateType,
+			},
+			"updated_at": &graphql.Field{
+				Type: dateType,
+			},
+			"deleted_at": &graphql.Field{
+				Type:        dateType,
+				Description: "When the item was moved to the trash",
+			},
+			"project_id": &graphql.Field{
+				Type: graphql.ID,
+			},
+		},
+	})
+	// Added separately since the fields refer to the type itself
+	taskType.AddFieldConfig("blockedBy", &graphql.Field{
+		Type:        graphql.NewList(taskType),
+		Resolve:     resolveBlockers,
+		Description: "The tasks that block this one until they are done, oldest first",
+	})
+	taskType.AddFieldConfig("blocking", &graphql.Field{
+		Type:        graphql.NewList(taskType),
+		Resolve:     resolveDependents,
+		Description: "The tasks that this one blocks until it is done, oldest first",
+	})
+	taskType.AddFieldConfig("subtasks", &graphql.Field{
+		Type: graphql.NewList(taskType),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			loader := taskLoaderOfContext(p, db)
+			subtasks, err := loader.LoadSubtasks(taskIdOfSource(p))
+			if err != nil {
+				return nil, err
+			}
+			loader.Prime(subtasks...)
+			return subtasks, nil
+		},
+		Description: "The task's direct subtasks, oldest first",
+	})
+
+	occurrenceType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Occurrence",
+		Description: "A date of a recurring task",
+		Fields: graphql.Fields{
+			"task": &graphql.Field{
+				Type: taskType,
+			},
+			"start_date": &graphql.Field{
+				Type:        dateType,
+				Description: "The start date of the task on this occurrence",
+			},
+			"end_date": &graphql.Field{
+				Type:        dateType,
+				Description: "The end date of the task on this occurrence",
+			},
+		},
+	})
+
+	habitType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Habit",
+		Description: "A recurring habit",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"title": &graphql.Field{
+				Type: graphql.String,
+			},
+			"notes": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Long-form description in Markdown",
+			},
+			"notes_html": notesHTMLField,
+			"checklist":  checklistField,
+			"interval": &graphql.Field{
+				Type: interval,
+			},
+			"frequency": &graphql.Field{
+				Type: graphql.Int,
+			},
+			"done": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+			"position": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Opaque rank of the habit in the manual order, which sorts by ascending position",
+			},
+			"version": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Incremented on every update",
+			},
+			"actions": &graphql.Field{
+				Type:    graphql.NewList(actionType),
+				Resolve: resolveActions,
+			},
+			"tracked_seconds":  trackedSecondsField,
+			"tracked_by_day":   trackedByDayField,
+			"timer_started_at": timerStartedAtField,
+			"tags": &graphql.Field{
+				Type:    graphql.NewList(tagType),
+				Resolve: resolveTags,
+			},
+			"reminders": &graphql.Field{
+				Type:    graphql.NewList(reminderType),
+				Resolve: resolveReminders,
+			},
+			"created_at": &graphql.Field{
+				Type: dateType,
+			},
+			"updated_at": &graphql.Field{
+				Type: dateType,
+			},
+			"deleted_at": &graphql.Field{
+				Type:        dateType,
+				Description: "When the item was moved to the trash",
+			},
+			"project_id": &graphql.Field{
+				Type: graphql.ID,
+			},
+		},
+	})
+
+	// Lists the project's live tasks or habits, oldest first
+	resolveProjectTasks := func(kind TaskKind) graphql.FieldResolveFn {
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			project := p.Source.(*Project)
+			page, err := db.GetTasks(userIdOfContext(p), TaskQuery{Kind: &kind, ProjectId: &project.Id})
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Prime(page.Tasks...)
+			return page.Tasks, nil
+		}
+	}
+
+	projectType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Project",
+		Description: "A list that groups tasks and habits",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"name": &graphql.Field{
+				Type: graphql.String,
+			},
+			"color": &graphql.Field{
+				Type:        graphql.String,
+				Description: "A hex color like #4a90e2, or empty for the default",
+			},
+			"archived": &graphql.Field{
+				Type: graphql.Boolean,
+			},
+			"position": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Projects are listed by ascending position",
+			},
+			"tasks": &graphql.Field{
+				Type:    graphql.NewList(taskType),
+				Resolve: resolveProjectTasks(TaskEnum),
+			},
+			"habits": &graphql.Field{
+				Type:    graphql.NewList(habitType),
+				Resolve: resolveProjectTasks(HabitEnum),
+			},
+			"created_at": &graphql.Field{
+				Type: dateType,
+			},
+			"updated_at": &graphql.Field{
+				Type: dateType,
+			},
+		},
+	})
+
+	// Relay style connections for paging through tasks and habits
+	newConnectionType := func(name string, nodeType *graphql.Object) *graphql.Object {
+		edgeType := graphql.NewObject(graphql.ObjectConfig{
+			Name: name + "Edge",
+			Fields: graphql.Fields{
+				"cursor": &graphql.Field{
+					Type: graphql.String,
+				},
+				"node": &graphql.Field{
+					Type: nodeType,
+				},
+			},
+		})
+		return graphql.NewObject(graphql.ObjectConfig{
+			Name: name + "Connection",
+			Fields: graphql.Fields{
+				"edges": &graphql.Field{
+					Type: graphql.NewList(edgeType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						page := p.Source.(*TaskPage)
+						edges := make([]taskEdge, len(page.Tasks))
+						for i := range page.Tasks {
+							edges[i] = taskEdge{
+								Cursor: TaskCursor(&page.Tasks[i], page.Order),
+								Node:   &page.Tasks[i],
+							}
+						}
+						return edges, nil
+					},
+				},
+				"pageInfo": &graphql.Field{
+					Type: pageInfoType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		})
+	}
+
+	fieldChangeType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "FieldChange",
+		Description: "The values of a field before and after a change, encoded as JSON",
+		Fields: graphql.Fields{
+			"field": &graphql.Field{
+				Type: graphql.String,
+			},
+			"before": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					encoded, err := json.Marshal(p.Source.(FieldChange).Before)
+					return string(encoded), err
+				},
+			},
+			"after": &graphql.Field{
+				Type: graphql.String,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					encoded, err := json.Marshal(p.Source.(FieldChange).After)
+					return string(encoded), err
+				},
+			},
+		},
+	})
+
+	historyEntryType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "HistoryEntry",
+		Description: "A change made to a task or habit",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"operation": &graphql.Field{
+				Type:        graphql.String,
+				Description: "One of create, update, delete, restore, purge, add_action, delete_action, add_tag, remove_tag, rename_tag, merge_tag, add_attachment, remove_attachment, add_dependency or remove_dependency",
+			},
+			"source": &graphql.Field{
+				Type:        graphql.String,
+				Description: "Where the change came from: graphql, rest, todoist, cli or retention",
+			},
+			"user_id": &graphql.Field{
+				Type:        graphql.ID,
+				Description: "The user who made the change",
+			},
+			"created_at": &graphql.Field{
+				Type: dateType,
+			},
+			"changes": &graphql.Field{
+				Type: graphql.NewList(fieldChangeType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					entry := p.Source.(HistoryEntry)
+					return entry.FieldChanges()
+				},
+			},
+		},
+	})
+
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "User",
+		Description: "A Duet user",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"username": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	trashType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Trash",
+		Description: "Deleted tasks and habits that can still be restored",
+		Fields: graphql.Fields{
+			"tasks": &graphql.Field{
+				Type: graphql.NewList(taskType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return tasksOfKind(p.Source.([]Task), TaskEnum), nil
+				},
+			},
+			"habits": &graphql.Field{
+				Type: graphql.NewList(habitType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return tasksOfKind(p.Source.([]Task), HabitEnum), nil
+				},
+			},
+		},
+	})
+
+	userQuery := &graphql.Field{
+		Type: userType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			user, err := db.GetUserById(userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			// Type of the nil matters apparently
+			if user == nil {
+				return nil, nil
+			}
+			return user, nil
+		},
+	}
+
+	taskQuery := &graphql.Field{
+		Type: taskType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type:         graphql.ID,
+				DefaultValue: nil,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id := p.Args["id"].(string)
+			kind := TaskEnum
+			task, err := db.GetTask(id, userIdOfContext(p), &kind)
+			if err != nil {
+				return nil, err
+			}
+			// Type of the nil matters apparently
+			if task == nil {
+				return nil, nil
+			}
+			return task, nil
+		},
+	}
+
+	habitQuery := &graphql.Field{
+		Type: habitType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type:         graphql.ID,
+				DefaultValue: nil,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id := p.Args["id"].(string)
+			kind := HabitEnum
+			task, err := db.GetTask(id, userIdOfContext(p), &kind)
+			if err != nil {
+				return nil, err
+			}
+			// Type of the nil matters apparently
+			if task == nil {
+				return nil, nil
+			}
+			return task, nil
+		},
+	}
+
+	tasksQuery := &graphql.Field{
+		Type: graphql.NewList(taskType),
+		Args: taskQueryArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			page, err := db.GetTasks(userIdOfContext(p), taskQueryOfArgs(p, TaskEnum))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Prime(page.Tasks...)
+			return page.Tasks, nil
+		},
+	}
+
+	tasksConnectionQuery := &graphql.Field{
+		Type: newConnectionType("Task", taskType),
+		Args: taskQueryArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			page, err := db.GetTasks(userIdOfContext(p), taskQueryOfArgs(p, TaskEnum))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Prime(page.Tasks...)
+			return page, nil
+		},
+	}
+
+	habitsQuery := &graphql.Field{
+		Type: graphql.NewList(habitType),
+		Args: taskQueryArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			page, err := db.GetTasks(userIdOfContext(p), taskQueryOfArgs(p, HabitEnum))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Prime(page.Tasks...)
+			return page.Tasks, nil
+		},
+	}
+
+	habitsConnectionQuery := &graphql.Field{
+		Type: newConnectionType("Habit", habitType),
+		Args: taskQueryArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			page, err := db.GetTasks(userIdOfContext(p), taskQueryOfArgs(p, HabitEnum))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Prime(page.Tasks...)
+			return page, nil
+		},
+	}
+
+	occurrencesQuery := &graphql.Field{
+		Type: graphql.NewList(occurrenceType),
+		Args: graphql.FieldConfigArgument{
+			"from": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(dateType),
+			},
+			"to": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(dateType),
+				Description: "No more than 366 days after from",
+			},
+			"limit": &graphql.ArgumentConfig{
+				Type:         graphql.Int,
+				DefaultValue: 100,
+				Description:  "Maximum number of occurrences to return",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			from, _ := p.Args["from"].(*time.Time)
+			to, _ := p.Args["to"].(*time.Time)
+			limit, _ := p.Args["limit"].(int)
+			occurrences, err := ExpandOccurrences(db, userIdOfContext(p), *from, *to, limit)
+			if err != nil {
+				return nil, err
+			}
+			loader := taskLoaderOfContext(p, db)
+			for _, occurrence := range occurrences {
+				loader.Prime(*occurrence.Task)
+			}
+			return occurrences, nil
+		},
+		Description: "Upcoming dates of the recurring tasks that aren't done, in order, starting from their current occurrence",
+	}
+
+	historyQuery := &graphql.Field{
+		Type: graphql.NewList(historyEntryType),
+		Args: graphql.FieldConfigArgument{
+			"taskId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			return db.GetHistory(taskId, userIdOfContext(p))
+		},
+		Description: "Every change made to a task or habit, oldest first",
+	}
+
+	projectQuery := &graphql.Field{
+		Type: projectType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			return db.GetProject(id, userIdOfContext(p))
+		},
+	}
+
+	projectsQuery := &graphql.Field{
+		Type: graphql.NewList(projectType),
+		Args: graphql.FieldConfigArgument{
+			"archived": &graphql.ArgumentConfig{
+				Type:        graphql.Boolean,
+				Description: "Only list projects that are or aren't archived",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			var archived *bool
+			if value, ok := p.Args["archived"].(bool); ok {
+				archived = &value
+			}
+			projects, err := db.GetProjects(userIdOfContext(p), archived)
+			if err != nil {
+				return nil, err
+			}
+			result := make([]*Project, len(projects))
+			for i := range projects {
+				result[i] = &projects[i]
+			}
+			return result, nil
+		},
+	}
+
+	tagsQuery := &graphql.Field{
+		Type: graphql.NewList(tagType),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return db.GetTags(userIdOfContext(p))
+		},
+		Description: "The user's tags by name",
+	}
+
+	trashQuery := &graphql.Field{
+		Type: trashType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			tasks, err := db.GetDeletedTasks(userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Prime(tasks...)
+			return tasks, nil
+		},
+	}
+
+	addTaskMutation := &graphql.Field{
+		Type: taskType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.ID,
+			},
+			"title": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"notes": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "Long-form description in Markdown",
+			},
+			"start_date": &graphql.ArgumentConfig{
+				Type: dateType,
+			},
+			"end_date": &graphql.ArgumentConfig{
+				Type: dateType,
+			},
+			"done": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"parent": &graphql.ArgumentConfig{
+				Type:        graphql.ID,
+				Description: "The task to add this as a subtask of",
+			},
+			"auto_complete": &graphql.ArgumentConfig{
+				Type:        graphql.Boolean,
+				Description: "Mark the task done once all its subtasks are",
+			},
+			"project": &graphql.ArgumentConfig{
+				Type:        graphql.ID,
+				Description: "The project to add the task to",
+			},
+			"priority": &graphql.ArgumentConfig{
+				Type: priority,
+			},
+			"recurrence": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "RFC 5545 RRULE to repeat the task by, such as \"FREQ=MONTHLY;BYDAY=2TU\", or empty to stop repeating",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			newTask := newTaskOfArgs(p.Args)
+			if err := db.AddTask(newTask, userIdOfContext(p)); err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return newTask, nil
+		},
+	}
+
+	addProjectMutation := &graphql.Field{
+		Type: projectType,
+		Args: graphql.FieldConfigArgument{
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"color": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"position": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "Defaults to after every other project",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			name, _ := p.Args["name"].(string)
+			color, _ := p.Args["color"].(string)
+			position, _ := p.Args["position"].(int)
+
+			project := &Project{
+				Name:     name,
+				Color:    color,
+				Position: position,
+			}
+			if err := db.AddProject(project, userIdOfContext(p)); err != nil {
+				return nil, err
 			}
-			return newTask, nil
+			return project, nil
+		},
+	}
+
+	updateProjectMutation := &graphql.Field{
+		Type: projectType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"color": &graphql.ArgumentConfig{
+				Type: graphql.String,
+			},
+			"archived": &graphql.ArgumentConfig{
+				Type: graphql.Boolean,
+			},
+			"position": &graphql.ArgumentConfig{
+				Type: graphql.Int,
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			attrs := make(map[string]interface{})
+			for _, name := range []string{"name", "color", "archived", "position"} {
+				if value, ok := p.Args[name]; ok {
+					attrs[name] = value
+				}
+			}
+			return db.UpdateProject(id, userIdOfContext(p), attrs)
+		},
+	}
+
+	deleteProjectMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "removeProjectPayload",
+			Fields: graphql.Fields{
+				"deletedId": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			deleted, err := db.DeleteProject(id, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			if !deleted {
+				return nil, nil
+			}
+			return id, nil
+		},
+		Description: "Deletes a project and moves its tasks and habits out of it",
+	}
+
+	addTagMutation := &graphql.Field{
+		Type: tagType,
+		Args: graphql.FieldConfigArgument{
+			"taskId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			name, _ := p.Args["name"].(string)
+			tag, err := db.AddTag(taskId, name, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return tag, nil
+		},
+		Description: "Tags a task or habit, creating the tag if it doesn't exist yet",
+	}
+
+	removeTagMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "removeTagPayload",
+			Fields: graphql.Fields{
+				"removed": &graphql.Field{
+					Type:        graphql.Boolean,
+					Description: "Whether the task or habit had the tag",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"taskId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			name, _ := p.Args["name"].(string)
+			removed, err := db.RemoveTag(taskId, name, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return removed, nil
+		},
+		Description: "Removes a tag from a task or habit. The tag itself is kept",
+	}
+
+	addDependencyMutation := &graphql.Field{
+		Type: taskType,
+		Args: graphql.FieldConfigArgument{
+			"taskId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"blockedBy": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "The task that must be done first",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			blockedBy, _ := p.Args["blockedBy"].(string)
+			userId := userIdOfContext(p)
+			if err := db.AddDependency(taskId, blockedBy, userId); err != nil {
+				return nil, err
+			}
+			task, err := db.GetTask(taskId, userId, nil)
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return task, nil
+		},
+		Description: "Blocks a task by another task until that one is done. Fails if it would make a cycle",
+	}
+
+	removeDependencyMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "removeDependencyPayload",
+			Fields: graphql.Fields{
+				"removed": &graphql.Field{
+					Type:        graphql.Boolean,
+					Description: "Whether the task was blocked by the other task",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"taskId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"blockedBy": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			blockedBy, _ := p.Args["blockedBy"].(string)
+			removed, err := db.RemoveDependency(taskId, blockedBy, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return removed, nil
+		},
+		Description: "Stops a task being blocked by another task",
+	}
+
+	quickAddMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "quickAddPayload",
+			Fields: graphql.Fields{
+				"task": &graphql.Field{
+					Type:        taskType,
+					Description: "The added task, or null if a habit was added",
+				},
+				"habit": &graphql.Field{
+					Type:        habitType,
+					Description: "The added habit, or null if a task was added",
+				},
+				"interpretation": &graphql.Field{
+					Type: graphql.NewObject(graphql.ObjectConfig{
+						Name:        "QuickAddInterpretation",
+						Description: "How quick-add text was understood",
+						Fields: graphql.Fields{
+							"kind": &graphql.Field{
+								Type: kind,
+							},
+							"title": &graphql.Field{
+								Type: graphql.String,
+							},
+							"end_date": &graphql.Field{
+								Type: dateType,
+							},
+							"recurrence": &graphql.Field{
+								Type:        graphql.String,
+								Description: "RRULE the task repeats by, or empty if it doesn't repeat",
+							},
+							"priority": &graphql.Field{
+								Type: priority,
+							},
+							"interval": &graphql.Field{
+								Type: interval,
+							},
+							"frequency": &graphql.Field{
+								Type: graphql.Int,
+							},
+							"tags": &graphql.Field{
+								Type: graphql.NewList(graphql.String),
+							},
+						},
+					}),
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"text": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "Such as \"Pay rent every month on the 1st #finance !high\" or \"Run 3 times a week\"",
+			},
+			"timezone": &graphql.ArgumentConfig{
+				Type:         graphql.String,
+				DefaultValue: "UTC",
+				Description:  "IANA time zone dates in the text are in, such as \"America/Toronto\"",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			text, _ := p.Args["text"].(string)
+			timezone, _ := p.Args["timezone"].(string)
+			loc, err := time.LoadLocation(timezone)
+			if err != nil {
+				return nil, err
+			}
+			task, quick, err := QuickAddTask(db, userIdOfContext(p), text, time.Now().In(loc))
+			if err != nil {
+				return nil, err
+			}
+			payload := map[string]interface{}{
+				"interpretation": quick,
+			}
+			if task.Kind == HabitEnum {
+				payload["habit"] = task
+			} else {
+				payload["task"] = task
+			}
+			return payload, nil
+		},
+		Description: "Adds a task or habit described in plain words, with its dates, recurrence, tags and priority",
+	}
+
+	renameTagMutation := &graphql.Field{
+		Type: tagType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"name": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			name, _ := p.Args["name"].(string)
+			tag, err := db.RenameTag(id, name, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return tag, nil
+		},
+		Description: "Renames a tag on every task and habit that has it",
+	}
+
+	mergeTagsMutation := &graphql.Field{
+		Type: tagType,
+		Args: graphql.FieldConfigArgument{
+			"sourceId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"targetId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			sourceId, _ := p.Args["sourceId"].(string)
+			targetId, _ := p.Args["targetId"].(string)
+			tag, err := db.MergeTags(sourceId, targetId, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return tag, nil
+		},
+		Description: "Replaces the source tag with the target tag everywhere and deletes the source tag",
+	}
+
+	deleteTagMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "deleteTagPayload",
+			Fields: graphql.Fields{
+				"deletedId": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			deleted, err := db.DeleteTag(id, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			if !deleted {
+				return nil, nil
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return id, nil
+		},
+		Description: "Deletes a tag and removes it from every task and habit",
+	}
+
+	addReminderMutation := &graphql.Field{
+		Type: reminderType,
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.ID,
+			},
+			"taskId": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"at": &graphql.ArgumentConfig{
+				Type:        dateType,
+				Description: "When to fire. Reminders of habits fire again every interval after it",
+			},
+			"offset_minutes": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "How many minutes before the end date of the task to fire",
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			taskId, _ := p.Args["taskId"].(string)
+			reminder := &Reminder{
+				Id:     id,
+				TaskId: taskId,
+			}
+			if at, ok := p.Args["at"].(*time.Time); ok {
+				reminder.At = at
+			}
+			if offset, ok := p.Args["offset_minutes"].(int); ok {
+				reminder.OffsetMinutes = &offset
+			}
+			if err := db.AddReminder(reminder, userIdOfContext(p)); err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return reminder, nil
+		},
+		Description: "Adds a reminder to a task or habit, at a time or a number of minutes before the end date of a task",
+	}
+
+	deleteReminderMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "deleteReminderPayload",
+			Fields: graphql.Fields{
+				"deletedId": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			deleted, err := db.DeleteReminder(id, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			if !deleted {
+				return nil, nil
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return id, nil
 		},
 	}
 
@@ -313,6 +1771,10 @@ func GetSchema(db Database) *graphql.Schema {
 			"title": &graphql.ArgumentConfig{
 				Type: graphql.NewNonNull(graphql.String),
 			},
+			"notes": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "Long-form description in Markdown",
+			},
 			"interval": &graphql.ArgumentConfig{
 				Type: graphql.NewNonNull(interval),
 			},
@@ -322,10 +1784,15 @@ func GetSchema(db Database) *graphql.Schema {
 			"done": &graphql.ArgumentConfig{
 				Type: graphql.Boolean,
 			},
+			"project": &graphql.ArgumentConfig{
+				Type:        graphql.ID,
+				Description: "The project to add the habit to",
+			},
 		},
 		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
 			id, _ := p.Args["id"].(string)
 			title, _ := p.Args["title"].(string)
+			notes, _ := p.Args["notes"].(string)
 			interval, _ := p.Args["interval"].(Interval)
 			frequency, _ := p.Args["frequency"].(int)
 			done, _ := p.Args["done"].(bool)
@@ -333,11 +1800,15 @@ func GetSchema(db Database) *graphql.Schema {
 			newTask := &Task{
 				Id:        id,
 				Title:     title,
+				Notes:     notes,
 				Interval:  interval,
 				Frequency: frequency,
 				Done:      done,
 				Kind:      HabitEnum,
 			}
+			if project, ok := p.Args["project"].(string); ok && project != "" {
+				newTask.ProjectId = &project
+			}
 
 			if err := db.AddTask(newTask, userIdOfContext(p)); err != nil {
 				return nil, err
@@ -372,11 +1843,102 @@ func GetSchema(db Database) *graphql.Schema {
 			if !taskDeleted {
 				return nil, nil
 			}
+			taskLoaderOfContext(p, db).Clear()
 			return id, nil
 		},
 		Description: "Deletes a task or habit by ID",
 	}
 
+	restoreTaskMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "restoreTaskPayload",
+			Fields: graphql.Fields{
+				"restoredId": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(*Task).Id, nil
+					},
+				},
+				"task": &graphql.Field{
+					Type: taskType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if task := p.Source.(*Task); task.Kind == TaskEnum {
+							return task, nil
+						}
+						return nil, nil
+					},
+				},
+				"habit": &graphql.Field{
+					Type: habitType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if task := p.Source.(*Task); task.Kind == HabitEnum {
+							return task, nil
+						}
+						return nil, nil
+					},
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			task, err := db.RestoreTask(id, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return task, nil
+		},
+		Description: "Restores a deleted task or habit from the trash",
+	}
+
+	purgeTaskMutation := &graphql.Field{
+		Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "purgeTaskPayload",
+			Fields: graphql.Fields{
+				"purgedId": &graphql.Field{
+					Type: graphql.ID,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source, nil
+					},
+				},
+			},
+		}),
+		Args: graphql.FieldConfigArgument{
+			"id": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			id, _ := p.Args["id"].(string)
+			taskPurged, err := db.PurgeTask(id, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			if !taskPurged {
+				return nil, nil
+			}
+			return id, nil
+		},
+		Description: "Permanently deletes a task or habit that is in the trash",
+	}
+
+	// Clears the request's loader after a successful update, so later fields see the changes
+	resolveUpdate := func(resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+		return func(p graphql.ResolveParams) (interface{}, error) {
+			item, err := resolve(p)
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return item, nil
+		}
+	}
+
 	updateTaskMutation := &graphql.Field{
 		Type: taskType,
 		Args: graphql.FieldConfigArgument{
@@ -386,6 +1948,14 @@ func GetSchema(db Database) *graphql.Schema {
 			"title": &graphql.ArgumentConfig{
 				Type: graphql.String,
 			},
+			"notes": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "Long-form description in Markdown",
+			},
+			"expectedVersion": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "Fail with a conflict instead of updating if the version has changed",
+			},
 			"start_date": &graphql.ArgumentConfig{
 				Type: dateType,
 			},
@@ -395,27 +1965,30 @@ func GetSchema(db Database) *graphql.Schema {
 			"done": &graphql.ArgumentConfig{
 				Type: graphql.Boolean,
 			},
+			"parent": &graphql.ArgumentConfig{
+				Type:        graphql.ID,
+				Description: "The task to move this under, or an empty ID to move it to the top level",
+			},
+			"auto_complete": &graphql.ArgumentConfig{
+				Type:        graphql.Boolean,
+				Description: "Mark the task done once all its subtasks are",
+			},
+			"project": &graphql.ArgumentConfig{
+				Type:        graphql.ID,
+				Description: "The project to move the task to, or an empty ID to move it out of its project",
+			},
+			"priority": &graphql.ArgumentConfig{
+				Type: priority,
+			},
+			"recurrence": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "RFC 5545 RRULE to repeat the task by, such as \"FREQ=MONTHLY;BYDAY=2TU\", or empty to stop repeating",
+			},
 		},
-		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		Resolve: resolveUpdate(func(p graphql.ResolveParams) (interface{}, error) {
 			id, _ := p.Args["id"].(string)
-
-			attrs := make(map[string]interface{})
-
-			if title, ok := p.Args["title"].(string); ok {
-				attrs["title"] = title
-			}
-			if startDate, ok := p.Args["start_date"].(*time.Time); ok {
-				attrs["start_date"] = startDate
-			}
-			if endDate, ok := p.Args["end_date"].(*time.Time); ok {
-				attrs["end_date"] = endDate
-			}
-			if done, ok := p.Args["done"].(bool); ok {
-				attrs["done"] = done
-			}
-
-			return db.UpdateTask(id, userIdOfContext(p), attrs)
-		},
+			return db.UpdateTask(id, userIdOfContext(p), taskAttrsOfArgs(p.Args), expectedVersionOfArgs(p.Args))
+		}),
 	}
 
 	updateHabitMutation := &graphql.Field{
@@ -427,6 +2000,14 @@ func GetSchema(db Database) *graphql.Schema {
 			"title": &graphql.ArgumentConfig{
 				Type: graphql.String,
 			},
+			"notes": &graphql.ArgumentConfig{
+				Type:        graphql.String,
+				Description: "Long-form description in Markdown",
+			},
+			"expectedVersion": &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "Fail with a conflict instead of updating if the version has changed",
+			},
 			"interval": &graphql.ArgumentConfig{
 				Type: interval,
 			},
@@ -436,27 +2017,59 @@ func GetSchema(db Database) *graphql.Schema {
 			"done": &graphql.ArgumentConfig{
 				Type: graphql.Boolean,
 			},
+			"project": &graphql.ArgumentConfig{
+				Type:        graphql.ID,
+				Description: "The project to move the habit to, or an empty ID to move it out of its project",
+			},
 		},
-		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		Resolve: resolveUpdate(func(p graphql.ResolveParams) (interface{}, error) {
 			id, _ := p.Args["id"].(string)
+			return db.UpdateTask(id, userIdOfContext(p), taskAttrsOfArgs(p.Args), expectedVersionOfArgs(p.Args))
+		}),
+	}
 
-			attrs := make(map[string]interface{})
+	// Moves a task or habit in the manual order
+	moveTaskArgs := graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.ID),
+		},
+		"before": &graphql.ArgumentConfig{
+			Type:        graphql.ID,
+			Description: "The item to place this right before",
+		},
+		"after": &graphql.ArgumentConfig{
+			Type:        graphql.ID,
+			Description: "The item to place this right after",
+		},
+		"expectedVersion": &graphql.ArgumentConfig{
+			Type:        graphql.Int,
+			Description: "Fail with a conflict instead of moving if the version has changed",
+		},
+	}
+	resolveMove := func(p graphql.ResolveParams) (interface{}, error) {
+		id, _ := p.Args["id"].(string)
+		var before, after *string
+		if id, ok := p.Args["before"].(string); ok {
+			before = &id
+		}
+		if id, ok := p.Args["after"].(string); ok {
+			after = &id
+		}
+		return MoveTask(db, id, userIdOfContext(p), before, after, expectedVersionOfArgs(p.Args))
+	}
 
-			if title, ok := p.Args["title"].(string); ok {
-				attrs["title"] = title
-			}
-			if interval, ok := p.Args["interval"].(Interval); ok {
-				attrs["interval"] = interval
-			}
-			if frequency, ok := p.Args["frequency"].(int); ok {
-				attrs["frequency"] = frequency
-			}
-			if done, ok := p.Args["done"].(bool); ok {
-				attrs["done"] = done
-			}
+	moveTaskMutation := &graphql.Field{
+		Type:        taskType,
+		Args:        moveTaskArgs,
+		Resolve:     resolveUpdate(resolveMove),
+		Description: "Moves a task in the manual order. Only the moved task is updated",
+	}
 
-			return db.UpdateTask(id, userIdOfContext(p), attrs)
-		},
+	moveHabitMutation := &graphql.Field{
+		Type:        habitType,
+		Args:        moveTaskArgs,
+		Resolve:     resolveUpdate(resolveMove),
+		Description: "Moves a habit in the manual order. Only the moved habit is updated",
 	}
 
 	addActionMutation := &graphql.Field{
@@ -491,10 +2104,57 @@ func GetSchema(db Database) *graphql.Schema {
 			if err := db.AddAction(newAction, userIdOfContext(p)); err != nil {
 				return nil, err
 			}
+			taskLoaderOfContext(p, db).Clear()
 			return newAction, nil
 		},
 	}
 
+	timerArgs := graphql.FieldConfigArgument{
+		"taskId": &graphql.ArgumentConfig{
+			Type: graphql.NewNonNull(graphql.ID),
+		},
+		"when": &graphql.ArgumentConfig{
+			Type:        dateType,
+			Description: "Defaults to now",
+		},
+	}
+	timerTimeOfArgs := func(args map[string]interface{}) time.Time {
+		if when, ok := args["when"].(*time.Time); ok && when != nil {
+			return *when
+		}
+		return time.Now()
+	}
+
+	startTimerMutation := &graphql.Field{
+		Type:        actionType,
+		Args:        timerArgs,
+		Description: "Starts a timer on a task or habit, stopping the user's other running timer",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			action, err := StartTimer(db, taskId, userIdOfContext(p), timerTimeOfArgs(p.Args))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return action, nil
+		},
+	}
+
+	stopTimerMutation := &graphql.Field{
+		Type:        actionType,
+		Args:        timerArgs,
+		Description: "Stops the running timer of a task or habit",
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			taskId, _ := p.Args["taskId"].(string)
+			action, err := StopTimer(db, taskId, userIdOfContext(p), timerTimeOfArgs(p.Args))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			return action, nil
+		},
+	}
+
 	deleteActionMutation := &graphql.Field{
 		Type: graphql.NewObject(graphql.ObjectConfig{
 			Name: "removeActionPayload",
@@ -518,31 +2178,337 @@ func GetSchema(db Database) *graphql.Schema {
 			if err := db.DeleteAction(id, userIdOfContext(p)); err != nil {
 				return nil, err
 			}
+			taskLoaderOfContext(p, db).Clear()
 			return id, nil
 		},
 	}
 
+	newTaskInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "NewTask",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"id": &graphql.InputObjectFieldConfig{
+				Type: graphql.ID,
+			},
+			"title": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.String),
+			},
+			"notes": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"start_date": &graphql.InputObjectFieldConfig{
+				Type: dateType,
+			},
+			"end_date": &graphql.InputObjectFieldConfig{
+				Type: dateType,
+			},
+			"done": &graphql.InputObjectFieldConfig{
+				Type: graphql.Boolean,
+			},
+			"parent": &graphql.InputObjectFieldConfig{
+				Type: graphql.ID,
+			},
+			"auto_complete": &graphql.InputObjectFieldConfig{
+				Type: graphql.Boolean,
+			},
+			"project": &graphql.InputObjectFieldConfig{
+				Type: graphql.ID,
+			},
+			"priority": &graphql.InputObjectFieldConfig{
+				Type: priority,
+			},
+			"recurrence": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	taskUpdateInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "TaskUpdate",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"id": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"title": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"notes": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+			"expectedVersion": &graphql.InputObjectFieldConfig{
+				Type:        graphql.Int,
+				Description: "Fail with a conflict instead of updating if the version has changed",
+			},
+			"start_date": &graphql.InputObjectFieldConfig{
+				Type: dateType,
+			},
+			"end_date": &graphql.InputObjectFieldConfig{
+				Type: dateType,
+			},
+			"done": &graphql.InputObjectFieldConfig{
+				Type: graphql.Boolean,
+			},
+			"parent": &graphql.InputObjectFieldConfig{
+				Type: graphql.ID,
+			},
+			"auto_complete": &graphql.InputObjectFieldConfig{
+				Type: graphql.Boolean,
+			},
+			"project": &graphql.InputObjectFieldConfig{
+				Type: graphql.ID,
+			},
+			"priority": &graphql.InputObjectFieldConfig{
+				Type: priority,
+			},
+			"recurrence": &graphql.InputObjectFieldConfig{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	newActionInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "NewAction",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"id": &graphql.InputObjectFieldConfig{
+				Type: graphql.ID,
+			},
+			"taskId": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(graphql.ID),
+			},
+			"kind": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(actionKind),
+			},
+			"when": &graphql.InputObjectFieldConfig{
+				Type: graphql.NewNonNull(dateType),
+			},
+		},
+	})
+
+	// The item of a batch mutation's result is null if the item failed, in which case error says why
+	taskResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TaskResult",
+		Fields: graphql.Fields{
+			"task": &graphql.Field{
+				Type: taskType,
+			},
+			"error": &graphql.Field{
+				Type: graphql.String,
+			},
+			"current": &graphql.Field{
+				Type:        taskType,
+				Description: "The server's copy if the update failed because expectedVersion was out of date",
+			},
+		},
+	})
+
+	deleteTaskResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "DeleteTaskResult",
+		Fields: graphql.Fields{
+			"deletedId": &graphql.Field{
+				Type: graphql.ID,
+			},
+			"error": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	actionResultType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "ActionResult",
+		Fields: graphql.Fields{
+			"action": &graphql.Field{
+				Type: actionType,
+			},
+			"error": &graphql.Field{
+				Type: graphql.String,
+			},
+		},
+	})
+
+	addTasksMutation := &graphql.Field{
+		Type: graphql.NewList(taskResultType),
+		Args: graphql.FieldConfigArgument{
+			"tasks": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(newTaskInput))),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			inputs, _ := p.Args["tasks"].([]interface{})
+			tasks := make([]*Task, len(inputs))
+			for i, input := range inputs {
+				args, _ := input.(map[string]interface{})
+				tasks[i] = newTaskOfArgs(args)
+			}
+
+			errs, err := db.AddTasks(tasks, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			results := make([]map[string]interface{}, len(tasks))
+			for i, task := range tasks {
+				results[i] = batchResult("task", task, errs[i])
+			}
+			return results, nil
+		},
+		Description: "Adds several tasks in one transaction. Each task that fails is skipped",
+	}
+
+	updateTasksMutation := &graphql.Field{
+		Type: graphql.NewList(taskResultType),
+		Args: graphql.FieldConfigArgument{
+			"tasks": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(taskUpdateInput))),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			inputs, _ := p.Args["tasks"].([]interface{})
+			updates := make([]TaskUpdate, len(inputs))
+			for i, input := range inputs {
+				args, _ := input.(map[string]interface{})
+				id, _ := args["id"].(string)
+				updates[i] = TaskUpdate{
+					TaskId:          id,
+					Attrs:           taskAttrsOfArgs(args),
+					ExpectedVersion: expectedVersionOfArgs(args),
+				}
+			}
+
+			tasks, errs, err := db.UpdateTasks(updates, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			results := make([]map[string]interface{}, len(tasks))
+			for i, task := range tasks {
+				results[i] = batchResult("task", task, errs[i])
+				if conflict, ok := errs[i].(*ConflictError); ok {
+					results[i]["current"] = conflict.Current
+				}
+			}
+			return results, nil
+		},
+		Description: "Updates several tasks in one transaction. Each update that fails is skipped",
+	}
+
+	deleteTasksMutation := &graphql.Field{
+		Type: graphql.NewList(deleteTaskResultType),
+		Args: graphql.FieldConfigArgument{
+			"ids": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.ID))),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			inputs, _ := p.Args["ids"].([]interface{})
+			ids := make([]string, len(inputs))
+			for i, input := range inputs {
+				ids[i], _ = input.(string)
+			}
+
+			errs, err := db.DeleteTasks(ids, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			results := make([]map[string]interface{}, len(ids))
+			for i, id := range ids {
+				results[i] = batchResult("deletedId", id, errs[i])
+			}
+			return results, nil
+		},
+		Description: "Deletes several tasks or habits by ID in one transaction",
+	}
+
+	addActionsMutation := &graphql.Field{
+		Type: graphql.NewList(actionResultType),
+		Args: graphql.FieldConfigArgument{
+			"actions": &graphql.ArgumentConfig{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(newActionInput))),
+			},
+		},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			inputs, _ := p.Args["actions"].([]interface{})
+			actions := make([]*Action, len(inputs))
+			for i, input := range inputs {
+				args, _ := input.(map[string]interface{})
+				id, _ := args["id"].(string)
+				taskId, _ := args["taskId"].(string)
+				kind, _ := args["kind"].(ActionKind)
+				when, _ := args["when"].(*time.Time)
+
+				actions[i] = &Action{
+					Id:     id,
+					Kind:   kind,
+					When:   when,
+					TaskId: taskId,
+				}
+			}
+
+			errs, err := db.AddActions(actions, userIdOfContext(p))
+			if err != nil {
+				return nil, err
+			}
+			taskLoaderOfContext(p, db).Clear()
+			results := make([]map[string]interface{}, len(actions))
+			for i, action := range actions {
+				results[i] = batchResult("action", action, errs[i])
+			}
+			return results, nil
+		},
+		Description: "Adds several actions in one transaction. Each action that fails is skipped",
+	}
+
 	queryType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "RootQuery",
 		Fields: graphql.Fields{
-			"task":   taskQuery,
-			"tasks":  tasksQuery,
-			"habit":  habitQuery,
-			"habits": habitsQuery,
-			"user":   userQuery,
+			"task":             taskQuery,
+			"tasks":            tasksQuery,
+			"tasksConnection":  tasksConnectionQuery,
+			"habit":            habitQuery,
+			"habits":           habitsQuery,
+			"habitsConnection": habitsConnectionQuery,
+			"user":             userQuery,
+			"trash":            trashQuery,
+			"project":          projectQuery,
+			"projects":         projectsQuery,
+			"tags":             tagsQuery,
+			"history":          historyQuery,
+			"occurrences":      occurrencesQuery,
 		},
 	})
 
 	mutationType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "RootMutation",
 		Fields: graphql.Fields{
-			"addTask":      addTaskMutation,
-			"deleteTask":   deleteTaskMutation,
-			"updateTask":   updateTaskMutation,
-			"addHabit":     addHabitMutation,
-			"updateHabit":  updateHabitMutation,
-			"addAction":    addActionMutation,
-			"deleteAction": deleteActionMutation,
+			"addTask":          addTaskMutation,
+			"deleteTask":       deleteTaskMutation,
+			"restoreTask":      restoreTaskMutation,
+			"purgeTask":        purgeTaskMutation,
+			"updateTask":       updateTaskMutation,
+			"addHabit":         addHabitMutation,
+			"quickAdd":         quickAddMutation,
+			"updateHabit":      updateHabitMutation,
+			"moveTask":         moveTaskMutation,
+			"moveHabit":        moveHabitMutation,
+			"addAction":        addActionMutation,
+			"deleteAction":     deleteActionMutation,
+			"startTimer":       startTimerMutation,
+			"stopTimer":        stopTimerMutation,
+			"addTasks":         addTasksMutation,
+			"updateTasks":      updateTasksMutation,
+			"deleteTasks":      deleteTasksMutation,
+			"addActions":       addActionsMutation,
+			"addProject":       addProjectMutation,
+			"updateProject":    updateProjectMutation,
+			"deleteProject":    deleteProjectMutation,
+			"addTag":           addTagMutation,
+			"removeTag":        removeTagMutation,
+			"renameTag":        renameTagMutation,
+			"mergeTags":        mergeTagsMutation,
+			"deleteTag":        deleteTagMutation,
+			"addDependency":    addDependencyMutation,
+			"removeDependency": removeDependencyMutation,
+			"addReminder":      addReminderMutation,
+			"deleteReminder":   deleteReminderMutation,
 		},
 	})
 
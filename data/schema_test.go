@@ -0,0 +1,40 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"golang.org/x/net/context"
+)
+
+func TestGetSchema(t *testing.T) {
+	db := NewMemoryDatabase()
+	user, err := db.CreateUser("test", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := GetSchema(db)
+	run := func(query string) string {
+		ctx := context.WithValue(context.Background(), UserIdKey, user.Id)
+		ctx = WithTaskLoader(ctx, NewTaskLoader(db, user.Id))
+		result := graphql.Do(graphql.Params{Schema: *schema, RequestString: query, Context: ctx})
+		if len(result.Errors) > 0 {
+			t.Fatalf("%s failed: %v", query, result.Errors)
+		}
+		data, err := json.Marshal(result.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	run(`mutation { addTask(title: "Write tests", notes: "*soon*") { id } addHabit(title: "Run", interval: WEEKLY, frequency: 3) { id } }`)
+	got := run(`{ tasks { title notes_html } habits { title interval frequency } }`)
+	var want interface{}
+	json.Unmarshal([]byte(`{"habits":[{"frequency":3,"interval":"WEEKLY","title":"Run"}],"tasks":[{"notes_html":"<p><em>soon</em></p>\n","title":"Write tests"}]}`), &want)
+	// Marshalled the same way as the result, so both escape HTML
+	if wantJSON, _ := json.Marshal(want); got != string(wantJSON) {
+		t.Errorf("Got %s, want %s", got, wantJSON)
+	}
+}
@@ -0,0 +1,201 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The helpers below are shared by the backends, which call them with a Database that is part of
+// their own transaction, and by the wrappers, which use them to find the tasks a change cascaded to.
+
+// Checks that the task may be moved under the parent: only tasks can be subtasks or have them,
+// the parent must be a live task of the same user, and the task can't end up above itself.
+func validateParent(db Database, task *Task, parentId string, userId uint64) error {
+	if task.Kind != TaskEnum {
+		return fmt.Errorf("Only tasks can be subtasks")
+	}
+	if parentId == task.Id {
+		return fmt.Errorf("Task ID \"%s\" can't be its own parent", parentId)
+	}
+	kind := TaskEnum
+	parent, err := db.GetTask(parentId, userId, &kind)
+	if err != nil {
+		return fmt.Errorf("Parent task ID \"%s\" does not exist for user \"%d\"", parentId, userId)
+	}
+	if task.Id == "" {
+		return nil
+	}
+	ancestors, err := ancestorsOf(db, userId, parent.ParentId)
+	if err != nil {
+		return err
+	}
+	for _, ancestor := range ancestors {
+		if ancestor.Id == task.Id {
+			return fmt.Errorf("Task ID \"%s\" can't be moved under its own subtask \"%s\"", task.Id, parentId)
+		}
+	}
+	return nil
+}
+
+// Checks the parent that the attributes of an update move the task under, if any.
+func validateParentAttr(db Database, task *Task, attrs map[string]interface{}, userId uint64) error {
+	parentId, ok := attrs["parent_id"].(*string)
+	if !ok || parentId == nil {
+		return nil
+	}
+	return validateParent(db, task, *parentId, userId)
+}
+
+// Returns the live tasks above each of the given parents, nearest first and without duplicates.
+func ancestorsOf(db Database, userId uint64, parentIds ...*string) ([]Task, error) {
+	ancestors := []Task{}
+	visited := make(map[string]bool)
+	for _, parentId := range parentIds {
+		for parentId != nil && !visited[*parentId] {
+			visited[*parentId] = true
+			parent, err := db.GetTask(*parentId, userId, nil)
+			if err == gorm.ErrRecordNotFound {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			ancestors = append(ancestors, *parent)
+			parentId = parent.ParentId
+		}
+	}
+	return ancestors, nil
+}
+
+// Returns the live subtasks of a task, their subtasks and so on, parents before children.
+func liveDescendants(db Database, taskId string, userId uint64) ([]Task, error) {
+	descendants := []Task{}
+	visited := map[string]bool{taskId: true}
+	queue := []string{taskId}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		page, err := db.GetTasks(userId, TaskQuery{ParentId: &id})
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range page.Tasks {
+			if !visited[child.Id] {
+				visited[child.Id] = true
+				descendants = append(descendants, child)
+				queue = append(queue, child.Id)
+			}
+		}
+	}
+	return descendants, nil
+}
+
+// Returns the descendants of a task in the trash that were moved there along with it, parents
+// before children. Subtasks deleted on their own before their parent are left out.
+func trashedDescendants(db Database, taskId string, userId uint64) ([]Task, error) {
+	deleted, err := db.GetDeletedTasks(userId)
+	if err != nil {
+		return nil, err
+	}
+	var root *Task
+	children := make(map[string][]Task)
+	for i, task := range deleted {
+		if task.Id == taskId {
+			root = &deleted[i]
+		}
+		if task.ParentId != nil {
+			children[*task.ParentId] = append(children[*task.ParentId], task)
+		}
+	}
+
+	descendants := []Task{}
+	if root == nil {
+		return descendants, nil
+	}
+	visited := map[string]bool{taskId: true}
+	queue := []string{taskId}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, child := range children[id] {
+			if !visited[child.Id] && child.DeletedAt.Equal(*root.DeletedAt) {
+				visited[child.Id] = true
+				descendants = append(descendants, child)
+				queue = append(queue, child.Id)
+			}
+		}
+	}
+	return descendants, nil
+}
+
+// Marks the task done if it completes automatically and every one of its subtasks is done. The
+// update in turn auto-completes the task's parent if this completed its last subtask.
+func autoCompleteTask(db Database, taskId *string, userId uint64) error {
+	if taskId == nil {
+		return nil
+	}
+	task, err := db.GetTask(*taskId, userId, nil)
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if task.Done || !task.AutoComplete {
+		return nil
+	}
+	page, err := db.GetTasks(userId, TaskQuery{ParentId: &task.Id})
+	if err != nil {
+		return err
+	}
+	if len(page.Tasks) == 0 {
+		return nil
+	}
+	for _, subtask := range page.Tasks {
+		if !subtask.Done {
+			return nil
+		}
+	}
+	_, err = db.UpdateTask(task.Id, userId, map[string]interface{}{"done": true}, nil)
+	return err
+}
+
+// Auto-completes the tasks that an update may have completed: the task itself, whose setting or
+// subtasks may have changed, and its old and new parents.
+func autoCompleteAfterUpdate(db Database, before *Task, after *Task, userId uint64) error {
+	if err := autoCompleteTask(db, &after.Id, userId); err != nil {
+		return err
+	}
+	if err := autoCompleteTask(db, after.ParentId, userId); err != nil {
+		return err
+	}
+	if before.ParentId != nil && (after.ParentId == nil || *before.ParentId != *after.ParentId) {
+		return autoCompleteTask(db, before.ParentId, userId)
+	}
+	return nil
+}
+
+// A task that was updated as a side effect of a change to another task.
+type cascadedUpdate struct {
+	before *Task
+	after  *Task
+}
+
+// Returns those of the given tasks, as they were before a change, that the change updated.
+func cascadedUpdates(db Database, before []Task, userId uint64) ([]cascadedUpdate, error) {
+	updates := []cascadedUpdate{}
+	for i := range before {
+		after, err := db.GetTask(before[i].Id, userId, nil)
+		if err == gorm.ErrRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if after.Version != before[i].Version {
+			updates = append(updates, cascadedUpdate{&before[i], after})
+		}
+	}
+	return updates, nil
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// A user-defined label like "@phone" that any number of the user's tasks and habits can have.
+// Names are unique per user.
+type Tag struct {
+	Id        string    `json:"id" gorm:"primary_key;type:uuid"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserId    uint64    `json:"user_id" gorm:"not_null"`
+	Name      string    `json:"name" gorm:"not_null"`
+}
+
+func (tag *Tag) BeforeCreate(scope *gorm.Scope) error {
+	if tag.Id == "" {
+		return scope.SetColumn("Id", newUUID())
+	}
+	return nil
+}
+
+// Links a task or habit to one of its tags.
+type taskTag struct {
+	TaskId string `gorm:"primary_key;type:uuid"`
+	TagId  string `gorm:"primary_key;type:uuid"`
+}
+
+func (taskTag) TableName() string {
+	return "task_tags"
+}
+
+// Returns the name with surrounding whitespace removed, or an error if nothing is left.
+func normalizeTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("Tag must have a name")
+	}
+	return name, nil
+}
+
+func tagNotFound(tagId string, userId uint64) error {
+	return fmt.Errorf("Tag ID \"%s\" does not exist for user \"%d\"", tagId, userId)
+}
+
+func tagNameTaken(name string) error {
+	return fmt.Errorf("Tag \"%s\" already exists, merge the tags instead", name)
+}
+
+// Returns the user's tag with the given ID along with the IDs of the live tasks and habits with it,
+// or a nil tag if the user has no such tag.
+func taggedTasks(db Database, tagId string, userId uint64) (*Tag, []string, error) {
+	tags, err := db.GetTags(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tag := range tags {
+		if tag.Id == tagId {
+			page, err := db.GetTasks(userId, TaskQuery{Tags: []string{tag.Name}})
+			if err != nil {
+				return nil, nil, err
+			}
+			return &tag, idsOfTasks(page.Tasks), nil
+		}
+	}
+	return nil, nil, nil
+}
+
+type byTagName []Tag
+
+func (s byTagName) Len() int           { return len(s) }
+func (s byTagName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTagName) Less(i, j int) bool { return s[i].Name < s[j].Name }
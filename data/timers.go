@@ -0,0 +1,244 @@
+package data
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// A running timer, which is a start action on a task with no stop action after it.
+type Timer struct {
+	TaskId string `json:"task_id"`
+	UserId uint64 `json:"user_id"`
+	// ID of the start action
+	ActionId  string    `json:"action_id"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type byStartedAt []Timer
+
+func (s byStartedAt) Len() int           { return len(s) }
+func (s byStartedAt) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byStartedAt) Less(i, j int) bool { return s[i].StartedAt.Before(s[j].StartedAt) }
+
+// A span of time tracked on a task, from a start action to the stop action after it. Stop is nil
+// while the timer is running.
+type TimeEntry struct {
+	Start time.Time  `json:"start"`
+	Stop  *time.Time `json:"stop"`
+}
+
+// The time tracked on a task on one day.
+type TrackedDay struct {
+	// The day as YYYY-MM-DD
+	Date    string `json:"date"`
+	Seconds int    `json:"seconds"`
+}
+
+type byWhen []Action
+
+func (s byWhen) Len() int      { return len(s) }
+func (s byWhen) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byWhen) Less(i, j int) bool {
+	if !s[i].When.Equal(*s[j].When) {
+		return s[i].When.Before(*s[j].When)
+	}
+	return s[i].Id < s[j].Id
+}
+
+// Pairs each start action of a task with the stop action after it, in order. Starts while the
+// timer is already running and stops while it isn't are ignored.
+func timeEntries(actions []Action) []TimeEntry {
+	sorted := []Action{}
+	for _, action := range actions {
+		if action.When != nil && (action.Kind == ActionStart || action.Kind == ActionStop) {
+			sorted = append(sorted, action)
+		}
+	}
+	sort.Sort(byWhen(sorted))
+
+	entries := []TimeEntry{}
+	running := false
+	for _, action := range sorted {
+		switch {
+		case action.Kind == ActionStart && !running:
+			entries = append(entries, TimeEntry{Start: *action.When})
+			running = true
+		case action.Kind == ActionStop && running:
+			entries[len(entries)-1].Stop = action.When
+			running = false
+		}
+	}
+	return entries
+}
+
+// Returns when the entry ends, which is now if its timer is running.
+func (entry *TimeEntry) end(now time.Time) time.Time {
+	if entry.Stop != nil {
+		return *entry.Stop
+	}
+	if now.Before(entry.Start) {
+		return entry.Start
+	}
+	return now
+}
+
+// Returns the total time tracked by the entries, counting running timers up to now.
+func trackedTime(entries []TimeEntry, now time.Time) time.Duration {
+	var total time.Duration
+	for i := range entries {
+		total += entries[i].end(now).Sub(entries[i].Start)
+	}
+	return total
+}
+
+// Returns the time tracked by the entries on each day in loc, oldest first, counting running timers
+// up to now. Entries that span midnight are split between the days.
+func trackedByDay(entries []TimeEntry, now time.Time, loc *time.Location) []TrackedDay {
+	totals := make(map[string]time.Duration)
+	for i := range entries {
+		end := entries[i].end(now).In(loc)
+		for start := entries[i].Start.In(loc); start.Before(end); {
+			midnight := time.Date(start.Year(), start.Month(), start.Day()+1, 0, 0, 0, 0, loc)
+			if midnight.After(end) {
+				midnight = end
+			}
+			totals[start.Format("2006-01-02")] += midnight.Sub(start)
+			start = midnight
+		}
+	}
+
+	days := []TrackedDay{}
+	for date, total := range totals {
+		days = append(days, TrackedDay{date, int(total / time.Second)})
+	}
+	sort.Sort(byDate(days))
+	return days
+}
+
+type byDate []TrackedDay
+
+func (s byDate) Len() int           { return len(s) }
+func (s byDate) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byDate) Less(i, j int) bool { return s[i].Date < s[j].Date }
+
+// Checks that a start or stop action keeps the task's timer consistent. A timer can only start
+// when the task's isn't running and no earlier started timer of the user's is, and can only stop
+// when it is running. Neither can be placed before the task's last tracked time.
+func validateTimerAction(db Database, action *Action, userId uint64) error {
+	if action.When == nil || action.Kind != ActionStart && action.Kind != ActionStop {
+		return nil
+	}
+	actions, err := db.GetActions([]string{action.TaskId}, userId)
+	if err != nil {
+		return err
+	}
+	entries := timeEntries(actions[action.TaskId])
+	var last *TimeEntry
+	if len(entries) > 0 {
+		last = &entries[len(entries)-1]
+	}
+
+	if action.Kind == ActionStop {
+		if last == nil || last.Stop != nil {
+			return fmt.Errorf("Task \"%s\" has no running timer", action.TaskId)
+		}
+		if action.When.Before(last.Start) {
+			return fmt.Errorf("Timer can't stop before it started")
+		}
+		return nil
+	}
+
+	if last != nil && last.Stop == nil {
+		return fmt.Errorf("Task \"%s\" already has a running timer", action.TaskId)
+	}
+	if last != nil && action.When.Before(*last.Stop) {
+		return fmt.Errorf("Timer can't start before the task's last tracked time ended")
+	}
+	// Timers started later are allowed so that tracked time can be filled in after the fact
+	running, err := db.GetRunningTimers(userId)
+	if err != nil {
+		return err
+	}
+	for _, timer := range running {
+		if !timer.StartedAt.After(*action.When) {
+			return fmt.Errorf("Timer of task \"%s\" is already running", timer.TaskId)
+		}
+	}
+	return nil
+}
+
+// Starts a timer on the task, stopping any other timer of the user's at the same time.
+func StartTimer(db Database, taskId string, userId uint64, when time.Time) (*Action, error) {
+	action := &Action{
+		Kind:   ActionStart,
+		When:   &when,
+		TaskId: taskId,
+	}
+	err := db.WithTx(func(tx Database) error {
+		running, err := tx.GetRunningTimers(userId)
+		if err != nil {
+			return err
+		}
+		for _, timer := range running {
+			if timer.TaskId == taskId {
+				continue
+			}
+			stop := when
+			if stop.Before(timer.StartedAt) {
+				stop = timer.StartedAt
+			}
+			if err := tx.AddAction(&Action{Kind: ActionStop, When: &stop, TaskId: timer.TaskId}, userId); err != nil {
+				return err
+			}
+		}
+		return tx.AddAction(action, userId)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// Stops the task's running timer.
+func StopTimer(db Database, taskId string, userId uint64, when time.Time) (*Action, error) {
+	action := &Action{
+		Kind:   ActionStop,
+		When:   &when,
+		TaskId: taskId,
+	}
+	if err := db.AddAction(action, userId); err != nil {
+		return nil, err
+	}
+	return action, nil
+}
+
+// Stops every timer that has been running for longer than maxDuration, as of maxDuration after it
+// started, and returns how many were stopped.
+func StopForgottenTimers(db Database, maxDuration time.Duration, now time.Time) (int, error) {
+	forgotten, err := db.GetForgottenTimers(now.Add(-maxDuration))
+	if err != nil {
+		return 0, err
+	}
+	for i, timer := range forgotten {
+		if _, err := StopTimer(db, timer.TaskId, timer.UserId, timer.StartedAt.Add(maxDuration)); err != nil {
+			return i, err
+		}
+	}
+	return len(forgotten), nil
+}
+
+// Periodically stops timers that have been running for longer than maxDuration. Runs until the
+// process exits.
+func RunTimerAutoStop(db Database, maxDuration time.Duration, interval time.Duration) {
+	for {
+		stopped, err := StopForgottenTimers(db, maxDuration, time.Now())
+		if err != nil {
+			log.Printf("Error stopping forgotten timers: %s", err)
+		} else if stopped > 0 {
+			log.Printf("Stopped %d timers running for longer than %s", stopped, maxDuration)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,21 @@
+package data
+
+import (
+	"log"
+	"time"
+)
+
+// Periodically purges tasks that have been in the trash for longer than retentionDays, recording
+// the purges in their history. Runs until the process exits.
+func RunTrashRetention(db Database, retentionDays int, interval time.Duration) {
+	db = WithHistory(db, SourceRetention)
+	for {
+		purged, err := db.PurgeDeletedTasks(time.Now().AddDate(0, 0, -retentionDays))
+		if err != nil {
+			log.Printf("Error purging trash: %s", err)
+		} else if len(purged) > 0 {
+			log.Printf("Purged %d tasks older than %d days from the trash", len(purged), retentionDays)
+		}
+		time.Sleep(interval)
+	}
+}
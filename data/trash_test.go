@@ -0,0 +1,180 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrashRestoresSubtasksDeletedTogether(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent := &Task{Title: "parent"}
+		addTestTasks(t, db, user.Id, parent)
+		kept := &Task{Title: "kept", ParentId: &parent.Id}
+		child := &Task{Title: "child", ParentId: &parent.Id}
+		addTestTasks(t, db, user.Id, kept, child)
+
+		if _, err := db.DeleteTask(kept.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		// Deletion times must differ for the tasks to count as deleted separately
+		time.Sleep(10 * time.Millisecond)
+		if _, err := db.DeleteTask(parent.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		trash, err := db.GetDeletedTasks(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titlesOf(trash); len(got) != 3 || got[2] != "kept" {
+			t.Errorf("%T: GetDeletedTasks returned %v, want kept last", db, got)
+		}
+
+		restored, err := db.RestoreTask(parent.Id, user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.DeletedAt != nil {
+			t.Errorf("%T: RestoreTask returned a task that is still deleted", db)
+		}
+		page, err := db.GetTasks(user.Id, TaskQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := titlesOf(page.Tasks), []string{"parent", "child"}; !equalStrings(got, want) {
+			t.Errorf("%T: after restoring, GetTasks returned %v, want %v", db, got, want)
+		}
+		if _, err := db.RestoreTask(parent.Id, user.Id); err == nil {
+			t.Errorf("%T: RestoreTask of a live task succeeded", db)
+		}
+	}
+}
+
+func TestTrashDetachesRestoredSubtasks(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent := &Task{Title: "parent"}
+		addTestTasks(t, db, user.Id, parent)
+		child := &Task{Title: "child", ParentId: &parent.Id}
+		addTestTasks(t, db, user.Id, child)
+		if _, err := db.DeleteTask(child.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if _, err := db.DeleteTask(parent.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		restored, err := db.RestoreTask(child.Id, user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored.ParentId != nil {
+			t.Errorf("%T: a subtask restored without its parent still has parent %s", db, *restored.ParentId)
+		}
+	}
+}
+
+func TestPurgeTask(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		purged := &Task{Title: "purged"}
+		addTestTasks(t, db, user.Id, purged)
+		kept := &Task{Title: "kept", NextId: &purged.Id}
+		addTestTasks(t, db, user.Id, kept)
+		when := time.Now()
+		if err := db.AddAction(&Action{TaskId: purged.Id, When: &when}, user.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		if ok, err := db.PurgeTask(purged.Id, user.Id); err != nil || ok {
+			t.Errorf("%T: PurgeTask of a live task returned %v, %v, want false", db, ok, err)
+		}
+		if _, err := db.DeleteTask(purged.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := db.PurgeTask(purged.Id, user.Id); err != nil || !ok {
+			t.Errorf("%T: PurgeTask returned %v, %v, want true", db, ok, err)
+		}
+
+		trash, err := db.GetDeletedTasks(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(trash) != 0 {
+			t.Errorf("%T: the trash has %v after purging", db, titlesOf(trash))
+		}
+		actions, err := db.GetActions([]string{purged.Id}, user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actions) != 0 {
+			t.Errorf("%T: the actions of a purged task are left: %v", db, actions)
+		}
+		got, err := db.GetTask(kept.Id, user.Id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.NextId != nil {
+			t.Errorf("%T: a task still refers to a purged task as its next occurrence", db)
+		}
+	}
+}
+
+func TestPurgeDeletedTasks(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		old := &Task{Title: "old"}
+		recent := &Task{Title: "recent"}
+		live := &Task{Title: "live"}
+		addTestTasks(t, db, user.Id, old, recent, live)
+		if _, err := db.DeleteTask(old.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		cutoff := time.Now()
+		time.Sleep(10 * time.Millisecond)
+		if _, err := db.DeleteTask(recent.Id, user.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		purged, err := db.PurgeDeletedTasks(cutoff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titlesOf(purged); !equalStrings(got, []string{"old"}) {
+			t.Errorf("%T: PurgeDeletedTasks purged %v, want [old]", db, got)
+		}
+		trash, err := db.GetDeletedTasks(user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titlesOf(trash); !equalStrings(got, []string{"recent"}) {
+			t.Errorf("%T: the trash has %v, want [recent]", db, got)
+		}
+	}
+}
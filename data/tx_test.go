@@ -0,0 +1,105 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithTxRollsBack(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		task := &Task{Title: "before"}
+		addTestTasks(t, db, user.Id, task)
+		if _, err := db.AddTag(task.Id, "tag", user.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		failed := errors.New("failed")
+		err = db.WithTx(func(tx Database) error {
+			if _, err := tx.UpdateTask(task.Id, user.Id, map[string]interface{}{"title": "after"}, nil); err != nil {
+				return err
+			}
+			when := time.Now()
+			if err := tx.AddAction(&Action{TaskId: task.Id, When: &when}, user.Id); err != nil {
+				return err
+			}
+			if _, err := tx.RemoveTag(task.Id, "tag", user.Id); err != nil {
+				return err
+			}
+			if err := tx.AddTask(&Task{Title: "added"}, user.Id); err != nil {
+				return err
+			}
+			return failed
+		})
+		if err != failed {
+			t.Errorf("%T: WithTx returned %v, want %v", db, err, failed)
+		}
+
+		page, err := db.GetTasks(user.Id, TaskQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titlesOf(page.Tasks); !equalStrings(got, []string{"before"}) {
+			t.Errorf("%T: after rolling back, GetTasks returned %v, want [before]", db, got)
+		}
+		actions, err := db.GetActions([]string{task.Id}, user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actions[task.Id]) != 0 {
+			t.Errorf("%T: an action was kept after rolling back", db)
+		}
+		tags, err := db.GetTaskTags([]string{task.Id}, user.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tags[task.Id]) != 1 {
+			t.Errorf("%T: after rolling back, the task has tags %v, want [tag]", db, tags[task.Id])
+		}
+	}
+}
+
+func TestWithTxNestedRollsBackOnlyItsOwnChanges(t *testing.T) {
+	dbs, closeDBs := openTestDatabases(t)
+	defer closeDBs()
+
+	for _, db := range dbs {
+		user, err := db.CreateUser("test", "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.WithTx(func(tx Database) error {
+			if err := tx.AddTask(&Task{Title: "outer"}, user.Id); err != nil {
+				return err
+			}
+			err := tx.WithTx(func(nested Database) error {
+				if err := nested.AddTask(&Task{Title: "nested"}, user.Id); err != nil {
+					return err
+				}
+				return errors.New("failed")
+			})
+			if err == nil {
+				t.Errorf("%T: the nested WithTx succeeded", db)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := db.GetTasks(user.Id, TaskQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titlesOf(page.Tasks); !equalStrings(got, []string{"outer"}) {
+			t.Errorf("%T: GetTasks returned %v, want [outer]", db, got)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/andyzg/duet/data"
+)
+
+// Handles "duet export", which writes a user's data to stdout in the same format as /rest/export.
+func runExport(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: duet export username [json|csv]")
+		os.Exit(2)
+	}
+	format := "json"
+	if len(args) == 2 {
+		format = args[1]
+	}
+
+	db := openDatabase()
+	defer db.Close()
+
+	user, err := db.GetUserByUsername(args[0])
+	if err != nil {
+		log.Fatalf("Finding user \"%s\" failed, %v", args[0], err)
+	}
+	export, err := data.ExportTasks(db, user.Id)
+	if err != nil {
+		log.Fatalf("Export failed, %v", err)
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(export)
+	case "csv":
+		err = export.WriteCSV(os.Stdout)
+	default:
+		log.Fatalf("Unknown format \"%s\", must be json or csv", format)
+	}
+	if err != nil {
+		log.Fatalf("Writing export failed, %v", err)
+	}
+}
+
+// Handles "duet import", which adds the data in a JSON export file to a user's account.
+func runImport(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: duet import username file.json")
+		os.Exit(2)
+	}
+
+	file, err := os.Open(args[1])
+	if err != nil {
+		log.Fatalf("Opening \"%s\" failed, %v", args[1], err)
+	}
+	defer file.Close()
+	export := data.Export{}
+	if err := json.NewDecoder(file).Decode(&export); err != nil {
+		log.Fatalf("Parsing \"%s\" failed, %v", args[1], err)
+	}
+
+	db := openDatabase()
+	defer db.Close()
+
+	user, err := db.GetUserByUsername(args[0])
+	if err != nil {
+		log.Fatalf("Finding user \"%s\" failed, %v", args[0], err)
+	}
+	result, err := data.ImportTasks(data.WithHistory(db, data.SourceCLI), user.Id, &export)
+	if err != nil {
+		log.Fatalf("Import failed, %v", err)
+	}
+	fmt.Printf("Created %d tasks and %d actions, skipped %d tasks and %d actions that already existed\n",
+		result.TasksCreated, result.ActionsCreated, result.TasksSkipped, result.ActionsSkipped)
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/andyzg/duet/data"
@@ -13,10 +15,100 @@ import (
 	"golang.org/x/net/context"
 )
 
+var dialect = flag.String("db", "postgres", "Database backend to use: \"postgres\", \"sqlite3\" or \"memory\"")
+var sqlitePath = flag.String("sqlite-path", "duet.db", "Database file to use with -db=sqlite3")
+var cacheSize = flag.Int("cache-size", 10000, "Number of users, tasks and task lists to cache in memory, or 0 to disable caching")
+var trashRetentionDays = flag.Int("trash-retention-days", 30, "Days to keep deleted tasks in the trash, or 0 to keep them forever")
+var attachmentsDir = flag.String("attachments-dir", "attachments", "Directory to store the files attached to tasks in")
+var attachmentQuotaMB = flag.Int64("attachment-quota-mb", 100, "Total size in megabytes of the files each user can attach")
+var reminderLog = flag.String("reminder-log", "", "File to append due reminders to as lines of JSON, or empty to write them to stderr")
+var timerAutoStopHours = flag.Int("timer-auto-stop-hours", 12, "Hours after which a running timer is stopped, or 0 to let timers run forever")
+
+// Returns the host, user and database name to connect to for the selected dialect.
+func databaseSource() (string, string, string) {
+	if *dialect == "sqlite3" {
+		return "", "", *sqlitePath
+	}
+	return "localhost", "duet", "duet"
+}
+
+func openDatabase() data.Database {
+	if *dialect == "memory" {
+		return data.NewMemoryDatabase()
+	}
+	host, user, dbName := databaseSource()
+	return data.InitDatabase(*dialect, host, user, dbName)
+}
+
+// Returns the broker that carries change events between duet instances sharing the database, or
+// within this instance if the backend can't do that.
+func openBroker() data.Broker {
+	if *dialect != "postgres" {
+		return data.NewMemoryBroker()
+	}
+	host, user, dbName := databaseSource()
+	broker, err := data.NewPostgresBroker(host, user, dbName)
+	if err != nil {
+		log.Fatalf("Opening change feed failed, %v", err)
+	}
+	return broker
+}
+
+// Returns the notifier that delivers reminders.
+func openNotifier() data.Notifier {
+	if *reminderLog == "" {
+		return data.NewLogNotifier(os.Stderr)
+	}
+	file, err := os.OpenFile(*reminderLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		log.Fatalf("Opening reminder log failed, %v", err)
+	}
+	return data.NewLogNotifier(file)
+}
+
 func main() {
-	db := data.InitDatabase("postgres", "localhost", "duet", "duet")
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "migrate":
+		runMigrate(flag.Args()[1:])
+		return
+	case "export":
+		runExport(flag.Args()[1:])
+		return
+	case "import":
+		runImport(flag.Args()[1:])
+		return
+	}
+
+	metrics := data.NewMetrics()
+	db := data.WithMetrics(openDatabase(), metrics)
 	defer db.Close()
 
+	broker := openBroker()
+	defer broker.Close()
+	db = data.WithChanges(db, broker)
+
+	if *cacheSize > 0 {
+		db = data.WithCache(db, data.NewLRUCache(*cacheSize))
+	}
+
+	if *trashRetentionDays > 0 {
+		go data.RunTrashRetention(db, *trashRetentionDays, time.Hour)
+	}
+
+	blobs, err := data.NewLocalBlobStore(*attachmentsDir)
+	if err != nil {
+		log.Fatalf("Opening attachment store failed, %v", err)
+	}
+	go data.RunAttachmentCleanup(db, blobs, time.Hour)
+
+	go data.RunReminders(db, openNotifier(), time.Minute)
+
+	if *timerAutoStopHours > 0 {
+		go data.RunTimerAutoStop(db, time.Duration(*timerAutoStopHours)*time.Hour, 5*time.Minute)
+	}
+
 	graphqlHandler := handler.New(&handler.Config{
 		Schema: data.GetSchema(db),
 		Pretty: true,
@@ -40,6 +132,7 @@ func main() {
 			return
 		}
 		ctx = context.WithValue(ctx, data.UserIdKey, userId)
+		ctx = data.WithTaskLoader(ctx, data.NewTaskLoader(db, userId))
 
 		graphqlHandler.ContextHandler(ctx, w, r)
 	})
@@ -47,19 +140,36 @@ func main() {
 	restApi := rest.NewApi()
 	restApi.Use(rest.DefaultDevStack...)
 
-	restRouter, err := rest.MakeRouter(
+	restRoutes := []*rest.Route{
 		rest.Post("/login", data.ServeLogin(db)),
 		rest.Post("/signup", data.ServeCreateUser(db)),
 		rest.Get("/verify", data.ServeVerifyToken(db)),
-	)
+		rest.Get("/export", data.ServeExport(db)),
+		rest.Post("/import", data.ServeImport(db)),
+	}
+	restRouter, err := rest.MakeRouter(restRoutes...)
 	if err != nil {
 		log.Fatalf("rest.MakeRouter failed, %v", err)
 	}
 	restApi.SetApp(restRouter)
+	restHandler := http.StripPrefix("/rest", restApi.MakeHandler())
 
 	http.HandleFunc("/", graphiql.ServeGraphiQL)
-	http.Handle("/rest/", http.StripPrefix("/rest", restApi.MakeHandler()))
-	http.Handle("/graphql", authGraphqlHandler)
+	// Each REST route is registered separately so its requests are measured separately
+	http.Handle("/rest/", metrics.InstrumentHandler("/rest/", restHandler))
+	restPaths := make(map[string]bool)
+	for _, route := range restRoutes {
+		path := "/rest" + route.PathExp
+		if !restPaths[path] {
+			restPaths[path] = true
+			http.Handle(path, metrics.InstrumentHandler(path, restHandler))
+		}
+	}
+	http.Handle("/graphql", metrics.InstrumentHandler("/graphql", authGraphqlHandler))
+	attachmentsHandler := data.HandleAttachments(db, blobs, *attachmentQuotaMB<<20)
+	http.Handle("/attachments", metrics.InstrumentHandler("/attachments", attachmentsHandler))
+	http.Handle("/attachments/", metrics.InstrumentHandler("/attachments/", attachmentsHandler))
+	http.Handle("/metrics", metrics)
 	http.Handle("/oauth/todoist/login", data.HandleTodoistLogin(db))
 	http.Handle("/oauth/todoist/callback", data.HandleTodoistCallback(db))
 
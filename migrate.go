@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/andyzg/duet/data"
+)
+
+const migrateUsage = "Usage: duet [-db=postgres|sqlite3] migrate up|down [steps]|status"
+
+// Handles "duet migrate", which manages the schema without starting the server.
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+	if *dialect == "memory" {
+		log.Fatalf("The memory database has no schema to migrate")
+	}
+
+	host, user, dbName := databaseSource()
+	db, err := data.OpenDatabase(*dialect, host, user, dbName)
+	if err != nil {
+		log.Fatalf("Opening database failed, %v", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := data.MigrateUp(db)
+		for _, version := range applied {
+			fmt.Printf("Applied migration %d\n", version)
+		}
+		if err != nil {
+			log.Fatalf("migrate up failed, %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps \"%s\"", args[1])
+			}
+		}
+		reverted, err := data.MigrateDown(db, steps)
+		for _, version := range reverted {
+			fmt.Printf("Reverted migration %d\n", version)
+		}
+		if err != nil {
+			log.Fatalf("migrate down failed, %v", err)
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No migrations to revert")
+		}
+	case "status":
+		version, err := data.SchemaVersion(db)
+		if err != nil {
+			log.Fatalf("migrate status failed, %v", err)
+		}
+		statuses, err := data.GetMigrationStatus(db)
+		if err != nil {
+			log.Fatalf("migrate status failed, %v", err)
+		}
+		fmt.Printf("Schema version: %d\n", version)
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-50s %s\n", status.Version, status.Description, applied)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}